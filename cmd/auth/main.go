@@ -3,6 +3,8 @@ package main
 import (
 	"ppAuthService/internal/config"
 	"ppAuthService/internal/logger"
+	"ppAuthService/internal/notifier"
+	"ppAuthService/internal/scheduler"
 	"ppAuthService/internal/server"
 	"ppAuthService/internal/service"
 	"ppAuthService/internal/store"
@@ -13,9 +15,13 @@ func main() {
 	cfg := config.MustNew()
 	lg := logger.MustNew(cfg.Env)
 	store := store.MustNew(lg, &cfg.Store)
-	service := service.MustNew(store, lg, &cfg.Token)
-	server := server.MustNew(service, lg, &cfg.Server)
+	notifier := notifier.MustNew(lg, &cfg.Notifier)
+	service := service.MustNew(store, notifier, lg, cfg)
+	server := server.MustNew(service, lg, cfg)
+	scheduler := scheduler.New(service, lg, &cfg.Scheduler)
 
+	scheduler.Start()
 	server.Start()
+	scheduler.Stop()
 
 }
@@ -0,0 +1,59 @@
+// breachindex builds the breached password index from a corpus in the HIBP formats
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"ppAuthService/pkg/breach"
+	"time"
+)
+
+func main() {
+	corpusPath := flag.String("corpus", "", "HIBP range directory or the file of hashes ordered by hash")
+	indexPath := flag.String("out", "breach.idx", "index file to write")
+	flag.Parse()
+	if *corpusPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*corpusPath, *indexPath); err != nil {
+		log.Fatalf("failed to build index: %v\n", err)
+	}
+}
+
+// run writes the index next to the target and renames it at the end, the temporary file is removed on any error
+func run(corpusPath string, indexPath string) error {
+	start := time.Now()
+	file, err := os.Create(indexPath + ".tmp")
+	if err != nil {
+		return err
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	w, err := breach.NewWriter(file)
+	if err != nil {
+		return err
+	}
+	if err := breach.Build(corpusPath, w); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	// the service never sees a partly written index
+	if err := os.Rename(file.Name(), indexPath); err != nil {
+		return err
+	}
+	renamed = true
+	log.Printf("index %s is built: %d hashes in %s\n", indexPath, w.Len(), time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -9,19 +9,71 @@ import (
 )
 
 type Config struct {
-	Env       string `envconfig:"ENV" default:"local"` // local, dev, prod
-	Server    Server
-	Store     Store
-	Token     Token
-	Scheduler Scheduler
+	Env            string `envconfig:"ENV" default:"local"` // local, dev, prod
+	Server         Server
+	Gateway        Gateway
+	RateLimit      RateLimit
+	Store          Store
+	Token          Token
+	Tenant         Tenant
+	Admin          Admin
+	ServiceAccount ServiceAccount
+	ApiKey         ApiKey
+	OAuth          OAuth
+	LoginGuard     LoginGuard
+	Mfa            Mfa
+	Session        Session
+	Notifier       Notifier
+	Email          Email
+	PasswordReset  PasswordReset
+	PasswordPolicy PasswordPolicy
+	Unregister     Unregister
+	Authz          Authz
+	Rebac          Rebac
+	Scheduler      Scheduler
 }
 type Scheduler struct {
 	TimeoutRemoveRefreshTokens time.Duration `envconfig:"SCHEDULER_TIMEOUT_REMOVE_REFRESH_TOKENS" required:"true"`
+	TimeoutPurgeUsers          time.Duration `envconfig:"SCHEDULER_TIMEOUT_PURGE_USERS" default:"3600s"`
+	TimeoutRemoveOAuthCodes    time.Duration `envconfig:"SCHEDULER_TIMEOUT_REMOVE_OAUTH_CODES" default:"3600s"`
 }
 type Server struct {
 	BindAddr     string        `envconfig:"SERVER_BIND_ADDR" required:"true"`
 	Name         string        `envconfig:"SERVER_NAME" required:"true"`
 	WriteTimeout time.Duration `envconfig:"SERVER_WRITE_TIMEOUT" required:"true"`
+	// X-Forwarded-For is taken into account only for requests from these networks
+	TrustedProxies []string `envconfig:"SERVER_TRUSTED_PROXIES"`
+}
+type Gateway struct {
+	Enabled                  bool          `envconfig:"GATEWAY_ENABLED" default:"false"`
+	BindAddr                 string        `envconfig:"GATEWAY_BIND_ADDR" default:":8080"`
+	ReadTimeout              time.Duration `envconfig:"GATEWAY_READ_TIMEOUT" default:"15s"`
+	WriteTimeout             time.Duration `envconfig:"GATEWAY_WRITE_TIMEOUT" default:"15s"`
+	MaxBodySize              int64         `envconfig:"GATEWAY_MAX_BODY_SIZE" default:"1048576"`
+	CorsAllowedOrigins       []string      `envconfig:"GATEWAY_CORS_ALLOWED_ORIGINS"`
+	CorsAllowedMethods       []string      `envconfig:"GATEWAY_CORS_ALLOWED_METHODS" default:"GET,POST,OPTIONS"`
+	CorsAllowedHeaders       []string      `envconfig:"GATEWAY_CORS_ALLOWED_HEADERS" default:"Authorization,Content-Type"`
+	CorsAllowCredentials     bool          `envconfig:"GATEWAY_CORS_ALLOW_CREDENTIALS" default:"false"`
+	CorsMaxAge               time.Duration `envconfig:"GATEWAY_CORS_MAX_AGE" default:"600s"`
+	RefreshTokenCookie       bool          `envconfig:"GATEWAY_REFRESH_TOKEN_COOKIE" default:"false"`
+	RefreshTokenCookieName   string        `envconfig:"GATEWAY_REFRESH_TOKEN_COOKIE_NAME" default:"refresh_token"`
+	RefreshTokenCookiePath   string        `envconfig:"GATEWAY_REFRESH_TOKEN_COOKIE_PATH" default:"/v1/auth"`
+	RefreshTokenCookieDomain string        `envconfig:"GATEWAY_REFRESH_TOKEN_COOKIE_DOMAIN"`
+}
+
+type RateLimit struct {
+	Enabled bool `envconfig:"RATE_LIMIT_ENABLED" default:"false"`
+	// quota in the form requests/period, e.g. 100/1s
+	Default string `envconfig:"RATE_LIMIT_DEFAULT" default:"100/1s"`
+	// quotas by method name, e.g. Login:5/1m,Register:3/1m
+	Methods map[string]string `envconfig:"RATE_LIMIT_METHODS"`
+	// quotas by client key separated by semicolons, e.g. ip:10.0.0.5=1000/1s;api-key:ppk_1a2b3c4d5e6f=50/1s
+	Clients string `envconfig:"RATE_LIMIT_CLIENTS"`
+	Key     string `envconfig:"RATE_LIMIT_KEY" default:"ip"` // ip, mtls, api-key
+	// the buckets unused for so long are dropped, but never before the quota fills them up again
+	IdleTimeout time.Duration `envconfig:"RATE_LIMIT_IDLE_TIMEOUT" default:"600s"`
+	// above the limit the new clients of a method share one bucket until the idle buckets are dropped
+	MaxBuckets int `envconfig:"RATE_LIMIT_MAX_BUCKETS" default:"100000"`
 }
 
 type Store struct {
@@ -39,6 +91,126 @@ type Token struct {
 	PrivateKeyPath  string        `envconfig:"TOKEN_PRIVATE_KEY_PATH" required:"true"`
 	AccessLifetime  time.Duration `envconfig:"TOKEN_ACCESS_LIFETIME" required:"true"`
 	RefreshLifetime time.Duration `envconfig:"TOKEN_REFRESH_LIFETIME" required:"true"`
+	// how long after the login an access token is accepted instead of the current password
+	ReauthWindow time.Duration `envconfig:"TOKEN_REAUTH_WINDOW" default:"300s"`
+	// bounds of the roles and permissions claims of the access token
+	MaxRolesClaim       int `envconfig:"TOKEN_MAX_ROLES_CLAIM" default:"16"`
+	MaxPermissionsClaim int `envconfig:"TOKEN_MAX_PERMISSIONS_CLAIM" default:"64"`
+}
+type Authz struct {
+	// decisions are cached for the ttl, grant changes drop them earlier. 0 turns the cache off
+	CacheTtl     time.Duration `envconfig:"AUTHZ_CACHE_TTL" default:"60s"`
+	CacheSize    int           `envconfig:"AUTHZ_CACHE_SIZE" default:"10000"`
+	MaxBatchSize int           `envconfig:"AUTHZ_MAX_BATCH_SIZE" default:"100"`
+	// decisions are returned with the explanation of how they were made
+	Debug bool `envconfig:"AUTHZ_DEBUG" default:"false"`
+}
+type Rebac struct {
+	// namespace configuration of the relation tuples, the relation RPCs are off without it
+	NamespacesPath string `envconfig:"REBAC_NAMESPACES_PATH"`
+	// the longest chain of usersets a check follows
+	MaxDepth int `envconfig:"REBAC_MAX_DEPTH" default:"16"`
+	// the most tuple reads of one check or expansion
+	MaxLookups   int `envconfig:"REBAC_MAX_LOOKUPS" default:"1000"`
+	MaxWriteSize int `envconfig:"REBAC_MAX_WRITE_SIZE" default:"100"`
+	MaxReadSize  int `envconfig:"REBAC_MAX_READ_SIZE" default:"1000"`
+	// checks are cached for the ttl, a consistency token newer than the cached check bypasses it. 0 turns the cache off
+	CacheTtl  time.Duration `envconfig:"REBAC_CACHE_TTL" default:"10s"`
+	CacheSize int           `envconfig:"REBAC_CACHE_SIZE" default:"10000"`
+}
+type Tenant struct {
+	// base64 encoded 32 bytes the own signing keys of the tenants are encrypted with, tenants can not have them without it
+	SigningKeyEncryptionKey string `envconfig:"TENANT_SIGNING_KEY_ENCRYPTION_KEY"`
+	SigningKeyBits          int    `envconfig:"TENANT_SIGNING_KEY_BITS" default:"2048"`
+}
+type Admin struct {
+	// the role the callers of the AdminService must have
+	Role            string `envconfig:"ADMIN_ROLE" default:"admin"`
+	DefaultPageSize int    `envconfig:"ADMIN_DEFAULT_PAGE_SIZE" default:"50"`
+	MaxPageSize     int    `envconfig:"ADMIN_MAX_PAGE_SIZE" default:"500"`
+}
+type ServiceAccount struct {
+	TokenLifetime time.Duration `envconfig:"SERVICE_ACCOUNT_TOKEN_LIFETIME" default:"3600s"`
+	// lifetime of the secrets created without one, 0 is unlimited
+	SecretLifetime time.Duration `envconfig:"SERVICE_ACCOUNT_SECRET_LIFETIME" default:"2160h"`
+	// number of the unexpired secrets an account may have at once
+	MaxSecrets int `envconfig:"SERVICE_ACCOUNT_MAX_SECRETS" default:"5"`
+}
+type ApiKey struct {
+	// the keys start with the prefix, so they are easy to recognise in the code and the logs
+	Prefix        string        `envconfig:"API_KEY_PREFIX" default:"ppk"`
+	TokenLifetime time.Duration `envconfig:"API_KEY_TOKEN_LIFETIME" default:"900s"`
+	// number of the unexpired keys a user or a service account may have at once
+	MaxKeys int `envconfig:"API_KEY_MAX_KEYS" default:"20"`
+}
+type OAuth struct {
+	// the authorization code is exchanged right after the redirect
+	CodeLifetime time.Duration `envconfig:"OAUTH_CODE_LIFETIME" default:"60s"`
+	// public URL of the gateway, the iss of the ID tokens and the base of the endpoints in the OpenID discovery
+	Issuer          string        `envconfig:"OAUTH_ISSUER" default:"http://localhost:8080"`
+	IdTokenLifetime time.Duration `envconfig:"OAUTH_ID_TOKEN_LIFETIME" default:"3600s"`
+}
+type LoginGuard struct {
+	LoginBackoffThreshold int           `envconfig:"LOGIN_GUARD_LOGIN_BACKOFF_THRESHOLD" default:"3"`
+	LoginLockoutThreshold int           `envconfig:"LOGIN_GUARD_LOGIN_LOCKOUT_THRESHOLD" default:"10"`
+	IpBackoffThreshold    int           `envconfig:"LOGIN_GUARD_IP_BACKOFF_THRESHOLD" default:"20"`
+	IpLockoutThreshold    int           `envconfig:"LOGIN_GUARD_IP_LOCKOUT_THRESHOLD" default:"100"`
+	BackoffBase           time.Duration `envconfig:"LOGIN_GUARD_BACKOFF_BASE" default:"1s"`
+	BackoffMax            time.Duration `envconfig:"LOGIN_GUARD_BACKOFF_MAX" default:"300s"`
+	LockoutDuration       time.Duration `envconfig:"LOGIN_GUARD_LOCKOUT_DURATION" default:"900s"`
+	ResetAfter            time.Duration `envconfig:"LOGIN_GUARD_RESET_AFTER" default:"86400s"`
+}
+type Mfa struct {
+	Issuer string `envconfig:"MFA_ISSUER" default:"ppAuthService"`
+	// base64 encoded 32 byte key for the TOTP secrets at rest
+	EncryptionKey     string        `envconfig:"MFA_ENCRYPTION_KEY" required:"true"`
+	ChallengeLifetime time.Duration `envconfig:"MFA_CHALLENGE_LIFETIME" default:"300s"`
+	// number of neighbouring steps accepted to tolerate the clock drift
+	Skew                       int `envconfig:"MFA_SKEW" default:"1"`
+	RecoveryCodesCount         int `envconfig:"MFA_RECOVERY_CODES_COUNT" default:"10"`
+	RecoveryCodesWarnThreshold int `envconfig:"MFA_RECOVERY_CODES_WARN_THRESHOLD" default:"3"`
+}
+type Session struct {
+	// maximum number of devices with active sessions per user, 0 is unlimited
+	MaxDevices     int    `envconfig:"SESSION_MAX_DEVICES" default:"0"`
+	EvictionPolicy string `envconfig:"SESSION_EVICTION_POLICY" default:"reject"` // reject, evict-lru
+}
+type Notifier struct {
+	Type     string `envconfig:"NOTIFIER_TYPE" default:"log"` // log, file
+	FilePath string `envconfig:"NOTIFIER_FILE_PATH" default:"notifications.jsonl"`
+}
+type Email struct {
+	VerificationTokenLifetime time.Duration `envconfig:"EMAIL_VERIFICATION_TOKEN_LIFETIME" default:"86400s"`
+	// users without a verified email can not login
+	RequireVerified bool `envconfig:"EMAIL_REQUIRE_VERIFIED" default:"false"`
+	// the shortest time between two verification emails of the user
+	ResendCooldown time.Duration `envconfig:"EMAIL_RESEND_COOLDOWN" default:"60s"`
+}
+type PasswordReset struct {
+	TokenLifetime time.Duration `envconfig:"PASSWORD_RESET_TOKEN_LIFETIME" default:"900s"`
+}
+type PasswordPolicy struct {
+	MinLength     int  `envconfig:"PASSWORD_POLICY_MIN_LENGTH" default:"8"`
+	MaxLength     int  `envconfig:"PASSWORD_POLICY_MAX_LENGTH" default:"128"`
+	RequireLower  bool `envconfig:"PASSWORD_POLICY_REQUIRE_LOWER" default:"false"`
+	RequireUpper  bool `envconfig:"PASSWORD_POLICY_REQUIRE_UPPER" default:"false"`
+	RequireDigit  bool `envconfig:"PASSWORD_POLICY_REQUIRE_DIGIT" default:"false"`
+	RequireSymbol bool `envconfig:"PASSWORD_POLICY_REQUIRE_SYMBOL" default:"false"`
+	DisallowLogin bool `envconfig:"PASSWORD_POLICY_DISALLOW_LOGIN" default:"true"`
+	// file with one common password per line
+	CommonPasswordsPath string `envconfig:"PASSWORD_POLICY_COMMON_PASSWORDS_PATH"`
+	// number of the previous passwords that can not be used again, 0 turns the check off
+	HistoryDepth int `envconfig:"PASSWORD_POLICY_HISTORY_DEPTH" default:"0"`
+	// index built by cmd/breachindex, the breach check is off without it
+	BreachIndexPath string `envconfig:"PASSWORD_POLICY_BREACH_INDEX_PATH"`
+	// passwords seen in the breaches at least this number of times are rejected
+	BreachMinCount int `envconfig:"PASSWORD_POLICY_BREACH_MIN_COUNT" default:"1"`
+}
+type Unregister struct {
+	// deleted users can be restored during this period, then they are purged
+	RetentionPeriod time.Duration `envconfig:"UNREGISTER_RETENTION_PERIOD" default:"720h"`
+	// reserve keeps the login of the deleted user taken until the purge, free releases it at once
+	LoginPolicy string `envconfig:"UNREGISTER_LOGIN_POLICY" default:"reserve"` // reserve, free
 }
 
 func MustNew() *Config {
@@ -10,6 +10,160 @@ type User struct {
 	UserId   *uuid.UUID `json:"user_id" db:"user_id"`
 	Login    string     `json:"login" db:"login"`
 	Password string     `json:"password" db:"password"`
+	// overrides the configured limit of devices
+	MaxDevices      *int      `json:"max_devices" db:"max_devices"`
+	Status          string    `json:"status" db:"status"`
+	StatusChangedAt time.Time `json:"status_changed_at" db:"status_changed_at"`
+	// set while the user is deleted and not purged yet
+	DeletedAt       *time.Time `json:"deleted_at" db:"deleted_at"`
+	Email           *string    `json:"email" db:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at" db:"email_verified_at"`
+	// new email waiting for the verification
+	PendingEmail *string    `json:"pending_email" db:"pending_email"`
+	TenantId     *uuid.UUID `json:"tenant_id" db:"tenant_id"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// UserStats counts the users by the status and the features they use
+type UserStats struct {
+	Total         int64
+	Pending       int64
+	Active        int64
+	Locked        int64
+	Disabled      int64
+	Deleted       int64
+	EmailVerified int64
+	MfaEnabled    int64
+	// registered within the last day and the last week
+	CreatedLastDay  int64
+	CreatedLastWeek int64
+}
+
+const (
+	UserStatusPending  = "pending"
+	UserStatusActive   = "active"
+	UserStatusLocked   = "locked"
+	UserStatusDisabled = "disabled"
+	UserStatusDeleted  = "deleted"
+)
+
+// DefaultTenantId is the tenant of the requests without the tenant
+var DefaultTenantId = uuid.Nil
+
+type Tenant struct {
+	TenantId *uuid.UUID `json:"tenant_id" db:"tenant_id"`
+	Name     string     `json:"name" db:"name"`
+	// override the configured lifetimes of the tokens
+	AccessLifetime  *time.Duration `json:"access_lifetime" db:"access_lifetime_seconds"`
+	RefreshLifetime *time.Duration `json:"refresh_lifetime" db:"refresh_lifetime_seconds"`
+	// overrides of the configured password policy
+	PasswordPolicy *TenantPasswordPolicy `json:"password_policy" db:"password_policy"`
+	CreatedAt      time.Time             `json:"created_at" db:"created_at"`
+}
+
+// TenantPasswordPolicy keeps the overridden rules only, nil rules are taken from the configuration
+type TenantPasswordPolicy struct {
+	MinLength     *int  `json:"minLength,omitempty"`
+	MaxLength     *int  `json:"maxLength,omitempty"`
+	RequireLower  *bool `json:"requireLower,omitempty"`
+	RequireUpper  *bool `json:"requireUpper,omitempty"`
+	RequireDigit  *bool `json:"requireDigit,omitempty"`
+	RequireSymbol *bool `json:"requireSymbol,omitempty"`
+	DisallowLogin *bool `json:"disallowLogin,omitempty"`
+}
+
+// TenantSigningKey is the own signing key of the tenant, the private key is kept encrypted
+type TenantSigningKey struct {
+	KeyId      string     `json:"key_id" db:"key_id"`
+	TenantId   *uuid.UUID `json:"tenant_id" db:"tenant_id"`
+	PrivateKey string     `json:"private_key" db:"private_key"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ServiceAccount is the principal of the machine-to-machine calls, it gets the access tokens
+// by the client credentials grant
+type ServiceAccount struct {
+	ServiceAccountId *uuid.UUID `json:"service_account_id" db:"service_account_id"`
+	TenantId         *uuid.UUID `json:"tenant_id" db:"tenant_id"`
+	ClientId         string     `json:"client_id" db:"client_id"`
+	Name             string     `json:"name" db:"name"`
+	// scopes the tokens of the account may have
+	Scopes     []string  `json:"scopes" db:"scopes"`
+	IsDisabled bool      `json:"is_disabled" db:"is_disabled"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+type ServiceAccountSecret struct {
+	ServiceAccountSecretId *uuid.UUID `json:"service_account_secret_id" db:"service_account_secret_id"`
+	ServiceAccountId       *uuid.UUID `json:"service_account_id" db:"service_account_id"`
+	SecretHash             string     `json:"secret_hash" db:"secret_hash"`
+	// nil for the secret that does not expire
+	ExpirationAt *time.Time `json:"expiration_at" db:"expiration_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ApiKey is the long-lived key of a user or of a service account, exactly one of the owners is set.
+// Prefix is the public part of the key that names it, the whole key is stored as the hash only
+type ApiKey struct {
+	ApiKeyId         *uuid.UUID `json:"api_key_id" db:"api_key_id"`
+	UserId           *uuid.UUID `json:"user_id" db:"user_id"`
+	ServiceAccountId *uuid.UUID `json:"service_account_id" db:"service_account_id"`
+	Name             string     `json:"name" db:"name"`
+	Prefix           string     `json:"prefix" db:"prefix"`
+	KeyHash          string     `json:"key_hash" db:"key_hash"`
+	// scopes the tokens of the key are limited to, empty for no limit
+	Scopes []string `json:"scopes" db:"scopes"`
+	// nil for the key that does not expire
+	ExpirationAt *time.Time `json:"expiration_at" db:"expiration_at"`
+	// nil until the key is used
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+const (
+	OAuthClientTypePublic       = "public"
+	OAuthClientTypeConfidential = "confidential"
+)
+
+// OAuthClient is the application that gets the tokens of the users by the authorization code flow.
+// The public clients have no secret
+type OAuthClient struct {
+	OAuthClientId *uuid.UUID `json:"oauth_client_id" db:"oauth_client_id"`
+	TenantId      *uuid.UUID `json:"tenant_id" db:"tenant_id"`
+	ClientId      string     `json:"client_id" db:"client_id"`
+	Name          string     `json:"name" db:"name"`
+	ClientType    string     `json:"client_type" db:"client_type"`
+	SecretHash    *string    `json:"secret_hash" db:"secret_hash"`
+	// the redirect uri of the request must be one of them exactly
+	RedirectUris []string  `json:"redirect_uris" db:"redirect_uris"`
+	Scopes       []string  `json:"scopes" db:"scopes"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+type OAuthAuthorizationCode struct {
+	CodeHash      string     `json:"code_hash" db:"code_hash"`
+	OAuthClientId *uuid.UUID `json:"oauth_client_id" db:"oauth_client_id"`
+	UserId        *uuid.UUID `json:"user_id" db:"user_id"`
+	RedirectUri   string     `json:"redirect_uri" db:"redirect_uri"`
+	// the redirect uri was sent with the authorization request, the token request must send the same one then
+	RedirectUriSent bool     `json:"redirect_uri_sent" db:"redirect_uri_sent"`
+	Scopes          []string `json:"scopes" db:"scopes"`
+	// S256 PKCE challenge, nil when the confidential client did not use PKCE
+	CodeChallenge *string `json:"code_challenge" db:"code_challenge"`
+	// OpenID Connect nonce of the client, nil when it was not sent
+	Nonce *string `json:"nonce" db:"nonce"`
+	// authentication context class of the login: 1 for the password, 2 with the second factor
+	Acr          string    `json:"acr" db:"acr"`
+	AuthTime     time.Time `json:"auth_time" db:"auth_time"`
+	ExpirationAt time.Time `json:"expiration_at" db:"expiration_at"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+type UserStatusHistory struct {
+	UserStatusHistoryId *uuid.UUID `json:"user_status_history_id" db:"user_status_history_id"`
+	UserId              *uuid.UUID `json:"user_id" db:"user_id"`
+	OldStatus           string     `json:"old_status" db:"old_status"`
+	NewStatus           string     `json:"new_status" db:"new_status"`
+	Reason              string     `json:"reason" db:"reason"`
+	ChangedAt           time.Time  `json:"changed_at" db:"changed_at"`
 }
 
 type RefreshToken struct {
@@ -18,4 +172,105 @@ type RefreshToken struct {
 	DeviceCode     string     `json:"device_code" db:"device_code"`
 	ExpirationAt   time.Time  `json:"expiration_at" db:"expiration_at"`
 	IsRevoke       bool       `json:"is_revoke" db:"is_revoke"`
+	SessionId      *uuid.UUID `json:"session_id" db:"session_id"`
+	IssuedAt       time.Time  `json:"issued_at" db:"issued_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	ClientIp       string     `json:"client_ip" db:"client_ip"`
+	UserAgent      string     `json:"user_agent" db:"user_agent"`
+	ClientVersion  string     `json:"client_version" db:"client_version"`
+	LastUsedAt     time.Time  `json:"last_used_at" db:"last_used_at"`
+	// scopes granted to the OAuth client, nil for the first-party logins
+	Scopes []string `json:"scopes" db:"scopes"`
+}
+
+// Session is the active refresh token of a login, the session id and the issue time pass from token to token on rotation
+type Session struct {
+	SessionId     *uuid.UUID `json:"session_id" db:"session_id"`
+	UserId        *uuid.UUID `json:"user_id" db:"user_id"`
+	DeviceCode    string     `json:"device_code" db:"device_code"`
+	IssuedAt      time.Time  `json:"issued_at" db:"issued_at"`
+	ExpirationAt  time.Time  `json:"expiration_at" db:"expiration_at"`
+	LastRefreshAt time.Time  `json:"last_refresh_at" db:"created_at"`
+	ClientIp      string     `json:"client_ip" db:"client_ip"`
+	UserAgent     string     `json:"user_agent" db:"user_agent"`
+	ClientVersion string     `json:"client_version" db:"client_version"`
+	LastUsedAt    time.Time  `json:"last_used_at" db:"last_used_at"`
+}
+
+const (
+	LoginAttemptKeyTypeLogin = "login"
+	LoginAttemptKeyTypeIp    = "ip"
+)
+
+type PasswordResetToken struct {
+	PasswordResetTokenId *uuid.UUID
+	UserId               *uuid.UUID
+	TokenHash            string
+	ExpirationAt         time.Time
+	CreatedAt            time.Time
+	UsedAt               *time.Time
+}
+
+type EmailVerificationToken struct {
+	EmailVerificationTokenId *uuid.UUID
+	UserId                   *uuid.UUID
+	Email                    string
+	TokenHash                string
+	ExpirationAt             time.Time
+	CreatedAt                time.Time
+	UsedAt                   *time.Time
+}
+
+type Role struct {
+	RoleId      *uuid.UUID `json:"role_id" db:"role_id"`
+	Name        string     `json:"name" db:"name"`
+	Description string     `json:"description" db:"description"`
+	// overrides the configured limit of devices for the users of the role
+	MaxDevices *int      `json:"max_devices" db:"max_devices"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	// names of the granted permissions
+	Permissions []string `json:"permissions" db:"permissions"`
+}
+
+// Grant is a permission of the user through the role
+type Grant struct {
+	Role       string `json:"role" db:"role"`
+	Permission string `json:"permission" db:"permission"`
+	// attribute condition of the grant, empty for an unconditional grant
+	Condition string `json:"condition" db:"condition"`
+}
+type Permission struct {
+	PermissionId *uuid.UUID `json:"permission_id" db:"permission_id"`
+	Name         string     `json:"name" db:"name"`
+	Description  string     `json:"description" db:"description"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// RelationTuple says that the subject has the relation to the object. An empty subject relation means a single
+// subject, otherwise the subject is the userset of the subject object
+type RelationTuple struct {
+	Namespace        string    `json:"namespace" db:"namespace"`
+	ObjectId         string    `json:"object_id" db:"object_id"`
+	Relation         string    `json:"relation" db:"relation"`
+	SubjectNamespace string    `json:"subject_namespace" db:"subject_namespace"`
+	SubjectId        string    `json:"subject_id" db:"subject_id"`
+	SubjectRelation  string    `json:"subject_relation" db:"subject_relation"`
+	Revision         int64     `json:"revision" db:"revision"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+}
+
+type LoginAttempt struct {
+	KeyType      string     `json:"key_type" db:"key_type"`
+	Key          string     `json:"key" db:"key"`
+	FailedCount  int        `json:"failed_count" db:"failed_count"`
+	LastFailedAt time.Time  `json:"last_failed_at" db:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until" db:"locked_until"`
+}
+
+type UserMfa struct {
+	UserId       *uuid.UUID `json:"user_id" db:"user_id"`
+	Secret       string     `json:"secret" db:"secret"`
+	IsConfirmed  bool       `json:"is_confirmed" db:"is_confirmed"`
+	LastUsedStep int64      `json:"last_used_step" db:"last_used_step"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
 }
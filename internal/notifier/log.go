@@ -0,0 +1,28 @@
+package notifier
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogNotifier writes the notifications to the log. The tokens end up in the log, so it is meant for local use only
+type LogNotifier struct {
+	lg *slog.Logger
+}
+
+func NewLogNotifier(lg *slog.Logger) *LogNotifier {
+	return &LogNotifier{lg}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, notification *Notification) error {
+	n.lg.Info("notification",
+		slog.String("owner", "notifier.Notify"),
+		slog.String("type", notification.Type),
+		slog.Any("userId", notification.UserId),
+		slog.String("login", notification.Login),
+		slog.String("email", notification.Email),
+		slog.String("token", notification.Token),
+		slog.Time("expirationAt", notification.ExpirationAt),
+	)
+	return nil
+}
@@ -0,0 +1,44 @@
+// Package notifier delivers the messages with secrets (reset and verification tokens) to the users
+package notifier
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"ppAuthService/internal/config"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TypePasswordReset     = "password-reset"
+	TypeEmailVerification = "email-verification"
+)
+
+type Notification struct {
+	Type   string     `json:"type"`
+	UserId *uuid.UUID `json:"userId"`
+	Login  string     `json:"login"`
+	// address to deliver to, empty when the user has no verified email
+	Email string `json:"email,omitempty"`
+	// single-use token the user has to present back
+	Token        string    `json:"token"`
+	ExpirationAt time.Time `json:"expirationAt"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, notification *Notification) error
+}
+
+func MustNew(lg *slog.Logger, cfg *config.Notifier) Notifier {
+	switch cfg.Type {
+	case "log":
+		return NewLogNotifier(lg)
+	case "file":
+		return NewFileNotifier(cfg.FilePath)
+	default:
+		log.Fatalf("failed to initialize notifier: unknown notifier type %q\n", cfg.Type)
+	}
+	return nil
+}
@@ -1,14 +1,17 @@
 package dto
 
 import (
+	"ppAuthService/internal/entity"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type AddUser struct {
+	TenantId *uuid.UUID
 	Login    string
 	Password string
+	Email    *string
 }
 type UpdateUser struct {
 	UserId   *uuid.UUID
@@ -16,14 +19,286 @@ type UpdateUser struct {
 	Password *string
 }
 
+type UpdateUserMaxDevices struct {
+	UserId *uuid.UUID
+	// nil removes the override
+	MaxDevices *int
+}
+
 type AddRefreshTokenWithRefreshTokenId struct {
 	RefreshTokenId *uuid.UUID
 	UserId         *uuid.UUID
 	DeviceCode     string
 	ExpirationAt   time.Time
 	IsRevoke       bool
+	SessionId      *uuid.UUID
+	IssuedAt       time.Time
+	ClientIp       string
+	UserAgent      string
+	ClientVersion  string
+	LastUsedAt     time.Time
+	// nil for the first-party logins
+	Scopes []string
+}
+
+// AddDeviceRefreshToken is the refresh token of a new login of the device
+type AddDeviceRefreshToken struct {
+	RefreshToken *AddRefreshTokenWithRefreshTokenId
+	// the most devices with active tokens, 0 is unlimited
+	MaxDevices int
+	// the least recently used devices are logged out to make room, otherwise the login is refused
+	EvictLru bool
 }
 type RevokeRefreshTokensByUserIdAndDeviceCode struct {
 	UserId     *uuid.UUID
 	DeviceCode *string
 }
+type RevokeRefreshTokensBySessionId struct {
+	UserId    *uuid.UUID
+	SessionId *uuid.UUID
+}
+type RevokeRefreshTokensExceptDeviceCode struct {
+	UserId     *uuid.UUID
+	DeviceCode string
+}
+
+type UpdateUserStatus struct {
+	UserId *uuid.UUID
+	// the status is changed only if it is still the old one
+	OldStatus string
+	NewStatus string
+	Reason    string
+}
+type DeleteUser struct {
+	UserId    *uuid.UUID
+	OldStatus string
+	Reason    string
+	// the login gets a suffix, so that it can be registered again
+	FreeLogin bool
+}
+type RestoreUser struct {
+	UserId *uuid.UUID
+	Reason string
+}
+
+type AddEmailVerificationToken struct {
+	UserId       *uuid.UUID
+	Email        string
+	TokenHash    string
+	ExpirationAt time.Time
+}
+type UpdateUserPendingEmail struct {
+	UserId       *uuid.UUID
+	PendingEmail string
+}
+
+type AddPasswordResetToken struct {
+	UserId       *uuid.UUID
+	TokenHash    string
+	ExpirationAt time.Time
+}
+
+// ResetPasswordByToken sets the password of the user of the token, the token must be unused and unexpired at Now
+type ResetPasswordByToken struct {
+	TokenHash string
+	UserId    *uuid.UUID
+	Password  string
+	Now       time.Time
+}
+
+type AddPasswordHistory struct {
+	UserId   *uuid.UUID
+	Password string
+	// number of the latest entries kept, the older ones are removed
+	Depth int
+}
+
+type AddRole struct {
+	TenantId    *uuid.UUID
+	Name        string
+	Description string
+	MaxDevices  *int
+}
+type AddPermission struct {
+	TenantId    *uuid.UUID
+	Name        string
+	Description string
+}
+type RolePermission struct {
+	TenantId   *uuid.UUID
+	Role       string
+	Permission string
+	// attribute condition, ignored on removal
+	Condition string
+}
+
+// UserRole names the role of the tenant of the user
+type UserRole struct {
+	UserId *uuid.UUID
+	Role   string
+}
+
+// ListUsers filters the users, nil filters are not applied. The page starts after the cursor user,
+// the users are ordered by the creation time from the newest
+type ListUsers struct {
+	TenantId    *uuid.UUID
+	LoginPrefix *string
+	Status      *string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// creation time and id of the last user of the previous page
+	AfterCreatedAt *time.Time
+	AfterUserId    *uuid.UUID
+	Limit          int
+}
+
+type AddTenant struct {
+	Name            string
+	AccessLifetime  *time.Duration
+	RefreshLifetime *time.Duration
+	PasswordPolicy  *entity.TenantPasswordPolicy
+}
+
+// UpdateTenant replaces the overrides of the tenant
+type UpdateTenant struct {
+	TenantId        *uuid.UUID
+	AccessLifetime  *time.Duration
+	RefreshLifetime *time.Duration
+	PasswordPolicy  *entity.TenantPasswordPolicy
+}
+type AddTenantSigningKey struct {
+	KeyId    string
+	TenantId *uuid.UUID
+	// encrypted PEM
+	PrivateKey string
+}
+
+// AddServiceAccount adds the account together with its first secret
+type AddServiceAccount struct {
+	TenantId           *uuid.UUID
+	ClientId           string
+	Name               string
+	Scopes             []string
+	SecretHash         string
+	SecretExpirationAt *time.Time
+}
+
+// UpdateServiceAccount changes the non nil fields
+type UpdateServiceAccount struct {
+	ServiceAccountId *uuid.UUID
+	Scopes           []string
+	IsDisabled       *bool
+}
+type AddServiceAccountSecret struct {
+	ServiceAccountId *uuid.UUID
+	SecretHash       string
+	ExpirationAt     *time.Time
+}
+
+type AddApiKey struct {
+	UserId           *uuid.UUID
+	ServiceAccountId *uuid.UUID
+	Name             string
+	Prefix           string
+	KeyHash          string
+	Scopes           []string
+	ExpirationAt     *time.Time
+}
+
+type AddOAuthClient struct {
+	TenantId     *uuid.UUID
+	ClientId     string
+	Name         string
+	ClientType   string
+	SecretHash   *string
+	RedirectUris []string
+	Scopes       []string
+}
+
+// RemoveOAuthClient removes the client and revokes the refresh tokens of its device
+type RemoveOAuthClient struct {
+	OAuthClientId *uuid.UUID
+	DeviceCode    string
+}
+
+// UpdateOAuthClient changes the non nil fields
+type UpdateOAuthClient struct {
+	OAuthClientId *uuid.UUID
+	RedirectUris  []string
+	Scopes        []string
+}
+type AddOAuthAuthorizationCode struct {
+	CodeHash        string
+	OAuthClientId   *uuid.UUID
+	UserId          *uuid.UUID
+	RedirectUri     string
+	RedirectUriSent bool
+	Scopes          []string
+	CodeChallenge   *string
+	Nonce           *string
+	Acr             string
+	AuthTime        time.Time
+	ExpirationAt    time.Time
+}
+
+type RelationTuple struct {
+	Namespace        string
+	ObjectId         string
+	Relation         string
+	SubjectNamespace string
+	SubjectId        string
+	SubjectRelation  string
+}
+type WriteRelationTuples struct {
+	TenantId *uuid.UUID
+	// written tuples that exist already are kept
+	Writes  []*RelationTuple
+	Deletes []*RelationTuple
+}
+
+// GetRelationTuples filters the tuples of the namespace of the tenant, nil fields match any value
+type GetRelationTuples struct {
+	TenantId         *uuid.UUID
+	Namespace        string
+	ObjectId         *string
+	Relation         *string
+	SubjectNamespace *string
+	SubjectId        *string
+	SubjectRelation  *string
+	// only the tuples with a userset subject
+	UsersetsOnly bool
+	// 0 is unlimited
+	Limit int
+}
+
+type AddLoginAttemptFailure struct {
+	KeyType  string
+	Key      string
+	FailedAt time.Time
+	// failures before this moment are forgotten
+	ResetBefore time.Time
+}
+type UpdateLoginAttemptLockedUntil struct {
+	KeyType     string
+	Key         string
+	LockedUntil time.Time
+}
+
+type SetUserMfa struct {
+	UserId *uuid.UUID
+	Secret string
+}
+type UpdateUserMfaLastUsedStep struct {
+	UserId       *uuid.UUID
+	LastUsedStep int64
+}
+
+type SetMfaRecoveryCodes struct {
+	UserId     *uuid.UUID
+	CodeHashes []string
+}
+type UseMfaRecoveryCode struct {
+	UserId   *uuid.UUID
+	CodeHash string
+	UsedIp   string
+}
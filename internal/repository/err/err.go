@@ -7,4 +7,8 @@ var (
 
 	ErrRecordNotFound  = errors.New("record not found")
 	ErrUniqueViolation = errors.New("unique violation")
+	// the email is used by another user
+	ErrEmailUniqueViolation = errors.New("email unique violation")
+	// the limit the operation was checked against is reached
+	ErrLimitExceeded = errors.New("limit exceeded")
 )
@@ -10,13 +10,106 @@ import (
 
 type Repository interface {
 	AddUser(dto *repoDto.AddUser) (*uuid.UUID, error)
-	GetUserByLogin(login string) (*entity.User, error)
+	GetUser(userId *uuid.UUID) (*entity.User, error)
+	GetUserByLogin(tenantId *uuid.UUID, login string) (*entity.User, error)
+	UpdateUserPendingEmail(dto *repoDto.UpdateUserPendingEmail) error
 	UpdateUser(dto *repoDto.UpdateUser) error
-	RemoveUser(userId *uuid.UUID) error
+	UpdateUserMaxDevices(dto *repoDto.UpdateUserMaxDevices) error
+	UpdateUserStatus(dto *repoDto.UpdateUserStatus) error
+	GetUserStatusHistory(userId *uuid.UUID) ([]*entity.UserStatusHistory, error)
+	DeleteUser(dto *repoDto.DeleteUser) error
+	RestoreUser(dto *repoDto.RestoreUser) error
+	RemoveUsersByDeletedAt(before time.Time) (int64, error)
+	ListUsers(dto *repoDto.ListUsers) ([]*entity.User, error)
+	GetUserStats(tenantId *uuid.UUID, now time.Time) (*entity.UserStats, error)
+
+	AddTenant(dto *repoDto.AddTenant) (*uuid.UUID, error)
+	GetTenant(tenantId *uuid.UUID) (*entity.Tenant, error)
+	GetTenantByName(name string) (*entity.Tenant, error)
+	GetTenants() ([]*entity.Tenant, error)
+	UpdateTenant(dto *repoDto.UpdateTenant) error
+	AddTenantSigningKey(dto *repoDto.AddTenantSigningKey) error
+	GetTenantSigningKey(keyId string) (*entity.TenantSigningKey, error)
+	GetTenantSigningKeys() ([]*entity.TenantSigningKey, error)
+	GetTenantSigningKeysByTenantId(tenantId *uuid.UUID) ([]*entity.TenantSigningKey, error)
+
+	AddServiceAccount(dto *repoDto.AddServiceAccount) (*uuid.UUID, error)
+	GetServiceAccount(serviceAccountId *uuid.UUID) (*entity.ServiceAccount, error)
+	GetServiceAccountByClientId(clientId string) (*entity.ServiceAccount, error)
+	GetServiceAccounts(tenantId *uuid.UUID) ([]*entity.ServiceAccount, error)
+	UpdateServiceAccount(dto *repoDto.UpdateServiceAccount) error
+	RemoveServiceAccount(serviceAccountId *uuid.UUID) error
+	AddServiceAccountSecret(dto *repoDto.AddServiceAccountSecret) (*uuid.UUID, error)
+	GetServiceAccountSecrets(serviceAccountId *uuid.UUID) ([]*entity.ServiceAccountSecret, error)
+	RemoveServiceAccountSecret(serviceAccountId *uuid.UUID, serviceAccountSecretId *uuid.UUID) error
+
+	AddApiKey(dto *repoDto.AddApiKey) (*uuid.UUID, error)
+	GetApiKeyByPrefix(prefix string) (*entity.ApiKey, error)
+	GetApiKeysByOwner(userId *uuid.UUID, serviceAccountId *uuid.UUID) ([]*entity.ApiKey, error)
+	UpdateApiKeyLastUsedAt(apiKeyId *uuid.UUID, lastUsedAt time.Time) error
+	RemoveApiKey(apiKeyId *uuid.UUID) error
+
+	AddOAuthClient(dto *repoDto.AddOAuthClient) (*uuid.UUID, error)
+	GetOAuthClientByClientId(clientId string) (*entity.OAuthClient, error)
+	GetOAuthClients(tenantId *uuid.UUID) ([]*entity.OAuthClient, error)
+	UpdateOAuthClient(dto *repoDto.UpdateOAuthClient) error
+	RemoveOAuthClient(dto *repoDto.RemoveOAuthClient) error
+	AddOAuthAuthorizationCode(dto *repoDto.AddOAuthAuthorizationCode) error
+	TakeOAuthAuthorizationCode(codeHash string) (*entity.OAuthAuthorizationCode, error)
+	RemoveOAuthAuthorizationCodesByExpirationAt(now time.Time) (int64, error)
 
 	AddRefreshTokenWithRefreshTokenId(dto *repoDto.AddRefreshTokenWithRefreshTokenId) error
+	AddDeviceRefreshToken(dto *repoDto.AddDeviceRefreshToken) ([]string, error)
 	GetRefreshToken(refreshTokenId *uuid.UUID) (*entity.RefreshToken, error)
 	RevokeRefreshTokenByRefreshTokenId(refreshTokenId *uuid.UUID) error
 	RevokeRefreshTokensByUserIdAndDeviceCode(dto *repoDto.RevokeRefreshTokensByUserIdAndDeviceCode) error
 	RemoveRefreshTokensByExpirationAt(now time.Time) (int64, error)
+	GetSessionsByUserId(userId *uuid.UUID, now time.Time) ([]*entity.Session, error)
+	RevokeRefreshTokensBySessionId(dto *repoDto.RevokeRefreshTokensBySessionId) error
+	RevokeRefreshTokensExceptDeviceCode(dto *repoDto.RevokeRefreshTokensExceptDeviceCode) (int64, error)
+
+	AddPasswordResetToken(dto *repoDto.AddPasswordResetToken) error
+	ResetPasswordByToken(dto *repoDto.ResetPasswordByToken) error
+	GetPasswordResetToken(tokenHash string, now time.Time) (*entity.PasswordResetToken, error)
+	RemovePasswordResetTokensByUserId(userId *uuid.UUID) error
+
+	AddPasswordHistory(dto *repoDto.AddPasswordHistory) error
+	GetPasswordHistory(userId *uuid.UUID, limit int) ([]string, error)
+
+	AddEmailVerificationToken(dto *repoDto.AddEmailVerificationToken) error
+	VerifyEmailByToken(tokenHash string, now time.Time) (*entity.EmailVerificationToken, error)
+	GetLastEmailVerificationTokenCreatedAt(userId *uuid.UUID) (*time.Time, error)
+
+	AddRole(dto *repoDto.AddRole) (*uuid.UUID, error)
+	GetRoles(tenantId *uuid.UUID) ([]*entity.Role, error)
+	RemoveRole(tenantId *uuid.UUID, name string) error
+	AddPermission(dto *repoDto.AddPermission) (*uuid.UUID, error)
+	GetPermissions(tenantId *uuid.UUID) ([]*entity.Permission, error)
+	RemovePermission(tenantId *uuid.UUID, name string) error
+	AddRolePermission(dto *repoDto.RolePermission) error
+	RemoveRolePermission(dto *repoDto.RolePermission) error
+	AddUserRole(dto *repoDto.UserRole) error
+	RemoveUserRole(dto *repoDto.UserRole) error
+	GetRolesByUserId(userId *uuid.UUID) ([]*entity.Role, error)
+	GetGrantsByUserId(userId *uuid.UUID) ([]*entity.Grant, error)
+
+	WriteRelationTuples(dto *repoDto.WriteRelationTuples) (int64, error)
+	GetRelationTuples(dto *repoDto.GetRelationTuples) ([]*entity.RelationTuple, error)
+	GetRelationTupleRevision() (int64, error)
+
+	GetLoginAttempt(keyType string, key string) (*entity.LoginAttempt, error)
+	AddLoginAttemptFailure(dto *repoDto.AddLoginAttemptFailure) (*entity.LoginAttempt, error)
+	UpdateLoginAttemptLockedUntil(dto *repoDto.UpdateLoginAttemptLockedUntil) error
+	RemoveLoginAttempt(keyType string, key string) error
+
+	SetUserMfa(dto *repoDto.SetUserMfa) error
+	GetUserMfa(userId *uuid.UUID) (*entity.UserMfa, error)
+	ConfirmUserMfa(dto *repoDto.SetMfaRecoveryCodes) error
+	UpdateUserMfaLastUsedStep(dto *repoDto.UpdateUserMfaLastUsedStep) error
+	RemoveUserMfa(userId *uuid.UUID) error
+
+	SetMfaRecoveryCodes(dto *repoDto.SetMfaRecoveryCodes) error
+	UseMfaRecoveryCode(dto *repoDto.UseMfaRecoveryCode) error
+	GetUnusedMfaRecoveryCodeCount(userId *uuid.UUID) (int64, error)
+	RemoveMfaRecoveryCodes(userId *uuid.UUID) error
 }
@@ -0,0 +1,72 @@
+// Package scheduler runs the periodic maintenance jobs of the service
+package scheduler
+
+import (
+	"log/slog"
+	"ppAuthService/internal/config"
+	"ppAuthService/internal/service"
+	"sync"
+	"time"
+)
+
+type job struct {
+	name    string
+	timeout time.Duration
+	run     func() (int64, error)
+}
+
+type Scheduler struct {
+	jobs   []job
+	lg     *slog.Logger
+	chStop chan struct{}
+	wg     sync.WaitGroup
+}
+
+func New(service *service.Service, lg *slog.Logger, cfg *config.Scheduler) *Scheduler {
+	return &Scheduler{
+		jobs: []job{
+			{"removeRefreshTokens", cfg.TimeoutRemoveRefreshTokens, service.RemoveExpiredRefreshTokens},
+			{"purgeUsers", cfg.TimeoutPurgeUsers, service.PurgeDeletedUsers},
+			{"removeOAuthCodes", cfg.TimeoutRemoveOAuthCodes, service.RemoveExpiredOAuthCodes},
+		},
+		lg:     lg,
+		chStop: make(chan struct{}),
+	}
+}
+
+// Start runs every job once and then after each timeout
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		if j.timeout <= 0 {
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			ticker := time.NewTicker(j.timeout)
+			defer ticker.Stop()
+			for {
+				s.run(j)
+				select {
+				case <-ticker.C:
+				case <-s.chStop:
+					return
+				}
+			}
+		}()
+	}
+	s.lg.Info("scheduler is started", slog.String("owner", "scheduler"))
+}
+func (s *Scheduler) Stop() {
+	close(s.chStop)
+	s.wg.Wait()
+	s.lg.Info("scheduler is stoped", slog.String("owner", "scheduler"))
+}
+func (s *Scheduler) run(j job) {
+	count, err := j.run()
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "scheduler"), slog.String("job", j.name))
+		return
+	}
+	s.lg.Info("job is done", slog.String("owner", "scheduler"), slog.String("job", j.name), slog.Int64("count", count))
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	srvErr "ppAuthService/internal/server/err"
+	"ppAuthService/internal/service"
+	svcErr "ppAuthService/internal/service/err"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type accessRule int
+
+const (
+	// anyone may call the method
+	rulePublic accessRule = iota
+	// the caller needs a valid access token
+	ruleAuthenticated
+	// the access token must belong to the user of the request
+	ruleSelf
+	// the caller needs the admin role
+	ruleAdmin
+)
+
+const adminServicePrefix = "/auth.AdminService/"
+
+// scopes of the methods that the api key, the service account and the client tokens may call
+const (
+	scopeAuthzCheck    = "authz.check"
+	scopeRelationsRead = "relations.read"
+	scopeOpenId        = "openid"
+)
+
+type methodRule struct {
+	access accessRule
+	// the scope a delegated token needs to call the method, such tokens can not call the methods without it
+	scope string
+}
+
+// methodRules are the rules of the methods, all the methods of the AdminService are admin ones without a scope.
+// The methods that are not listed need a valid access token and have no scope. The OAuthService ones are called
+// only by the HTTP handlers of the OAuth flow
+var methodRules = map[string]methodRule{
+	"/auth.AuthService/Register":                   {access: rulePublic},
+	"/auth.AuthService/Login":                      {access: rulePublic},
+	"/auth.AuthService/RefreshToken":               {access: rulePublic},
+	"/auth.AuthServiceExt/Token":                   {access: rulePublic},
+	"/auth.AuthServiceExt/ExchangeApiKey":          {access: rulePublic},
+	"/auth.AuthServiceExt/RequestPasswordReset":    {access: rulePublic},
+	"/auth.AuthServiceExt/ConfirmPasswordReset":    {access: rulePublic},
+	"/auth.AuthServiceExt/VerifyEmail":             {access: rulePublic},
+	"/auth.AuthService/Unregister":                 {access: ruleSelf},
+	"/auth.AuthService/Logout":                     {access: ruleSelf},
+	"/auth.AuthService/UpdatePassword":             {access: ruleSelf},
+	"/auth.AuthServiceExt/ChangeEmail":             {access: ruleSelf},
+	"/auth.AuthServiceExt/ResendEmailVerification": {access: ruleSelf},
+	"/auth.AuthServiceExt/ListSessions":            {access: ruleSelf},
+	"/auth.AuthServiceExt/RevokeSession":           {access: ruleSelf},
+	"/auth.AuthServiceExt/RevokeAllOtherSessions":  {access: ruleSelf},
+	"/auth.AuthServiceExt/EnrollTotp":              {access: ruleSelf},
+	"/auth.AuthServiceExt/ConfirmTotp":             {access: ruleSelf},
+	"/auth.AuthServiceExt/DisableTotp":             {access: ruleSelf},
+	"/auth.AuthServiceExt/RegenerateRecoveryCodes": {access: ruleSelf},
+	"/auth.AuthServiceExt/CreateApiKey":            {access: ruleSelf},
+	"/auth.AuthServiceExt/ListApiKeys":             {access: ruleSelf},
+	"/auth.AuthServiceExt/RevokeApiKey":            {access: ruleSelf},
+	"/auth.AuthzService/CheckPermission":           {access: ruleAuthenticated, scope: scopeAuthzCheck},
+	"/auth.AuthzService/BatchCheck":                {access: ruleAuthenticated, scope: scopeAuthzCheck},
+	"/auth.RelationService/Check":                  {access: ruleAuthenticated, scope: scopeRelationsRead},
+	"/auth.RelationService/Expand":                 {access: ruleAuthenticated, scope: scopeRelationsRead},
+	"/auth.RelationService/Read":                   {access: ruleAuthenticated, scope: scopeRelationsRead},
+	"/auth.RelationService/Write":                  {access: ruleAdmin},
+	"/auth.OAuthService/CheckAuthorize":            {access: rulePublic},
+	"/auth.OAuthService/Authorize":                 {access: rulePublic},
+	"/auth.OAuthService/Token":                     {access: rulePublic},
+	"/auth.OAuthService/UserInfo":                  {access: ruleAuthenticated, scope: scopeOpenId},
+}
+
+func ruleOf(fullMethod string) methodRule {
+	if strings.HasPrefix(fullMethod, adminServicePrefix) {
+		return methodRule{access: ruleAdmin}
+	}
+	if rule, ok := methodRules[fullMethod]; ok {
+		return rule
+	}
+	return methodRule{access: ruleAuthenticated}
+}
+
+// userIdRequest is a request made on behalf of the user
+type userIdRequest interface {
+	GetUserId() string
+}
+
+// AuthInterceptor verifies the bearer access token of the call, puts its claims into the context
+// and enforces the rule and the scope of the method
+func AuthInterceptor(svc *service.Service) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		rule := ruleOf(info.FullMethod)
+		if rule.access == rulePublic {
+			return handler(ctx, req)
+		}
+		claims, err := svc.Authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if err := svc.AuthorizeScope(claims, rule.scope, info.FullMethod); err != nil {
+			return nil, err
+		}
+		switch rule.access {
+		case ruleSelf:
+			userIdReq, ok := req.(userIdRequest)
+			if !ok {
+				return nil, status.Error(codes.Internal, srvErr.ErrInternalServerError.Error())
+			}
+			userId, err := uuid.Parse(userIdReq.GetUserId())
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+			}
+			if userId != *claims.Sub {
+				return nil, status.Error(codes.PermissionDenied, svcErr.ErrNotTokenOwner.Error())
+			}
+			if err := svc.AuthorizeSelf(claims, info.FullMethod); err != nil {
+				return nil, err
+			}
+		case ruleAdmin:
+			targetUserId := ""
+			if userIdReq, ok := req.(userIdRequest); ok {
+				targetUserId = userIdReq.GetUserId()
+			}
+			if ctx, err = svc.AuthorizeAdmin(ctx, claims, info.FullMethod, targetUserId); err != nil {
+				return nil, err
+			}
+		}
+		return handler(service.WithClaims(ctx, claims), req)
+	}
+}
@@ -1,7 +1,11 @@
-package repository
+package server
 
 import "errors"
 
 var (
 	ErrInternalServerError = errors.New("internal server error")
+	ErrInvalidRequestBody  = errors.New("invalid request body")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRateLimitExceeded   = errors.New("rate limit exceeded, retry later")
+	ErrInvalidFormToken    = errors.New("the form has expired, sign in again")
 )
@@ -0,0 +1,426 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ppAuthService/internal/config"
+	srvErr "ppAuthService/internal/server/err"
+	"ppAuthService/internal/service"
+
+	proto "github.com/MedvedevEA/ppProtos/gen/auth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// Gateway is an HTTP/JSON front for the service. Each request is passed through the same
+// interceptor chain as the grpc calls, so validation and error semantics are identical
+type Gateway struct {
+	lg          *slog.Logger
+	cfg         *config.Gateway
+	service     *service.Service
+	interceptor grpc.UnaryServerInterceptor
+	httpServer  *http.Server
+}
+
+func NewGateway(service *service.Service, lg *slog.Logger, cfg *config.Gateway, interceptor grpc.UnaryServerInterceptor) *Gateway {
+	// with the credentials allowed any site could make the authenticated calls
+	if cfg.CorsAllowCredentials && slices.Contains(cfg.CorsAllowedOrigins, "*") {
+		log.Fatalf("failed to initialize gateway: the wildcard origin can not be allowed with the credentials\n")
+	}
+	g := &Gateway{
+		lg:          lg,
+		cfg:         cfg,
+		service:     service,
+		interceptor: interceptor,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /v1/auth/register", handle(g, "/auth.AuthService/Register", service.Register))
+	mux.Handle("POST /v1/auth/unregister", handle(g, "/auth.AuthService/Unregister", service.Unregister))
+	mux.Handle("POST /v1/auth/login", handle(g, "/auth.AuthService/Login", service.Login))
+	mux.Handle("POST /v1/auth/logout", handle(g, "/auth.AuthService/Logout", service.Logout))
+	mux.Handle("POST /v1/auth/update-password", handle(g, "/auth.AuthService/UpdatePassword", service.UpdatePassword))
+	mux.Handle("POST /v1/auth/password-reset/request", handle(g, "/auth.AuthServiceExt/RequestPasswordReset", service.RequestPasswordReset))
+	mux.Handle("POST /v1/auth/password-reset/confirm", handle(g, "/auth.AuthServiceExt/ConfirmPasswordReset", service.ConfirmPasswordReset))
+	mux.Handle("POST /v1/auth/email/verify", handle(g, "/auth.AuthServiceExt/VerifyEmail", service.VerifyEmail))
+	mux.Handle("POST /v1/auth/email/change", handle(g, "/auth.AuthServiceExt/ChangeEmail", service.ChangeEmail))
+	mux.Handle("POST /v1/auth/email/resend-verification", handle(g, "/auth.AuthServiceExt/ResendEmailVerification", service.ResendEmailVerification))
+	mux.Handle("POST /v1/auth/refresh-token", handle(g, "/auth.AuthService/RefreshToken", service.RefreshToken))
+	mux.Handle("POST /v1/auth/token", handle(g, "/auth.AuthServiceExt/Token", service.Token))
+	mux.Handle("POST /v1/auth/api-keys/exchange", handle(g, "/auth.AuthServiceExt/ExchangeApiKey", service.ExchangeApiKey))
+	mux.Handle("POST /v1/auth/sessions/list", handle(g, "/auth.AuthServiceExt/ListSessions", service.ListSessions))
+	mux.Handle("POST /v1/auth/sessions/revoke", handle(g, "/auth.AuthServiceExt/RevokeSession", service.RevokeSession))
+	mux.Handle("POST /v1/auth/sessions/revoke-others", handle(g, "/auth.AuthServiceExt/RevokeAllOtherSessions", service.RevokeAllOtherSessions))
+	mux.Handle("POST /v1/auth/mfa/totp/enroll", handle(g, "/auth.AuthServiceExt/EnrollTotp", service.EnrollTotp))
+	mux.Handle("POST /v1/auth/mfa/totp/confirm", handle(g, "/auth.AuthServiceExt/ConfirmTotp", service.ConfirmTotp))
+	mux.Handle("POST /v1/auth/mfa/totp/disable", handle(g, "/auth.AuthServiceExt/DisableTotp", service.DisableTotp))
+	mux.Handle("POST /v1/auth/mfa/recovery-codes/regenerate", handle(g, "/auth.AuthServiceExt/RegenerateRecoveryCodes", service.RegenerateRecoveryCodes))
+	mux.Handle("POST /v1/auth/api-keys/create", handle(g, "/auth.AuthServiceExt/CreateApiKey", service.CreateApiKey))
+	mux.Handle("POST /v1/auth/api-keys/list", handle(g, "/auth.AuthServiceExt/ListApiKeys", service.ListApiKeys))
+	mux.Handle("POST /v1/auth/api-keys/revoke", handle(g, "/auth.AuthServiceExt/RevokeApiKey", service.RevokeApiKey))
+
+	mux.Handle("POST /v1/authz/check", handle(g, "/auth.AuthzService/CheckPermission", service.CheckPermission))
+	mux.Handle("POST /v1/authz/batch-check", handle(g, "/auth.AuthzService/BatchCheck", service.BatchCheck))
+	mux.Handle("POST /v1/relations/check", handle(g, "/auth.RelationService/Check", service.CheckRelation))
+	mux.Handle("POST /v1/relations/expand", handle(g, "/auth.RelationService/Expand", service.ExpandRelation))
+	mux.Handle("POST /v1/relations/write", handle(g, "/auth.RelationService/Write", service.WriteRelationTuples))
+	mux.Handle("POST /v1/relations/read", handle(g, "/auth.RelationService/Read", service.ReadRelationTuples))
+
+	mux.Handle("POST /v1/admin/clear-login-lockout", handle(g, "/auth.AdminService/ClearLoginLockout", service.ClearLoginLockout))
+	mux.Handle("POST /v1/admin/set-user-status", handle(g, "/auth.AdminService/SetUserStatus", service.SetUserStatus))
+	mux.Handle("POST /v1/admin/get-user-status-history", handle(g, "/auth.AdminService/GetUserStatusHistory", service.GetUserStatusHistory))
+	mux.Handle("POST /v1/admin/restore-user", handle(g, "/auth.AdminService/RestoreUser", service.RestoreUser))
+	mux.Handle("POST /v1/admin/set-user-max-devices", handle(g, "/auth.AdminService/SetUserMaxDevices", service.SetUserMaxDevices))
+	mux.Handle("POST /v1/admin/roles/create", handle(g, "/auth.AdminService/CreateRole", service.CreateRole))
+	mux.Handle("POST /v1/admin/roles/delete", handle(g, "/auth.AdminService/DeleteRole", service.DeleteRole))
+	mux.Handle("POST /v1/admin/roles/list", handle(g, "/auth.AdminService/ListRoles", service.ListRoles))
+	mux.Handle("POST /v1/admin/permissions/create", handle(g, "/auth.AdminService/CreatePermission", service.CreatePermission))
+	mux.Handle("POST /v1/admin/permissions/delete", handle(g, "/auth.AdminService/DeletePermission", service.DeletePermission))
+	mux.Handle("POST /v1/admin/permissions/list", handle(g, "/auth.AdminService/ListPermissions", service.ListPermissions))
+	mux.Handle("POST /v1/admin/permissions/grant", handle(g, "/auth.AdminService/GrantPermission", service.GrantPermission))
+	mux.Handle("POST /v1/admin/permissions/revoke", handle(g, "/auth.AdminService/RevokePermission", service.RevokePermission))
+	mux.Handle("POST /v1/admin/user-roles/assign", handle(g, "/auth.AdminService/AssignRole", service.AssignRole))
+	mux.Handle("POST /v1/admin/user-roles/unassign", handle(g, "/auth.AdminService/UnassignRole", service.UnassignRole))
+	mux.Handle("POST /v1/admin/user-roles/list", handle(g, "/auth.AdminService/ListUserRoles", service.ListUserRoles))
+	mux.Handle("POST /v1/admin/users/list", handle(g, "/auth.AdminService/ListUsers", service.ListUsers))
+	mux.Handle("POST /v1/admin/users/get", handle(g, "/auth.AdminService/GetUser", service.GetUser))
+	mux.Handle("POST /v1/admin/users/force-logout", handle(g, "/auth.AdminService/ForceLogout", service.ForceLogout))
+	mux.Handle("POST /v1/admin/users/reset-password", handle(g, "/auth.AdminService/ResetPassword", service.ResetPassword))
+	mux.Handle("POST /v1/admin/users/stats", handle(g, "/auth.AdminService/GetUserStats", service.GetUserStats))
+	mux.Handle("POST /v1/admin/tenants/create", handle(g, "/auth.AdminService/CreateTenant", service.CreateTenant))
+	mux.Handle("POST /v1/admin/tenants/update", handle(g, "/auth.AdminService/UpdateTenant", service.UpdateTenant))
+	mux.Handle("POST /v1/admin/tenants/list", handle(g, "/auth.AdminService/ListTenants", service.ListTenants))
+	mux.Handle("POST /v1/admin/tenants/rotate-signing-key", handle(g, "/auth.AdminService/RotateTenantSigningKey", service.RotateTenantSigningKey))
+	mux.Handle("POST /v1/admin/service-accounts/create", handle(g, "/auth.AdminService/CreateServiceAccount", service.CreateServiceAccount))
+	mux.Handle("POST /v1/admin/service-accounts/update", handle(g, "/auth.AdminService/UpdateServiceAccount", service.UpdateServiceAccount))
+	mux.Handle("POST /v1/admin/service-accounts/delete", handle(g, "/auth.AdminService/DeleteServiceAccount", service.DeleteServiceAccount))
+	mux.Handle("POST /v1/admin/service-accounts/list", handle(g, "/auth.AdminService/ListServiceAccounts", service.ListServiceAccounts))
+	mux.Handle("POST /v1/admin/service-accounts/secrets/add", handle(g, "/auth.AdminService/AddServiceAccountSecret", service.AddServiceAccountSecret))
+	mux.Handle("POST /v1/admin/service-accounts/secrets/remove", handle(g, "/auth.AdminService/RemoveServiceAccountSecret", service.RemoveServiceAccountSecret))
+	mux.Handle("POST /v1/admin/service-accounts/api-keys/create", handle(g, "/auth.AdminService/CreateServiceAccountApiKey", service.CreateServiceAccountApiKey))
+	mux.Handle("POST /v1/admin/service-accounts/api-keys/list", handle(g, "/auth.AdminService/ListServiceAccountApiKeys", service.ListServiceAccountApiKeys))
+	mux.Handle("POST /v1/admin/service-accounts/api-keys/revoke", handle(g, "/auth.AdminService/RevokeServiceAccountApiKey", service.RevokeServiceAccountApiKey))
+
+	mux.Handle("POST /v1/admin/oauth-clients/create", handle(g, "/auth.AdminService/CreateOAuthClient", service.CreateOAuthClient))
+	mux.Handle("POST /v1/admin/oauth-clients/update", handle(g, "/auth.AdminService/UpdateOAuthClient", service.UpdateOAuthClient))
+	mux.Handle("POST /v1/admin/oauth-clients/delete", handle(g, "/auth.AdminService/DeleteOAuthClient", service.DeleteOAuthClient))
+	mux.Handle("POST /v1/admin/oauth-clients/list", handle(g, "/auth.AdminService/ListOAuthClients", service.ListOAuthClients))
+
+	mux.Handle("GET /v1/tenants/{tenant}/jwks.json", http.HandlerFunc(g.tenantJwks))
+	mux.Handle("GET /oauth/authorize", http.HandlerFunc(g.oauthAuthorize))
+	mux.Handle("POST /oauth/authorize", http.HandlerFunc(g.oauthAuthorize))
+	mux.Handle("POST /oauth/token", http.HandlerFunc(g.oauthToken))
+	mux.Handle("GET /oauth/jwks.json", http.HandlerFunc(g.openIdJwks))
+	mux.Handle("GET /.well-known/openid-configuration", http.HandlerFunc(g.openIdConfiguration))
+	mux.Handle("GET /userinfo", http.HandlerFunc(g.userInfo))
+	mux.Handle("POST /userinfo", http.HandlerFunc(g.userInfo))
+
+	g.httpServer = &http.Server{
+		Addr:         cfg.BindAddr,
+		Handler:      g.cors(g.limitBody(mux)),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+	return g
+}
+
+func (g *Gateway) Start() error {
+	if err := g.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+func (g *Gateway) Stop(ctx context.Context) error {
+	return g.httpServer.Shutdown(ctx)
+}
+
+// handle binds an HTTP route to a service method registered under fullMethod
+func handle[Req any, Resp any](g *Gateway, fullMethod string, call func(context.Context, *Req) (*Resp, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := new(Req)
+		if err := decode(r, req); err != nil {
+			g.lg.Error(err.Error(), slog.String("owner", "gateway"), slog.String("method", fullMethod))
+			g.writeError(w, status.Error(codes.InvalidArgument, srvErr.ErrInvalidRequestBody.Error()))
+			return
+		}
+		if err := g.beforeCall(r, req); err != nil {
+			g.writeError(w, err)
+			return
+		}
+		resp, stream, err := g.invoke(r, fullMethod, req, func(ctx context.Context, req any) (any, error) {
+			return call(ctx, req.(*Req))
+		})
+		writeMetadata(w, stream)
+		if err != nil {
+			g.writeError(w, err)
+			return
+		}
+		g.afterCall(w, resp)
+		g.write(w, http.StatusOK, resp)
+	})
+}
+
+// invoke passes the request through the interceptor chain as the grpc call of fullMethod
+func (g *Gateway) invoke(r *http.Request, fullMethod string, req any, handler grpc.UnaryHandler) (any, *serverTransportStream, error) {
+	stream := &serverTransportStream{method: fullMethod}
+	ctx := g.incomingContext(r, stream)
+	resp, err := g.interceptor(ctx, req, &grpc.UnaryServerInfo{Server: g.service, FullMethod: fullMethod}, handler)
+	return resp, stream, err
+}
+
+// incomingContext makes the HTTP request look like an incoming grpc call to the interceptors and the service
+func (g *Gateway) incomingContext(r *http.Request, stream *serverTransportStream) context.Context {
+	md := metadata.MD{}
+	for key, values := range r.Header {
+		switch key = strings.ToLower(key); key {
+		case "cookie", "connection", "content-length", "content-type", "host":
+			continue
+		}
+		md.Append(key, values...)
+	}
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(addrPort)})
+	}
+	return grpc.NewContextWithServerTransportStream(ctx, stream)
+}
+
+// beforeCall substitutes the refresh token taken from the cookie
+func (g *Gateway) beforeCall(r *http.Request, req any) error {
+	if !g.cfg.RefreshTokenCookie {
+		return nil
+	}
+	if req, ok := req.(*proto.RefreshTokenRequest); ok && req.RefreshTokenId == "" {
+		cookie, err := r.Cookie(g.cfg.RefreshTokenCookieName)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, srvErr.ErrInvalidRefreshToken.Error())
+		}
+		claims, err := g.service.ParseToken(cookie.Value)
+		if err != nil || claims.TokenType != "refresh" || claims.Jti == nil {
+			g.lg.Error("refresh token cookie verification error", slog.String("owner", "gateway"))
+			return status.Error(codes.Unauthenticated, srvErr.ErrInvalidRefreshToken.Error())
+		}
+		req.RefreshTokenId = claims.Jti.String()
+	}
+	return nil
+}
+
+// afterCall moves the refresh token from the response body to the HttpOnly cookie
+func (g *Gateway) afterCall(w http.ResponseWriter, resp any) {
+	if !g.cfg.RefreshTokenCookie {
+		return
+	}
+	switch resp := resp.(type) {
+	case *proto.LoginResponse:
+		g.setRefreshTokenCookie(w, resp.RefreshToken)
+		resp.RefreshToken = ""
+	case *proto.RefreshTokenResponse:
+		g.setRefreshTokenCookie(w, resp.RefreshToken)
+		resp.RefreshToken = ""
+	case *proto.LogoutResponse, *proto.UpdatePasswordResponse, *proto.UnregisterResponse:
+		g.setRefreshTokenCookie(w, "")
+	}
+}
+func (g *Gateway) setRefreshTokenCookie(w http.ResponseWriter, refreshToken string) {
+	cookie := &http.Cookie{
+		Name:     g.cfg.RefreshTokenCookieName,
+		Value:    refreshToken,
+		Path:     g.cfg.RefreshTokenCookiePath,
+		Domain:   g.cfg.RefreshTokenCookieDomain,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	}
+	if refreshToken != "" {
+		claims, err := g.service.ParseToken(refreshToken)
+		if err != nil {
+			g.lg.Error(err.Error(), slog.String("owner", "gateway"))
+			return
+		}
+		cookie.Expires = claims.ExpiresAt.Time
+		cookie.MaxAge = int(time.Until(claims.ExpiresAt.Time).Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+func (g *Gateway) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !g.isAllowedOrigin(origin) {
+			if r.Method == http.MethodOptions && origin != "" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if g.cfg.CorsAllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(g.cfg.CorsAllowedMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(g.cfg.CorsAllowedHeaders, ", "))
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(g.cfg.CorsMaxAge.Seconds())))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody bounds the size of the request bodies, the larger ones fail to be read
+func (g *Gateway) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, g.cfg.MaxBodySize)
+		next.ServeHTTP(w, r)
+	})
+}
+func (g *Gateway) isAllowedOrigin(origin string) bool {
+	for _, allowedOrigin := range g.cfg.CorsAllowedOrigins {
+		// the wildcard never allows the credentials, they need the explicit origins
+		if (allowedOrigin == "*" && !g.cfg.CorsAllowCredentials) || strings.EqualFold(allowedOrigin, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantJwks publishes the public keys the tokens of the tenant are verified with
+func (g *Gateway) tenantJwks(w http.ResponseWriter, r *http.Request) {
+	keys, err := g.service.TenantJwks(r.PathValue("tenant"))
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	g.write(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+func decode(r *http.Request, req any) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	if message, ok := req.(protobuf.Message); ok {
+		return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, message)
+	}
+	return json.Unmarshal(body, req)
+}
+func (g *Gateway) write(w http.ResponseWriter, code int, resp any) {
+	var (
+		body []byte
+		err  error
+	)
+	if message, ok := resp.(protobuf.Message); ok {
+		body, err = protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(message)
+	} else {
+		body, err = json.Marshal(resp)
+	}
+	if err != nil {
+		g.lg.Error(err.Error(), slog.String("owner", "gateway"))
+		code = http.StatusInternalServerError
+		body = []byte(`{"code":13,"message":"` + srvErr.ErrInternalServerError.Error() + `"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+func (g *Gateway) writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	g.write(w, HTTPStatusFromCode(st.Code()), st.Proto())
+}
+
+// writeMetadata passes the grpc headers and trailers set by the service to the HTTP response
+func writeMetadata(w http.ResponseWriter, stream *serverTransportStream) {
+	if retryAfter := stream.header.Get("retry-after"); len(retryAfter) != 0 {
+		w.Header().Set("Retry-After", retryAfter[0])
+	}
+	for key, values := range stream.header {
+		w.Header().Add("Access-Control-Expose-Headers", "Grpc-Metadata-"+key)
+		for _, value := range values {
+			w.Header().Add("Grpc-Metadata-"+key, value)
+		}
+	}
+	for key, values := range stream.trailer {
+		w.Header().Add("Access-Control-Expose-Headers", "Grpc-Trailer-"+key)
+		for _, value := range values {
+			w.Header().Add("Grpc-Trailer-"+key, value)
+		}
+	}
+}
+
+// HTTPStatusFromCode maps grpc status codes to HTTP statuses
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// serverTransportStream collects the metadata that the service sets with grpc.SetHeader and grpc.SetTrailer
+type serverTransportStream struct {
+	method  string
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *serverTransportStream) Method() string {
+	return s.method
+}
+func (s *serverTransportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+func (s *serverTransportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+func (s *serverTransportStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
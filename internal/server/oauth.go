@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	srvErr "ppAuthService/internal/server/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/secure"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// authorizeParams are the parameters of the authorization request the login page passes on
+var authorizeParams = []string{"response_type", "client_id", "redirect_uri", "scope", "state", "code_challenge", "code_challenge_method", "nonce"}
+
+// the login page puts the anti-CSRF token into the form and into the cookie, the POST must carry both
+const (
+	authorizeCsrfCookie = "oauth_csrf"
+	authorizeCsrfField  = "csrf_token"
+)
+
+// oauthErrors are the RFC 6749 error codes of the service errors
+var oauthErrors = map[string]string{
+	svcErr.ErrInvalidClient.Error():        "invalid_client",
+	svcErr.ErrInvalidGrant.Error():         "invalid_grant",
+	svcErr.ErrUnsupportedGrantType.Error(): "unsupported_grant_type",
+	svcErr.ErrInvalidArgumentScope.Error(): "invalid_scope",
+}
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in</title>
+</head>
+<body>
+{{- if .Fatal}}
+<p>{{.Error}}</p>
+{{- else}}
+<h1>Sign in{{with .ClientName}} to continue to {{.}}{{end}}</h1>
+{{- with .Error}}
+<p role="alert">{{.}}</p>
+{{- end}}
+<form method="post" action="authorize">
+<input type="hidden" name="csrf_token" value="{{.CsrfToken}}">
+{{- range $name, $value := .Params}}
+<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{- end}}
+{{- if .MfaToken}}
+<input type="hidden" name="mfa_token" value="{{.MfaToken}}">
+<p><label>Code <input name="mfa_code" autocomplete="one-time-code" required autofocus></label></p>
+{{- else}}
+<p><label>Login <input name="login" value="{{.Login}}" autocomplete="username" required autofocus></label></p>
+<p><label>Password <input name="password" type="password" autocomplete="current-password" required></label></p>
+{{- end}}
+<p><button type="submit">Sign in</button></p>
+</form>
+{{- end}}
+</body>
+</html>
+`))
+
+type authorizePage struct {
+	// Fatal is set when the request can not be redirected back to the client
+	Fatal      bool
+	Error      string
+	ClientName string
+	Login      string
+	MfaToken   string
+	CsrfToken  string
+	Params     map[string]string
+}
+
+// oauthAuthorize is the authorization endpoint of the code flow. GET shows the login page, POST checks the
+// credentials with the same rules as Login and redirects to the client with the code
+func (g *Gateway) oauthAuthorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		g.lg.Error(err.Error(), slog.String("owner", "gateway"))
+		g.writeAuthorizePage(w, http.StatusBadRequest, &authorizePage{Fatal: true, Error: srvErr.ErrInvalidRequestBody.Error()})
+		return
+	}
+	page := &authorizePage{Login: r.PostForm.Get("login"), Params: map[string]string{}}
+	for _, name := range authorizeParams {
+		if value := r.Form.Get(name); value != "" {
+			page.Params[name] = value
+		}
+	}
+	if r.Method == http.MethodPost && !checkAuthorizeCsrf(r) {
+		g.lg.Warn("csrf token verification error", slog.String("owner", "gateway"), slog.String("origin", r.Header.Get("Origin")))
+		page.Error = srvErr.ErrInvalidFormToken.Error()
+		g.writeAuthorizePage(w, http.StatusForbidden, page)
+		return
+	}
+	req := &svcDto.AuthorizeRequest{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientId:            r.Form.Get("client_id"),
+		RedirectUri:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Nonce:               r.Form.Get("nonce"),
+	}
+	fullMethod, call := "/auth.OAuthService/CheckAuthorize", g.service.CheckAuthorize
+	redirectCode := http.StatusFound
+	if r.Method == http.MethodPost {
+		req.Login = r.PostForm.Get("login")
+		req.Password = r.PostForm.Get("password")
+		req.MfaToken = r.PostForm.Get("mfa_token")
+		req.MfaCode = r.PostForm.Get("mfa_code")
+		fullMethod, call = "/auth.OAuthService/Authorize", g.service.Authorize
+		redirectCode = http.StatusSeeOther
+	}
+	resp, _, err := g.invoke(r, fullMethod, req, func(ctx context.Context, req any) (any, error) {
+		return call(ctx, req.(*svcDto.AuthorizeRequest))
+	})
+	if err != nil {
+		st := status.Convert(err)
+		page.Error = st.Message()
+		switch st.Message() {
+		case svcErr.ErrInvalidOAuthClient.Error(), svcErr.ErrInvalidRedirectUri.Error():
+			page.Fatal = true
+		case svcErr.ErrInvalidMfaCode.Error():
+			// the challenge is still valid, the user enters the code again
+			page.MfaToken = req.MfaToken
+		}
+		if st.Code() == codes.Internal {
+			page.Error = srvErr.ErrInternalServerError.Error()
+		}
+		g.writeAuthorizePage(w, HTTPStatusFromCode(st.Code()), page)
+		return
+	}
+	authorizeResp := resp.(*svcDto.AuthorizeResponse)
+	if authorizeResp.RedirectUri != "" {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, authorizeResp.RedirectUri, redirectCode)
+		return
+	}
+	page.ClientName = authorizeResp.ClientName
+	page.MfaToken = authorizeResp.MfaToken
+	g.writeAuthorizePage(w, http.StatusOK, page)
+}
+
+// checkAuthorizeCsrf accepts the POST of the login page: the origin, when the browser sends it, is the gateway
+// and the token of the form matches the one of the cookie
+func checkAuthorizeCsrf(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return false
+		}
+	}
+	cookie, err := r.Cookie(authorizeCsrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get(authorizeCsrfField))) == 1
+}
+
+// writeAuthorizePage writes the page, the form gets a new anti-CSRF token each time
+func (g *Gateway) writeAuthorizePage(w http.ResponseWriter, code int, page *authorizePage) {
+	if !page.Fatal {
+		csrfToken, err := secure.GenerateToken(32)
+		if err != nil {
+			g.lg.Error(err.Error(), slog.String("owner", "gateway"))
+			g.writeAuthorizePage(w, http.StatusInternalServerError, &authorizePage{Fatal: true, Error: srvErr.ErrInternalServerError.Error()})
+			return
+		}
+		page.CsrfToken = csrfToken
+		http.SetCookie(w, &http.Cookie{
+			Name:     authorizeCsrfCookie,
+			Value:    csrfToken,
+			Path:     "/oauth/authorize",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(code)
+	if err := authorizeTemplate.Execute(w, page); err != nil {
+		g.lg.Error(err.Error(), slog.String("owner", "gateway"))
+	}
+}
+
+// oauthToken is the token endpoint of the code flow, the request is form encoded and the client may authenticate
+// with the HTTP basic authentication, RFC 6749 2.3.1
+func (g *Gateway) oauthToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := r.ParseForm(); err != nil {
+		g.lg.Error(err.Error(), slog.String("owner", "gateway"))
+		g.writeOAuthError(w, status.Error(codes.InvalidArgument, srvErr.ErrInvalidRequestBody.Error()))
+		return
+	}
+	req := &svcDto.OAuthTokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		ClientId:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		Code:         r.PostForm.Get("code"),
+		RedirectUri:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+	}
+	if clientId, clientSecret, ok := r.BasicAuth(); ok {
+		// the credentials are form encoded before the basic encoding
+		var err error
+		if req.ClientId, err = url.QueryUnescape(clientId); err == nil {
+			req.ClientSecret, err = url.QueryUnescape(clientSecret)
+		}
+		if err != nil {
+			g.writeOAuthError(w, status.Error(codes.Unauthenticated, svcErr.ErrInvalidClient.Error()))
+			return
+		}
+	}
+	resp, stream, err := g.invoke(r, "/auth.OAuthService/Token", req, func(ctx context.Context, req any) (any, error) {
+		return g.service.OAuthToken(ctx, req.(*svcDto.OAuthTokenRequest))
+	})
+	writeMetadata(w, stream)
+	if err != nil {
+		g.writeOAuthError(w, err)
+		return
+	}
+	g.write(w, http.StatusOK, resp)
+}
+
+// writeOAuthError writes the error response of RFC 6749 5.2
+func (g *Gateway) writeOAuthError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := HTTPStatusFromCode(st.Code())
+	errorCode, ok := oauthErrors[st.Message()]
+	switch {
+	case ok && errorCode == "invalid_client":
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		code = http.StatusUnauthorized
+	case ok:
+		code = http.StatusBadRequest
+	case st.Code() == codes.ResourceExhausted:
+		errorCode = "temporarily_unavailable"
+	case code >= http.StatusInternalServerError:
+		errorCode = "server_error"
+	case st.Code() == codes.InvalidArgument:
+		errorCode = "invalid_request"
+	default:
+		// the user of the grant is not active anymore
+		errorCode, code = "invalid_grant", http.StatusBadRequest
+	}
+	description := st.Message()
+	if code >= http.StatusInternalServerError {
+		description = srvErr.ErrInternalServerError.Error()
+	}
+	g.write(w, code, map[string]string{"error": errorCode, "error_description": description})
+}
+
+// openIdConfiguration publishes the OpenID provider metadata
+func (g *Gateway) openIdConfiguration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	g.write(w, http.StatusOK, g.service.OpenIdConfiguration())
+}
+
+// openIdJwks publishes the keys the ID tokens and the access tokens are verified with
+func (g *Gateway) openIdJwks(w http.ResponseWriter, r *http.Request) {
+	keys, err := g.service.OpenIdJwks()
+	if err != nil {
+		g.writeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	g.write(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+// userInfo is the OpenID Connect userinfo endpoint, the access token is taken from the authorization header
+func (g *Gateway) userInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	resp, _, err := g.invoke(r, "/auth.OAuthService/UserInfo", &svcDto.UserInfoRequest{}, func(ctx context.Context, req any) (any, error) {
+		return g.service.UserInfo(ctx, req.(*svcDto.UserInfoRequest))
+	})
+	if err != nil {
+		g.writeBearerError(w, err)
+		return
+	}
+	g.write(w, http.StatusOK, resp)
+}
+
+// writeBearerError writes the error of the protected resource with the WWW-Authenticate header of RFC 6750 3
+func (g *Gateway) writeBearerError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	var errorCode string
+	switch st.Code() {
+	case codes.Unauthenticated:
+		errorCode = "invalid_token"
+	case codes.PermissionDenied:
+		errorCode = "insufficient_scope"
+	default:
+		g.writeError(w, err)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer error="`+errorCode+`", error_description="`+st.Message()+`"`)
+	g.write(w, HTTPStatusFromCode(st.Code()), map[string]string{"error": errorCode, "error_description": st.Message()})
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ppAuthService/internal/config"
+	srvErr "ppAuthService/internal/server/err"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+type quota struct {
+	rate  float64 // tokens per second
+	burst float64
+}
+
+// parseQuota parses the quota in the form requests/period, e.g. 5/1m. "unlimited" turns the limit off
+func parseQuota(value string) (*quota, error) {
+	if value == "unlimited" {
+		return nil, nil
+	}
+	requestsValue, periodValue, ok := strings.Cut(value, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid quota value %q", value)
+	}
+	requests, err := strconv.Atoi(requestsValue)
+	if err != nil || requests <= 0 {
+		return nil, fmt.Errorf("invalid quota value %q", value)
+	}
+	period, err := time.ParseDuration(periodValue)
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("invalid quota value %q", value)
+	}
+	return &quota{
+		rate:  float64(requests) / period.Seconds(),
+		burst: float64(requests),
+	}, nil
+}
+
+// parseClientQuotas parses the quotas of the clients in the form key=quota;key=quota
+func parseClientQuotas(value string) (map[string]*quota, error) {
+	clientQuotas := map[string]*quota{}
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		// the key may contain "=" (the subject of the certificate), the quota never does
+		i := strings.LastIndex(item, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid client quota value %q", item)
+		}
+		clientQuota, err := parseQuota(item[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		clientQuotas[item[:i]] = clientQuota
+	}
+	return clientQuotas, nil
+}
+
+type bucket struct {
+	key       string
+	tokens    float64
+	updatedAt time.Time
+	// the time the empty bucket takes to fill up
+	refill time.Duration
+}
+
+func newBucket(key string, q *quota, now time.Time) *bucket {
+	return &bucket{key: key, tokens: q.burst, updatedAt: now, refill: time.Duration(q.burst / q.rate * float64(time.Second))}
+}
+
+// take refills the bucket for the time passed and takes a token, otherwise returns the time until the next token
+func (b *bucket) take(q *quota, now time.Time) (bool, time.Duration) {
+	b.tokens = math.Min(q.burst, b.tokens+now.Sub(b.updatedAt).Seconds()*q.rate)
+	b.updatedAt = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / q.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// idle reports whether the bucket is full again and unused long enough to be dropped
+func (b *bucket) idle(now time.Time, idleTimeout time.Duration) bool {
+	return now.Sub(b.updatedAt) >= max(idleTimeout, b.refill)
+}
+
+// ApiKeyFunc returns the prefix of the verified api key the call is made with, empty when there is none
+type ApiKeyFunc func(ctx context.Context) string
+
+// RateLimiter is a token bucket limiter keyed by method and client
+type RateLimiter struct {
+	mu sync.Mutex
+	// buckets by key and their use order, the least recently used one is at the back
+	buckets map[string]*list.Element
+	order   *list.List
+	// the buckets by method shared by the clients that come while all the buckets are in use
+	overflow     map[string]*bucket
+	defaultQuota *quota
+	methodQuotas map[string]*quota
+	clientQuotas map[string]*quota
+	apiKeyFunc   ApiKeyFunc
+	cfg          *config.RateLimit
+}
+
+func NewRateLimiter(cfg *config.RateLimit, apiKeyFunc ApiKeyFunc) (*RateLimiter, error) {
+	defaultQuota, err := parseQuota(cfg.Default)
+	if err != nil {
+		return nil, err
+	}
+	methodQuotas := make(map[string]*quota, len(cfg.Methods))
+	for method, value := range cfg.Methods {
+		methodQuota, err := parseQuota(value)
+		if err != nil {
+			return nil, err
+		}
+		methodQuotas[method] = methodQuota
+	}
+	clientQuotas, err := parseClientQuotas(cfg.Clients)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Key {
+	case "ip", "mtls", "api-key":
+	default:
+		return nil, fmt.Errorf("invalid rate limit key %q", cfg.Key)
+	}
+	if cfg.MaxBuckets < 1 {
+		return nil, fmt.Errorf("invalid rate limit max buckets %d", cfg.MaxBuckets)
+	}
+	return &RateLimiter{
+		buckets:      make(map[string]*list.Element),
+		order:        list.New(),
+		overflow:     make(map[string]*bucket),
+		defaultQuota: defaultQuota,
+		methodQuotas: methodQuotas,
+		clientQuotas: clientQuotas,
+		apiKeyFunc:   apiKeyFunc,
+		cfg:          cfg,
+	}, nil
+}
+
+// quota returns the quota of the client when it has one, otherwise the quota of the method, looked up by the full
+// and by the short method name
+func (l *RateLimiter) quota(fullMethod string, clientKey string) *quota {
+	if clientQuota, ok := l.clientQuotas[clientKey]; ok {
+		return clientQuota
+	}
+	if methodQuota, ok := l.methodQuotas[fullMethod]; ok {
+		return methodQuota
+	}
+	if methodQuota, ok := l.methodQuotas[fullMethod[strings.LastIndex(fullMethod, "/")+1:]]; ok {
+		return methodQuota
+	}
+	return l.defaultQuota
+}
+
+// clientKey identifies the client by the configured key, falling back to the ip. The api key is the one of the
+// verified access token, so a client can not get a new bucket by sending another value
+func (l *RateLimiter) clientKey(ctx context.Context) string {
+	switch l.cfg.Key {
+	case "mtls":
+		if p, ok := peer.FromContext(ctx); ok {
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.PeerCertificates) != 0 {
+				return "mtls:" + tlsInfo.State.PeerCertificates[0].Subject.String()
+			}
+		}
+	case "api-key":
+		if prefix := l.apiKeyFunc(ctx); prefix != "" {
+			return "api-key:" + prefix
+		}
+	}
+	if ip, ok := realip.FromContext(ctx); ok {
+		return "ip:" + ip.String()
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		// the port differs between the connections of the same client
+		if addrPort, err := netip.ParseAddrPort(p.Addr.String()); err == nil {
+			return "ip:" + addrPort.Addr().String()
+		}
+		return "ip:" + p.Addr.String()
+	}
+	return "unknown"
+}
+
+// take takes a token from the bucket of the client and the method, otherwise returns the time until the next token.
+// While all the buckets are in use, the new clients of the method share one overflow bucket, so that a client can
+// not get its exhausted bucket dropped by filling the limiter with new keys
+func (l *RateLimiter) take(fullMethod string, clientKey string, q *quota, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the idle buckets are full again, dropping them changes nothing
+	for back := l.order.Back(); back != nil && back.Value.(*bucket).idle(now, l.cfg.IdleTimeout); back = l.order.Back() {
+		delete(l.buckets, back.Value.(*bucket).key)
+		l.order.Remove(back)
+	}
+
+	key := fullMethod + "|" + clientKey
+	var b *bucket
+	if element, ok := l.buckets[key]; ok {
+		l.order.MoveToFront(element)
+		b = element.Value.(*bucket)
+	} else if l.order.Len() < l.cfg.MaxBuckets {
+		b = newBucket(key, q, now)
+		l.buckets[key] = l.order.PushFront(b)
+	} else {
+		if b = l.overflow[fullMethod]; b == nil {
+			b = newBucket(fullMethod, q, now)
+			l.overflow[fullMethod] = b
+		}
+	}
+	return b.take(q, now)
+}
+
+func (l *RateLimiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		clientKey := l.clientKey(ctx)
+		q := l.quota(info.FullMethod, clientKey)
+		if q == nil {
+			return handler(ctx, req)
+		}
+		if ok, retryAfter := l.take(info.FullMethod, clientKey, q, time.Now()); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(seconds)))
+			return nil, status.Error(codes.ResourceExhausted, srvErr.ErrRateLimitExceeded.Error())
+		}
+		return handler(ctx, req)
+	}
+}
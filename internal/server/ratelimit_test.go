@@ -0,0 +1,143 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"ppAuthService/internal/config"
+)
+
+func TestParseQuota(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    *quota
+		wantErr bool
+	}{
+		{value: "5/1s", want: &quota{rate: 5, burst: 5}},
+		{value: "60/1m", want: &quota{rate: 1, burst: 60}},
+		{value: "unlimited", want: nil},
+		{value: "5", wantErr: true},
+		{value: "0/1s", wantErr: true},
+		{value: "-1/1s", wantErr: true},
+		{value: "x/1s", wantErr: true},
+		{value: "5/0s", wantErr: true},
+		{value: "5/1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseQuota(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseQuota(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("parseQuota(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseClientQuotas(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]*quota
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: map[string]*quota{}},
+		{name: "clients", value: "ip:10.0.0.5=1000/1s; api-key:ppk_1a2b3c4d5e6f=50/1s;",
+			want: map[string]*quota{"ip:10.0.0.5": {rate: 1000, burst: 1000}, "api-key:ppk_1a2b3c4d5e6f": {rate: 50, burst: 50}}},
+		{name: "key with equals signs", value: "mtls:CN=svc,O=pp=10/1s",
+			want: map[string]*quota{"mtls:CN=svc,O=pp": {rate: 10, burst: 10}}},
+		{name: "unlimited", value: "ip:10.0.0.5=unlimited", want: map[string]*quota{"ip:10.0.0.5": nil}},
+		{name: "missing key", value: "=10/1s", wantErr: true},
+		{name: "missing quota", value: "ip:10.0.0.5", wantErr: true},
+		{name: "invalid quota", value: "ip:10.0.0.5=10", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseClientQuotas(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseClientQuotas(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseClientQuotas(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+			for key, want := range tt.want {
+				q, ok := got[key]
+				if !ok || (q == nil) != (want == nil) || (q != nil && *q != *want) {
+					t.Errorf("parseClientQuotas(%q)[%q] = %+v, want %+v", tt.value, key, q, want)
+				}
+			}
+		})
+	}
+}
+
+func newTestRateLimiter(t *testing.T, maxBuckets int) *RateLimiter {
+	t.Helper()
+	l, err := NewRateLimiter(&config.RateLimit{Default: "2/1s", Key: "ip", IdleTimeout: time.Minute, MaxBuckets: maxBuckets}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	q := &quota{rate: 2, burst: 2}
+	now := time.Now()
+	l := newTestRateLimiter(t, 10)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.take("/m", "ip:a", q, now); !ok {
+			t.Fatalf("take() #%d = false, want true", i)
+		}
+	}
+	ok, retryAfter := l.take("/m", "ip:a", q, now)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Fatalf("take() of the empty bucket = %v, %v, want false, 500ms", ok, retryAfter)
+	}
+	// the buckets are kept by method and client
+	if ok, _ := l.take("/m", "ip:b", q, now); !ok {
+		t.Error("take() of another client = false, want true")
+	}
+	if ok, _ := l.take("/other", "ip:a", q, now); !ok {
+		t.Error("take() of another method = false, want true")
+	}
+	// the bucket refills with the rate
+	if ok, _ := l.take("/m", "ip:a", q, now.Add(500*time.Millisecond)); !ok {
+		t.Error("take() after the refill = false, want true")
+	}
+}
+
+// the exhausted bucket is not dropped for a new client while it is in use, the new clients share the overflow bucket
+func TestRateLimiterTakeFull(t *testing.T) {
+	q := &quota{rate: 2, burst: 2}
+	now := time.Now()
+	l := newTestRateLimiter(t, 1)
+	l.take("/m", "ip:a", q, now)
+	l.take("/m", "ip:a", q, now)
+	if ok, _ := l.take("/m", "ip:b", q, now); !ok {
+		t.Fatal("take() of the first overflow client = false, want true")
+	}
+	if ok, _ := l.take("/m", "ip:c", q, now); !ok {
+		t.Fatal("take() of the second overflow client = false, want true")
+	}
+	if ok, _ := l.take("/m", "ip:d", q, now); ok {
+		t.Error("take() of the exhausted overflow bucket = true, want false")
+	}
+	if ok, _ := l.take("/m", "ip:a", q, now); ok {
+		t.Error("take() of the exhausted bucket = true, want false")
+	}
+	// the idle bucket is dropped and its place is taken by the next new client
+	later := now.Add(time.Minute)
+	if ok, _ := l.take("/m", "ip:e", q, later); !ok {
+		t.Fatal("take() after the idle timeout = false, want true")
+	}
+	if _, ok := l.buckets["/m|ip:e"]; !ok || l.order.Len() != 1 {
+		t.Errorf("buckets = %v, want the bucket of the new client only", l.buckets)
+	}
+}
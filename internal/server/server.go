@@ -2,8 +2,10 @@ package server
 
 import (
 	"context"
+	"log"
 	"log/slog"
 	"net"
+	"net/netip"
 	"os"
 	"os/signal"
 	"syscall"
@@ -15,6 +17,7 @@ import (
 	proto "github.com/MedvedevEA/ppProtos/gen/auth"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/logging"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/recovery"
 
 	"google.golang.org/grpc"
@@ -25,6 +28,7 @@ import (
 type Server struct {
 	lg         *slog.Logger
 	grpcServer *grpc.Server
+	gateway    *Gateway
 	cfg        *config.Server
 }
 
@@ -33,7 +37,7 @@ func InterceptorLogger(l *slog.Logger) logging.Logger {
 		l.Log(ctx, slog.Level(lvl), msg, fields...)
 	})
 }
-func MustNew(service *service.Service, lg *slog.Logger, cfg *config.Server) *Server {
+func MustNew(service *service.Service, lg *slog.Logger, cfg *config.Config) *Server {
 	loggingOpts := []logging.Option{
 		logging.WithLogOnEvents(logging.FinishCall),
 	}
@@ -43,22 +47,54 @@ func MustNew(service *service.Service, lg *slog.Logger, cfg *config.Server) *Ser
 			return status.Error(codes.Internal, srvErr.ErrInternalServerError.Error())
 		}),
 	}
-	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+	trustedProxies := make([]netip.Prefix, 0, len(cfg.Server.TrustedProxies))
+	for _, trustedProxy := range cfg.Server.TrustedProxies {
+		prefix, err := netip.ParsePrefix(trustedProxy)
+		if err != nil {
+			log.Fatalf("failed to initialize server: %v\n", err)
+		}
+		trustedProxies = append(trustedProxies, prefix)
+	}
+	realipOpts := []realip.Option{
+		realip.WithTrustedPeers(trustedProxies),
+		realip.WithTrustedProxies(trustedProxies),
+		realip.WithHeaders([]string{realip.XForwardedFor, realip.XRealIp}),
+	}
+	interceptors := []grpc.UnaryServerInterceptor{
 		recovery.UnaryServerInterceptor(recoveryOpts...),
+		realip.UnaryServerInterceptorOpts(realipOpts...),
 		logging.UnaryServerInterceptor(InterceptorLogger(lg), loggingOpts...),
-	))
+	}
+	if cfg.RateLimit.Enabled {
+		rateLimiter, err := NewRateLimiter(&cfg.RateLimit, service.RequestApiKey)
+		if err != nil {
+			log.Fatalf("failed to initialize server: %v\n", err)
+		}
+		interceptors = append(interceptors, rateLimiter.UnaryServerInterceptor())
+	}
+	interceptors = append(interceptors, AuthInterceptor(service))
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 	proto.RegisterAuthServiceServer(grpcServer, service)
+	grpcServer.RegisterService(authServiceExtDesc(service), service)
+	grpcServer.RegisterService(authzServiceDesc(service), service)
+	grpcServer.RegisterService(relationServiceDesc(service), service)
+	grpcServer.RegisterService(adminServiceDesc(service), service)
+
+	var gateway *Gateway
+	if cfg.Gateway.Enabled {
+		gateway = NewGateway(service, lg, &cfg.Gateway, ChainUnaryInterceptors(interceptors...))
+	}
 
 	return &Server{
 		lg:         lg,
 		grpcServer: grpcServer,
-		cfg:        cfg,
+		gateway:    gateway,
+		cfg:        &cfg.Server,
 	}
 }
 
 func (s *Server) Start() {
-	chErr := make(chan error, 1)
-	defer close(chErr)
+	chErr := make(chan error, 3)
 
 	go func() {
 		s.lg.Info("server is started", slog.String("owner", "server"), slog.String("bindAddress", s.cfg.BindAddr))
@@ -69,17 +105,50 @@ func (s *Server) Start() {
 		}
 		chErr <- s.grpcServer.Serve(listener)
 	}()
+	if s.gateway != nil {
+		go func() {
+			s.lg.Info("gateway is started", slog.String("owner", "server"), slog.String("bindAddress", s.gateway.cfg.BindAddr))
+			chErr <- s.gateway.Start()
+		}()
+	}
 	go func() {
 		chQuit := make(chan os.Signal, 1)
 		signal.Notify(chQuit, syscall.SIGINT, syscall.SIGTERM)
 		<-chQuit
-		s.grpcServer.Stop()
 		chErr <- nil
 	}()
-	if err := <-chErr; err != nil {
+	err := <-chErr
+	s.stop()
+	if err != nil {
 		s.lg.Error(err.Error(), slog.String("owner", "server"))
 		return
 	}
 	s.lg.Info("server is stoped", slog.String("owner", "server"))
 
 }
+
+func (s *Server) stop() {
+	if s.gateway != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.WriteTimeout)
+		defer cancel()
+		if err := s.gateway.Stop(ctx); err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", "server"))
+		}
+	}
+	s.grpcServer.Stop()
+}
+
+// ChainUnaryInterceptors folds interceptors into one, so that calls that do not come through
+// the grpc transport (see Gateway) pass through the same chain as grpc calls
+func ChainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
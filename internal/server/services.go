@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"ppAuthService/internal/service"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// jsonCodec marshals the messages of the services that have no proto definition, the clients call them with
+// the json content subtype (content-type application/grpc+json)
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonMethod is the grpc method of the service call, the request and the response are the messages of the gateway
+func jsonMethod[Req any, Resp any](serviceName string, methodName string, call func(context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: methodName,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				return call(ctx, req.(*Req))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + methodName}, handler)
+		},
+	}
+}
+
+// authServiceExtDesc describes the methods of the AuthService that the proto module lacks. The messages are the JSON
+// of the gateway, so the grpc clients and the gateway share one contract
+func authServiceExtDesc(s *service.Service) *grpc.ServiceDesc {
+	const name = "auth.AuthServiceExt"
+	return &grpc.ServiceDesc{
+		ServiceName: name,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			jsonMethod(name, "RequestPasswordReset", s.RequestPasswordReset),
+			jsonMethod(name, "ConfirmPasswordReset", s.ConfirmPasswordReset),
+			jsonMethod(name, "VerifyEmail", s.VerifyEmail),
+			jsonMethod(name, "ChangeEmail", s.ChangeEmail),
+			jsonMethod(name, "ResendEmailVerification", s.ResendEmailVerification),
+			jsonMethod(name, "Token", s.Token),
+			jsonMethod(name, "ExchangeApiKey", s.ExchangeApiKey),
+			jsonMethod(name, "ListSessions", s.ListSessions),
+			jsonMethod(name, "RevokeSession", s.RevokeSession),
+			jsonMethod(name, "RevokeAllOtherSessions", s.RevokeAllOtherSessions),
+			jsonMethod(name, "EnrollTotp", s.EnrollTotp),
+			jsonMethod(name, "ConfirmTotp", s.ConfirmTotp),
+			jsonMethod(name, "DisableTotp", s.DisableTotp),
+			jsonMethod(name, "RegenerateRecoveryCodes", s.RegenerateRecoveryCodes),
+			jsonMethod(name, "CreateApiKey", s.CreateApiKey),
+			jsonMethod(name, "ListApiKeys", s.ListApiKeys),
+			jsonMethod(name, "RevokeApiKey", s.RevokeApiKey),
+		},
+		Metadata: "services.go",
+	}
+}
+
+// authzServiceDesc describes the AuthzService, which is not in the proto module
+func authzServiceDesc(s *service.Service) *grpc.ServiceDesc {
+	const name = "auth.AuthzService"
+	return &grpc.ServiceDesc{
+		ServiceName: name,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			jsonMethod(name, "CheckPermission", s.CheckPermission),
+			jsonMethod(name, "BatchCheck", s.BatchCheck),
+		},
+		Metadata: "services.go",
+	}
+}
+
+// relationServiceDesc describes the RelationService, which is not in the proto module
+func relationServiceDesc(s *service.Service) *grpc.ServiceDesc {
+	const name = "auth.RelationService"
+	return &grpc.ServiceDesc{
+		ServiceName: name,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			jsonMethod(name, "Check", s.CheckRelation),
+			jsonMethod(name, "Expand", s.ExpandRelation),
+			jsonMethod(name, "Write", s.WriteRelationTuples),
+			jsonMethod(name, "Read", s.ReadRelationTuples),
+		},
+		Metadata: "services.go",
+	}
+}
+
+// adminServiceDesc describes the AdminService, which is not in the proto module
+func adminServiceDesc(s *service.Service) *grpc.ServiceDesc {
+	const name = "auth.AdminService"
+	return &grpc.ServiceDesc{
+		ServiceName: name,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			jsonMethod(name, "ClearLoginLockout", s.ClearLoginLockout),
+			jsonMethod(name, "SetUserStatus", s.SetUserStatus),
+			jsonMethod(name, "GetUserStatusHistory", s.GetUserStatusHistory),
+			jsonMethod(name, "RestoreUser", s.RestoreUser),
+			jsonMethod(name, "SetUserMaxDevices", s.SetUserMaxDevices),
+			jsonMethod(name, "CreateRole", s.CreateRole),
+			jsonMethod(name, "DeleteRole", s.DeleteRole),
+			jsonMethod(name, "ListRoles", s.ListRoles),
+			jsonMethod(name, "CreatePermission", s.CreatePermission),
+			jsonMethod(name, "DeletePermission", s.DeletePermission),
+			jsonMethod(name, "ListPermissions", s.ListPermissions),
+			jsonMethod(name, "GrantPermission", s.GrantPermission),
+			jsonMethod(name, "RevokePermission", s.RevokePermission),
+			jsonMethod(name, "AssignRole", s.AssignRole),
+			jsonMethod(name, "UnassignRole", s.UnassignRole),
+			jsonMethod(name, "ListUserRoles", s.ListUserRoles),
+			jsonMethod(name, "ListUsers", s.ListUsers),
+			jsonMethod(name, "GetUser", s.GetUser),
+			jsonMethod(name, "ForceLogout", s.ForceLogout),
+			jsonMethod(name, "ResetPassword", s.ResetPassword),
+			jsonMethod(name, "GetUserStats", s.GetUserStats),
+			jsonMethod(name, "CreateTenant", s.CreateTenant),
+			jsonMethod(name, "UpdateTenant", s.UpdateTenant),
+			jsonMethod(name, "ListTenants", s.ListTenants),
+			jsonMethod(name, "RotateTenantSigningKey", s.RotateTenantSigningKey),
+			jsonMethod(name, "CreateServiceAccount", s.CreateServiceAccount),
+			jsonMethod(name, "UpdateServiceAccount", s.UpdateServiceAccount),
+			jsonMethod(name, "DeleteServiceAccount", s.DeleteServiceAccount),
+			jsonMethod(name, "ListServiceAccounts", s.ListServiceAccounts),
+			jsonMethod(name, "AddServiceAccountSecret", s.AddServiceAccountSecret),
+			jsonMethod(name, "RemoveServiceAccountSecret", s.RemoveServiceAccountSecret),
+			jsonMethod(name, "CreateServiceAccountApiKey", s.CreateServiceAccountApiKey),
+			jsonMethod(name, "ListServiceAccountApiKeys", s.ListServiceAccountApiKeys),
+			jsonMethod(name, "RevokeServiceAccountApiKey", s.RevokeServiceAccountApiKey),
+			jsonMethod(name, "CreateOAuthClient", s.CreateOAuthClient),
+			jsonMethod(name, "UpdateOAuthClient", s.UpdateOAuthClient),
+			jsonMethod(name, "DeleteOAuthClient", s.DeleteOAuthClient),
+			jsonMethod(name, "ListOAuthClients", s.ListOAuthClients),
+		},
+		Metadata: "services.go",
+	}
+}
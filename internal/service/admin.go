@@ -0,0 +1,280 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func toAdminUserDto(user *entity.User) *svcDto.AdminUser {
+	return &svcDto.AdminUser{
+		UserId:          user.UserId.String(),
+		TenantId:        user.TenantId.String(),
+		Login:           user.Login,
+		Status:          user.Status,
+		StatusChangedAt: user.StatusChangedAt,
+		Email:           user.Email,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		PendingEmail:    user.PendingEmail,
+		MaxDevices:      user.MaxDevices,
+		DeletedAt:       user.DeletedAt,
+		CreatedAt:       user.CreatedAt,
+	}
+}
+
+// the cursor is the creation time and the id of the last user of the page
+func encodeUserCursor(user *entity.User) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(user.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + user.UserId.String()))
+}
+func decodeUserCursor(cursor string) (*time.Time, *uuid.UUID, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+	createdAtValue, userIdValue, _ := strings.Cut(string(value), ",")
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	userId, err := uuid.Parse(userIdValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &createdAt, &userId, nil
+}
+
+// adminTenantFilter returns the tenant of the name for the admin of the call. The admins of the default tenant see
+// all the tenants and nil, for the empty name, means any tenant. The other admins see only the own tenant
+func (s *Service) adminTenantFilter(ctx context.Context, name string) (*uuid.UUID, error) {
+	var tenantId *uuid.UUID
+	if name != "" {
+		tenant, err := s.store.GetTenantByName(name)
+		if err != nil {
+			if errors.Is(err, repoErr.ErrRecordNotFound) {
+				return nil, status.Error(codes.NotFound, svcErr.ErrTenantNotFound.Error())
+			}
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		tenantId = tenant.TenantId
+	}
+	if *callerTenantId(ctx) == entity.DefaultTenantId {
+		return tenantId, nil
+	}
+	if tenantId != nil && *tenantId != *adminTenantId(ctx) {
+		return nil, status.Error(codes.NotFound, svcErr.ErrTenantNotFound.Error())
+	}
+	return adminTenantId(ctx), nil
+}
+
+func (s *Service) ListUsers(ctx context.Context, req *svcDto.ListUsersRequest) (*svcDto.ListUsersResponse, error) {
+	pageSize := req.PageSize
+	if pageSize == 0 {
+		pageSize = s.admin.DefaultPageSize
+	}
+	if pageSize < 0 || pageSize > s.admin.MaxPageSize {
+		s.lg.Error("invalid page size value", slog.String("owner", "service.ListUsers"), slog.Int("pageSize", req.PageSize))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentPageSize.Error())
+	}
+	dto := &repoDto.ListUsers{
+		CreatedFrom: req.CreatedFrom,
+		CreatedTo:   req.CreatedTo,
+		// one more user tells whether there is the next page
+		Limit: pageSize + 1,
+	}
+	if req.CreatedFrom != nil && req.CreatedTo != nil && !req.CreatedFrom.Before(*req.CreatedTo) {
+		s.lg.Error("invalid created range value", slog.String("owner", "service.ListUsers"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentCreated.Error())
+	}
+	if req.LoginPrefix != "" {
+		dto.LoginPrefix = &req.LoginPrefix
+	}
+	if req.Status != "" {
+		if _, ok := userStatusTransitions[req.Status]; !ok {
+			s.lg.Error("invalid user status value", slog.String("owner", "service.ListUsers"))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentStatus.Error())
+		}
+		dto.Status = &req.Status
+	}
+	if req.Cursor != "" {
+		var err error
+		if dto.AfterCreatedAt, dto.AfterUserId, err = decodeUserCursor(req.Cursor); err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", "service.ListUsers"))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentCursor.Error())
+		}
+	}
+	tenantId, err := s.adminTenantFilter(ctx, req.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	dto.TenantId = tenantId
+	users, err := s.store.ListUsers(dto)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.ListUsersResponse{Users: make([]*svcDto.AdminUser, 0, min(len(users), pageSize))}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		resp.NextCursor = encodeUserCursor(users[len(users)-1])
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, toAdminUserDto(user))
+	}
+	return resp, nil
+}
+func (s *Service) GetUser(ctx context.Context, req *svcDto.GetUserRequest) (*svcDto.GetUserResponse, error) {
+	var (
+		user *entity.User
+		err  error
+	)
+	if req.UserId != "" {
+		userId, parseErr := uuid.Parse(req.UserId)
+		if parseErr != nil {
+			s.lg.Error(parseErr.Error(), slog.String("owner", "service.GetUser"))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+		}
+		user, err = s.store.GetUser(&userId)
+	} else {
+		if req.Login == "" {
+			s.lg.Error("invalid login value", slog.String("owner", "service.GetUser"))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentLogin.Error())
+		}
+		tenantId, tenantErr := s.adminTenantFilter(ctx, req.Tenant)
+		if tenantErr != nil {
+			return nil, tenantErr
+		}
+		if tenantId == nil {
+			tenantId = &entity.DefaultTenantId
+		}
+		user, err = s.store.GetUserByLogin(tenantId, req.Login)
+	}
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	roles, err := s.store.GetRolesByUserId(user.UserId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	userMfa, err := s.getConfirmedUserMfa(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.store.GetSessionsByUserId(user.UserId, time.Now())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.GetUserResponse{
+		User:           toAdminUserDto(user),
+		Roles:          make([]string, 0, len(roles)),
+		MfaEnabled:     userMfa != nil,
+		ActiveSessions: len(sessions),
+	}
+	for _, role := range roles {
+		resp.Roles = append(resp.Roles, role.Name)
+	}
+	return resp, nil
+}
+
+// ForceLogout revokes the refresh tokens, the issued access tokens stay valid until they expire
+func (s *Service) ForceLogout(ctx context.Context, req *svcDto.ForceLogoutRequest) (*svcDto.ForceLogoutResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ForceLogout"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	if req.Reason == "" {
+		s.lg.Error("reason is required", slog.String("owner", "service.ForceLogout"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentReason.Error())
+	}
+	if _, err := s.store.GetUser(&userId); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var deviceCode *string
+	if req.DeviceCode != "" {
+		deviceCode = &req.DeviceCode
+	}
+	if err := s.store.RevokeRefreshTokensByUserIdAndDeviceCode(&repoDto.RevokeRefreshTokensByUserIdAndDeviceCode{
+		UserId:     &userId,
+		DeviceCode: deviceCode,
+	}); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "admin.logout", slog.Any("userId", userId), slog.String("deviceCode", req.DeviceCode), slog.String("reason", req.Reason))
+	return &svcDto.ForceLogoutResponse{}, nil
+}
+
+// ResetPassword sends the password reset to the user and logs the user out everywhere. The support staff never
+// learns the new password
+func (s *Service) ResetPassword(ctx context.Context, req *svcDto.ResetPasswordRequest) (*svcDto.ResetPasswordResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ResetPassword"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	if req.Reason == "" {
+		s.lg.Error("reason is required", slog.String("owner", "service.ResetPassword"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentReason.Error())
+	}
+	user, err := s.store.GetUser(&userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user.Status == entity.UserStatusDeleted {
+		return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+	}
+	if err := s.store.RevokeRefreshTokensByUserIdAndDeviceCode(&repoDto.RevokeRefreshTokensByUserIdAndDeviceCode{
+		UserId:     &userId,
+		DeviceCode: nil,
+	}); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.sendPasswordReset(user, "service.ResetPassword"); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, "admin.password.reset", slog.Any("userId", userId), slog.String("reason", req.Reason))
+	return &svcDto.ResetPasswordResponse{}, nil
+}
+func (s *Service) GetUserStats(ctx context.Context, req *svcDto.GetUserStatsRequest) (*svcDto.GetUserStatsResponse, error) {
+	tenantId, err := s.adminTenantFilter(ctx, req.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.store.GetUserStats(tenantId, time.Now())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &svcDto.GetUserStatsResponse{
+		Total: stats.Total,
+		ByStatus: map[string]int64{
+			entity.UserStatusPending:  stats.Pending,
+			entity.UserStatusActive:   stats.Active,
+			entity.UserStatusLocked:   stats.Locked,
+			entity.UserStatusDisabled: stats.Disabled,
+			entity.UserStatusDeleted:  stats.Deleted,
+		},
+		EmailVerified:   stats.EmailVerified,
+		MfaEnabled:      stats.MfaEnabled,
+		CreatedLastDay:  stats.CreatedLastDay,
+		CreatedLastWeek: stats.CreatedLastWeek,
+	}, nil
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/jwt"
+	"ppAuthService/pkg/secure"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// sizes of the public part that names the key and of the secret part in bytes before encoding
+	apiKeyPublicSize = 6
+	apiKeySecretSize = 32
+)
+
+// generateApiKey returns the key written as prefix_public_secret and its public prefix_public part.
+// The public part is hex, so the key splits unambiguously even though the secret may contain underscores
+func (s *Service) generateApiKey() (string, string, error) {
+	public := make([]byte, apiKeyPublicSize)
+	if _, err := rand.Read(public); err != nil {
+		return "", "", err
+	}
+	secret, err := secure.GenerateToken(apiKeySecretSize)
+	if err != nil {
+		return "", "", err
+	}
+	prefix := s.apiKey.Prefix + "_" + hex.EncodeToString(public)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// apiKeyPrefix returns the public part of the key, empty for the value that is not a key
+func (s *Service) apiKeyPrefix(apiKey string) string {
+	rest, ok := strings.CutPrefix(apiKey, s.apiKey.Prefix+"_")
+	if !ok {
+		return ""
+	}
+	public, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(public) != 2*apiKeyPublicSize || secret == "" {
+		return ""
+	}
+	return s.apiKey.Prefix + "_" + public
+}
+
+// apiKeyExpirationAt returns nil for the empty lifetime, the key does not expire
+func (s *Service) apiKeyExpirationAt(lifetimeValue string, owner string) (*time.Time, error) {
+	if lifetimeValue == "" {
+		return nil, nil
+	}
+	lifetime, err := time.ParseDuration(lifetimeValue)
+	if err != nil || lifetime < time.Second {
+		s.lg.Error("invalid lifetime value", slog.String("owner", owner), slog.String("lifetime", lifetimeValue))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentLifetime.Error())
+	}
+	expirationAt := time.Now().Add(lifetime)
+	return &expirationAt, nil
+}
+
+func isApiKeyExpired(apiKey *entity.ApiKey, now time.Time) bool {
+	return apiKey.ExpirationAt != nil && !apiKey.ExpirationAt.After(now)
+}
+
+// addApiKey creates the key of the user or of the service account. allowedScopes limits the scopes of the key,
+// nil allows any scopes
+func (s *Service) addApiKey(ctx context.Context, dto *repoDto.AddApiKey, allowedScopes []string, lifetime string, owner string) (*svcDto.CreateApiKeyResponse, error) {
+	if strings.TrimSpace(dto.Name) == "" {
+		s.lg.Error("invalid name value", slog.String("owner", owner))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentName.Error())
+	}
+	scopes, err := s.parseScopes(dto.Scopes, owner)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if allowedScopes != nil && !slices.Contains(allowedScopes, scope) {
+			s.lg.Error("scope is not allowed", slog.String("owner", owner), slog.String("scope", scope))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentScope.Error())
+		}
+	}
+	expirationAt, err := s.apiKeyExpirationAt(lifetime, owner)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := s.store.GetApiKeysByOwner(dto.UserId, dto.ServiceAccountId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	now := time.Now()
+	unexpired := 0
+	for _, apiKey := range apiKeys {
+		if !isApiKeyExpired(apiKey, now) {
+			unexpired++
+		}
+	}
+	if unexpired >= s.apiKey.MaxKeys {
+		s.lg.Error("too many unexpired api keys", slog.String("owner", owner))
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrTooManyApiKeys.Error())
+	}
+	apiKey, prefix, err := s.generateApiKey()
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	dto.Scopes = scopes
+	dto.Prefix = prefix
+	dto.KeyHash = secure.GetHash(apiKey)
+	dto.ExpirationAt = expirationAt
+	if _, err := s.store.AddApiKey(dto); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "api_key.create", slog.Any("userId", dto.UserId), slog.Any("serviceAccountId", dto.ServiceAccountId), slog.String("prefix", prefix), slog.Any("scopes", scopes))
+	return &svcDto.CreateApiKeyResponse{
+		ApiKey:       apiKey,
+		Prefix:       prefix,
+		ExpirationAt: expirationAt,
+	}, nil
+}
+func (s *Service) listApiKeys(userId *uuid.UUID, serviceAccountId *uuid.UUID) (*svcDto.ListApiKeysResponse, error) {
+	apiKeys, err := s.store.GetApiKeysByOwner(userId, serviceAccountId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.ListApiKeysResponse{ApiKeys: make([]*svcDto.ApiKey, 0, len(apiKeys))}
+	for _, apiKey := range apiKeys {
+		resp.ApiKeys = append(resp.ApiKeys, &svcDto.ApiKey{
+			Name:         apiKey.Name,
+			Prefix:       apiKey.Prefix,
+			Scopes:       apiKey.Scopes,
+			ExpirationAt: apiKey.ExpirationAt,
+			LastUsedAt:   apiKey.LastUsedAt,
+			CreatedAt:    apiKey.CreatedAt,
+		})
+	}
+	return resp, nil
+}
+
+// revokeApiKey removes the key, the tokens it was exchanged for stay valid until they expire
+func (s *Service) revokeApiKey(ctx context.Context, userId *uuid.UUID, serviceAccountId *uuid.UUID, prefix string) error {
+	apiKey, err := s.store.GetApiKeyByPrefix(prefix)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return status.Error(codes.NotFound, svcErr.ErrApiKeyNotFound.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	// the key of another owner is reported the same as the missing one
+	if (userId != nil && (apiKey.UserId == nil || *apiKey.UserId != *userId)) ||
+		(serviceAccountId != nil && (apiKey.ServiceAccountId == nil || *apiKey.ServiceAccountId != *serviceAccountId)) {
+		return status.Error(codes.NotFound, svcErr.ErrApiKeyNotFound.Error())
+	}
+	if err := s.store.RemoveApiKey(apiKey.ApiKeyId); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return status.Error(codes.NotFound, svcErr.ErrApiKeyNotFound.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "api_key.revoke", slog.Any("userId", userId), slog.Any("serviceAccountId", serviceAccountId), slog.String("prefix", prefix))
+	return nil
+}
+
+func (s *Service) CreateApiKey(ctx context.Context, req *svcDto.CreateApiKeyRequest) (*svcDto.CreateApiKeyResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.CreateApiKey"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	user, err := s.store.GetUser(&userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.checkUserStatus(user, "service.CreateApiKey"); err != nil {
+		return nil, err
+	}
+	return s.addApiKey(ctx, &repoDto.AddApiKey{
+		UserId: user.UserId,
+		Name:   req.Name,
+		Scopes: req.Scopes,
+	}, nil, req.Lifetime, "service.CreateApiKey")
+}
+func (s *Service) ListApiKeys(ctx context.Context, req *svcDto.ListApiKeysRequest) (*svcDto.ListApiKeysResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ListApiKeys"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	return s.listApiKeys(&userId, nil)
+}
+func (s *Service) RevokeApiKey(ctx context.Context, req *svcDto.RevokeApiKeyRequest) (*svcDto.RevokeApiKeyResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.RevokeApiKey"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	if err := s.revokeApiKey(ctx, &userId, nil, req.Prefix); err != nil {
+		return nil, err
+	}
+	return &svcDto.RevokeApiKeyResponse{}, nil
+}
+func (s *Service) CreateServiceAccountApiKey(ctx context.Context, req *svcDto.CreateServiceAccountApiKeyRequest) (*svcDto.CreateApiKeyResponse, error) {
+	serviceAccount, err := s.getServiceAccount(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	return s.addApiKey(ctx, &repoDto.AddApiKey{
+		ServiceAccountId: serviceAccount.ServiceAccountId,
+		Name:             req.Name,
+		Scopes:           req.Scopes,
+	}, serviceAccount.Scopes, req.Lifetime, "service.CreateServiceAccountApiKey")
+}
+func (s *Service) ListServiceAccountApiKeys(ctx context.Context, req *svcDto.ListServiceAccountApiKeysRequest) (*svcDto.ListApiKeysResponse, error) {
+	serviceAccount, err := s.getServiceAccount(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	return s.listApiKeys(nil, serviceAccount.ServiceAccountId)
+}
+func (s *Service) RevokeServiceAccountApiKey(ctx context.Context, req *svcDto.RevokeServiceAccountApiKeyRequest) (*svcDto.RevokeApiKeyResponse, error) {
+	serviceAccount, err := s.getServiceAccount(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.revokeApiKey(ctx, nil, serviceAccount.ServiceAccountId, req.Prefix); err != nil {
+		return nil, err
+	}
+	return &svcDto.RevokeApiKeyResponse{}, nil
+}
+
+// ExchangeApiKey issues the short-lived access token for the key. The token of a user key carries the roles and
+// the permissions of the user, the token of a service account key is the same as by the client credentials grant.
+// Neither has a refresh token
+func (s *Service) ExchangeApiKey(ctx context.Context, req *svcDto.ExchangeApiKeyRequest) (*svcDto.TokenResponse, error) {
+	prefix := s.apiKeyPrefix(req.ApiKey)
+	if prefix == "" {
+		s.lg.Error("invalid api key format", slog.String("owner", "service.ExchangeApiKey"))
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidApiKey.Error())
+	}
+	apiKey, err := s.store.GetApiKeyByPrefix(prefix)
+	if err != nil && !errors.Is(err, repoErr.ErrRecordNotFound) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	now := time.Now()
+	if err != nil || !secure.CheckHash(req.ApiKey, apiKey.KeyHash) || isApiKeyExpired(apiKey, now) {
+		s.lg.Error("api key verification error", slog.String("owner", "service.ExchangeApiKey"), slog.String("prefix", prefix))
+		s.audit(ctx, "api_key.exchange.failure", slog.String("prefix", prefix))
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidApiKey.Error())
+	}
+	var (
+		accessTokenString string
+		scopes            []string
+	)
+	if apiKey.UserId != nil {
+		accessTokenString, scopes, err = s.exchangeUserApiKey(apiKey, req.Scope)
+	} else {
+		accessTokenString, scopes, err = s.exchangeServiceAccountApiKey(apiKey, req.Scope)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.UpdateApiKeyLastUsedAt(apiKey.ApiKeyId, now); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "api_key.exchange", slog.Any("userId", apiKey.UserId), slog.Any("serviceAccountId", apiKey.ServiceAccountId), slog.String("prefix", prefix), slog.Any("scopes", scopes))
+	return &svcDto.TokenResponse{
+		AccessToken: accessTokenString,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.apiKey.TokenLifetime.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// requestedScopes returns the requested scopes, or all the allowed ones when none are requested.
+// nil allowed scopes allow any scopes
+func (s *Service) requestedScopes(scope string, allowedScopes []string, owner string) ([]string, error) {
+	if scope == "" {
+		return allowedScopes, nil
+	}
+	scopes, err := s.parseScopes(strings.Fields(scope), owner)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		if allowedScopes != nil && !slices.Contains(allowedScopes, scope) {
+			s.lg.Error("scope is not allowed", slog.String("owner", owner), slog.String("scope", scope))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentScope.Error())
+		}
+	}
+	return scopes, nil
+}
+func (s *Service) exchangeUserApiKey(apiKey *entity.ApiKey, scope string) (string, []string, error) {
+	var allowedScopes []string
+	if len(apiKey.Scopes) != 0 {
+		allowedScopes = apiKey.Scopes
+	}
+	scopes, err := s.requestedScopes(scope, allowedScopes, "service.ExchangeApiKey")
+	if err != nil {
+		return "", nil, err
+	}
+	user, err := s.store.GetUser(apiKey.UserId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return "", nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidApiKey.Error())
+		}
+		return "", nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.checkUserStatus(user, "service.ExchangeApiKey"); err != nil {
+		return "", nil, err
+	}
+	tenant, err := s.getTenant(user.TenantId)
+	if err != nil {
+		return "", nil, err
+	}
+	signingKey, err := s.tokenSigningKey(tenant, "service.ExchangeApiKey")
+	if err != nil {
+		return "", nil, err
+	}
+	roles, permissions, truncated, err := s.userAuthorization(user.UserId)
+	if err != nil {
+		return "", nil, err
+	}
+	accessTokenString, _, err := jwt.CreateToken(user.UserId, "", "access", s.apiKey.TokenLifetime, signingKey, jwt.WithAuthorization(roles, permissions, truncated), jwt.WithTenant(user.TenantId), jwt.WithScopes(scopes), jwt.WithApiKey(apiKey.Prefix))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ExchangeApiKey"))
+		return "", nil, status.Error(codes.Internal, err.Error())
+	}
+	return accessTokenString, scopes, nil
+}
+func (s *Service) exchangeServiceAccountApiKey(apiKey *entity.ApiKey, scope string) (string, []string, error) {
+	serviceAccount, err := s.store.GetServiceAccount(apiKey.ServiceAccountId)
+	if err != nil && !errors.Is(err, repoErr.ErrRecordNotFound) {
+		return "", nil, status.Error(codes.Internal, err.Error())
+	}
+	if err != nil || serviceAccount.IsDisabled {
+		s.lg.Error("service account is disabled", slog.String("owner", "service.ExchangeApiKey"), slog.String("prefix", apiKey.Prefix))
+		return "", nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidApiKey.Error())
+	}
+	// the scopes of the account may have been narrowed since the key was created. The slice is never nil,
+	// the account without scopes gets none
+	allowedScopes := append([]string{}, serviceAccount.Scopes...)
+	if len(apiKey.Scopes) != 0 {
+		allowedScopes = make([]string, 0, len(apiKey.Scopes))
+		for _, scope := range apiKey.Scopes {
+			if slices.Contains(serviceAccount.Scopes, scope) {
+				allowedScopes = append(allowedScopes, scope)
+			}
+		}
+	}
+	scopes, err := s.requestedScopes(scope, allowedScopes, "service.ExchangeApiKey")
+	if err != nil {
+		return "", nil, err
+	}
+	tenant, err := s.getTenant(serviceAccount.TenantId)
+	if err != nil {
+		return "", nil, err
+	}
+	signingKey, err := s.tokenSigningKey(tenant, "service.ExchangeApiKey")
+	if err != nil {
+		return "", nil, err
+	}
+	accessTokenString, _, err := jwt.CreateToken(serviceAccount.ServiceAccountId, "", "access", s.apiKey.TokenLifetime, signingKey, jwt.WithSubjectType(jwt.SubjectTypeServiceAccount), jwt.WithTenant(serviceAccount.TenantId), jwt.WithScopes(scopes), jwt.WithApiKey(apiKey.Prefix))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ExchangeApiKey"))
+		return "", nil, status.Error(codes.Internal, err.Error())
+	}
+	return accessTokenString, scopes, nil
+}
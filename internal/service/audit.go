@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const clientVersionKey = "x-client-version"
+
+// the client sets the user agent and the version freely, they are cut to this many bytes before they are stored
+const maxClientValueSize = 256
+
+// clientInfo describes where the call comes from, it is kept on the session and added to the audit events
+type clientInfo struct {
+	ip            string
+	userAgent     string
+	clientVersion string
+}
+
+func getClientInfo(ctx context.Context) *clientInfo {
+	return &clientInfo{
+		ip:            clientIp(ctx),
+		userAgent:     truncate(metadataValue(ctx, "user-agent"), maxClientValueSize),
+		clientVersion: truncate(metadataValue(ctx, clientVersionKey), maxClientValueSize),
+	}
+}
+
+// truncate cuts the value to at most size bytes without splitting a character
+func truncate(value string, size int) string {
+	if len(value) <= size {
+		return value
+	}
+	for size > 0 && !utf8.RuneStart(value[size]) {
+		size--
+	}
+	return value[:size]
+}
+
+// session describes the session a refresh token belongs to
+type session struct {
+	sessionId *uuid.UUID
+	issuedAt  time.Time
+	client    *clientInfo
+	// the OAuth client of the session and the scopes granted to it, empty for the first-party logins
+	clientId string
+	scopes   []string
+	// a new login of the device, its previous tokens are replaced and the limit of devices applies
+	newDevice bool
+}
+
+// audit writes the security relevant event together with the client of the call
+func (s *Service) audit(ctx context.Context, event string, attrs ...any) {
+	client := getClientInfo(ctx)
+	attrs = append(attrs,
+		slog.String("event", event),
+		slog.String("ip", client.ip),
+		slog.String("userAgent", client.userAgent),
+		slog.String("clientVersion", client.clientVersion),
+	)
+	s.lg.LogAttrs(ctx, slog.LevelInfo, "audit", slog.Group("audit", attrs...))
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoErr "ppAuthService/internal/repository/err"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/jwt"
+	"slices"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Authenticate verifies the bearer access token of the call. The token is trusted until it expires,
+// the state of the user is not read
+func (s *Service) Authenticate(ctx context.Context, method string) (*jwt.TokenClaims, error) {
+	tokenString := bearerToken(ctx)
+	if tokenString == "" {
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrAccessTokenRequired.Error())
+	}
+	claims, err := s.ParseToken(tokenString)
+	if err != nil || claims.TokenType != "access" || claims.Sub == nil {
+		s.lg.Error("access token verification error", slog.String("owner", "service.Authenticate"), slog.String("method", method))
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidAccessToken.Error())
+	}
+	return claims, nil
+}
+
+// AuthorizeSelf lets the call of the user on its own account through while the user is active. The status is read
+// from the store, so a locked, disabled or deleted user is refused before its access token expires
+func (s *Service) AuthorizeSelf(claims *jwt.TokenClaims, method string) error {
+	user, err := s.store.GetUser(claims.Sub)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return status.Error(codes.Unauthenticated, svcErr.ErrInvalidAccessToken.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	return s.checkUserStatus(user, method)
+}
+
+// AuthorizeAdmin lets the call through when the authenticated user is active and has the admin role of the own
+// tenant. The role is read from the store, so a revoked role takes effect before the token expires. Service accounts
+// are never admins. The admin manages the own tenant and only its users; the admins of the default tenant manage
+// all the tenants and the tenant of the metadata. The returned context carries the managed tenant
+func (s *Service) AuthorizeAdmin(ctx context.Context, claims *jwt.TokenClaims, method string, targetUserId string) (context.Context, error) {
+	if claims.SubjectType == jwt.SubjectTypeServiceAccount {
+		s.lg.Warn("admin role is required", slog.String("owner", "service.AuthorizeAdmin"), slog.Any("serviceAccountId", claims.Sub), slog.String("method", method))
+		return nil, status.Error(codes.PermissionDenied, svcErr.ErrAdminRoleRequired.Error())
+	}
+	user, err := s.store.GetUser(claims.Sub)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidAccessToken.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	tenantId := &entity.DefaultTenantId
+	if claims.Tenant != nil {
+		tenantId = claims.Tenant
+	}
+	if *tenantId != *user.TenantId {
+		s.lg.Error("tenant of the token does not match the user", slog.String("owner", "service.AuthorizeAdmin"), slog.Any("userId", user.UserId))
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidAccessToken.Error())
+	}
+	if err := s.checkUserStatus(user, "service.AuthorizeAdmin"); err != nil {
+		return nil, err
+	}
+	roles, err := s.store.GetRolesByUserId(user.UserId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !slices.ContainsFunc(roles, func(role *entity.Role) bool { return role.Name == s.admin.Role }) {
+		s.lg.Warn("admin role is required", slog.String("owner", "service.AuthorizeAdmin"), slog.Any("userId", user.UserId), slog.String("method", method))
+		return nil, status.Error(codes.PermissionDenied, svcErr.ErrAdminRoleRequired.Error())
+	}
+	managedTenantId := user.TenantId
+	if metadataValue(ctx, tenantKey) != "" {
+		tenant, err := s.requestTenant(ctx, "service.AuthorizeAdmin")
+		if err != nil {
+			return nil, err
+		}
+		if *user.TenantId != entity.DefaultTenantId && *tenant.TenantId != *user.TenantId {
+			s.lg.Warn("tenant of the admin does not match", slog.String("owner", "service.AuthorizeAdmin"), slog.Any("userId", user.UserId), slog.String("method", method))
+			return nil, status.Error(codes.PermissionDenied, svcErr.ErrAdminRoleRequired.Error())
+		}
+		managedTenantId = tenant.TenantId
+	}
+	// the request of the user of another tenant is answered the same way as the one of a missing user,
+	// a malformed id is left to the method
+	if targetUserId != "" && *user.TenantId != entity.DefaultTenantId {
+		if userId, err := uuid.Parse(targetUserId); err == nil {
+			target, err := s.store.GetUser(&userId)
+			if err != nil && !errors.Is(err, repoErr.ErrRecordNotFound) {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			if err == nil && *target.TenantId != *user.TenantId {
+				s.lg.Warn("user of another tenant", slog.String("owner", "service.AuthorizeAdmin"), slog.Any("adminId", user.UserId), slog.Any("userId", userId), slog.String("method", method))
+				return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+			}
+		}
+	}
+	s.audit(ctx, "admin.call", slog.Any("adminId", user.UserId), slog.Any("tenantId", managedTenantId), slog.String("method", method))
+	return context.WithValue(ctx, adminTenantKey{}, managedTenantId), nil
+}
+
+// AuthorizeScope lets a delegated token, the token of an api key, of a service account or limited to scopes, call
+// only the methods of its scopes. Such tokens never call the methods without a scope, the admin and the account
+// management ones. The token of a user api key without scopes may call any method with a scope
+func (s *Service) AuthorizeScope(claims *jwt.TokenClaims, scope string, method string) error {
+	if !claims.IsDelegated() {
+		return nil
+	}
+	if scope == "" {
+		s.lg.Warn("delegated token is not allowed", slog.String("owner", "service.AuthorizeScope"), slog.Any("sub", claims.Sub), slog.String("apiKey", claims.ApiKey), slog.String("method", method))
+		return status.Error(codes.PermissionDenied, svcErr.ErrDelegatedTokenNotAllowed.Error())
+	}
+	if (claims.ApiKey != "" && claims.SubjectType == "" && claims.Scope == "") || claims.HasScope(scope) {
+		return nil
+	}
+	s.lg.Warn("scope is required", slog.String("owner", "service.AuthorizeScope"), slog.Any("sub", claims.Sub), slog.String("scope", scope), slog.String("method", method))
+	return status.Error(codes.PermissionDenied, svcErr.ErrScopeRequired.Error())
+}
+
+// RequestApiKey returns the prefix of the api key the verified access token of the call was exchanged for,
+// empty when the call has no such token
+func (s *Service) RequestApiKey(ctx context.Context) string {
+	tokenString := bearerToken(ctx)
+	if tokenString == "" {
+		return ""
+	}
+	claims, err := s.ParseToken(tokenString)
+	if err != nil || claims.TokenType != "access" {
+		return ""
+	}
+	return claims.ApiKey
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"ppAuthService/internal/entity"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/condition"
+	"ppAuthService/pkg/jwt"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reasons of the decisions
+const (
+	reasonGranted          = "granted"
+	reasonNoGrant          = "no_grant"
+	reasonConditionNotMet  = "condition_not_met"
+	reasonUserNotFound     = "user_not_found"
+	reasonUserInactive     = "user_inactive"
+	reasonInvalidCondition = "invalid_condition"
+)
+
+type decision struct {
+	allowed     bool
+	reason      string
+	explanation []string
+}
+
+// decide evaluates the grants of the user of the tenant for the permission. The subject has the id, the login and
+// the tenant id of the user in addition to the given attributes, the resource attributes are taken as they are
+func (s *Service) decide(tenantId *uuid.UUID, userId uuid.UUID, permission string, subject, resource map[string]string) (*decision, error) {
+	key := tenantId.String() + "/" + decisionKey(permission, subject, resource)
+	if d, ok := s.decisionCache.get(userId, key); ok {
+		return d, nil
+	}
+	generation := s.decisionCache.currentGeneration()
+	d, err := s.evaluate(tenantId, userId, permission, subject, resource)
+	if err != nil {
+		return nil, err
+	}
+	s.decisionCache.set(userId, key, d, generation)
+	return d, nil
+}
+
+// evaluate sees only the users of the tenant, the user of another tenant does not exist for it
+func (s *Service) evaluate(tenantId *uuid.UUID, userId uuid.UUID, permission string, subject, resource map[string]string) (*decision, error) {
+	user, err := s.store.GetUser(&userId)
+	if err != nil && !errors.Is(err, repoErr.ErrRecordNotFound) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err != nil || *user.TenantId != *tenantId {
+		return &decision{reason: reasonUserNotFound, explanation: []string{"user does not exist"}}, nil
+	}
+	if user.Status != entity.UserStatusActive {
+		return &decision{reason: reasonUserInactive, explanation: []string{fmt.Sprintf("user status is %s", user.Status)}}, nil
+	}
+	subjectAttrs := maps.Clone(subject)
+	if subjectAttrs == nil {
+		subjectAttrs = make(map[string]string)
+	}
+	// the attributes of the user are the stored ones, the caller can not pass others for them
+	subjectAttrs["id"] = user.UserId.String()
+	subjectAttrs["login"] = user.Login
+	subjectAttrs["tenant"] = user.TenantId.String()
+
+	grants, err := s.store.GetGrantsByUserId(&userId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	d := &decision{reason: reasonNoGrant}
+	for _, grant := range grants {
+		if grant.Permission != permission {
+			continue
+		}
+		if grant.Condition == "" {
+			d.allowed, d.reason = true, reasonGranted
+			d.explanation = append(d.explanation, fmt.Sprintf("role %s grants %s", grant.Role, permission))
+			return d, nil
+		}
+		// conditions are validated on grant, a stored invalid one denies
+		cond, err := condition.Parse(grant.Condition)
+		if err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", "service.evaluate"), slog.String("role", grant.Role), slog.String("permission", permission))
+			d.reason = reasonInvalidCondition
+			d.explanation = append(d.explanation, fmt.Sprintf("role %s grants %s when %s: invalid condition", grant.Role, permission, grant.Condition))
+			continue
+		}
+		ok, failed := cond.Evaluate(subjectAttrs, resource)
+		if ok {
+			d.allowed, d.reason = true, reasonGranted
+			d.explanation = append(d.explanation, fmt.Sprintf("role %s grants %s when %s: met", grant.Role, permission, grant.Condition))
+			return d, nil
+		}
+		if d.reason == reasonNoGrant {
+			d.reason = reasonConditionNotMet
+		}
+		d.explanation = append(d.explanation, fmt.Sprintf("role %s grants %s when %s: not met, %s", grant.Role, permission, grant.Condition, failed))
+	}
+	if d.reason == reasonNoGrant {
+		d.explanation = append(d.explanation, fmt.Sprintf("no role of the user grants %s", permission))
+	}
+	return d, nil
+}
+
+func (s *Service) checkPermission(ctx context.Context, req *svcDto.CheckPermissionRequest, owner string) (*svcDto.CheckPermissionResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	// the token of a user, its api keys and its OAuth clients decide only for the user, service accounts for anyone
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrAccessTokenRequired.Error())
+	}
+	if claims.SubjectType != jwt.SubjectTypeServiceAccount && *claims.Sub != userId {
+		s.lg.Warn("permission of another user", slog.String("owner", owner), slog.Any("sub", claims.Sub), slog.Any("userId", userId))
+		return nil, status.Error(codes.PermissionDenied, svcErr.ErrNotTokenOwner.Error())
+	}
+	if req.Permission == "" {
+		s.lg.Error("invalid permission name value", slog.String("owner", owner))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentPermission.Error())
+	}
+	d, err := s.decide(callerTenantId(ctx), userId, req.Permission, req.Subject, req.Resource)
+	if err != nil {
+		return nil, err
+	}
+	resp := &svcDto.CheckPermissionResponse{
+		Allowed: d.allowed,
+		Reason:  d.reason,
+	}
+	if s.authz.Debug {
+		resp.Explanation = d.explanation
+		s.lg.Debug("permission is checked", slog.String("owner", owner), slog.Any("userId", userId), slog.String("permission", req.Permission), slog.Bool("allowed", d.allowed), slog.Any("explanation", d.explanation))
+	}
+	return resp, nil
+}
+
+// CheckPermission answers whether the user may perform the action on the resource
+func (s *Service) CheckPermission(ctx context.Context, req *svcDto.CheckPermissionRequest) (*svcDto.CheckPermissionResponse, error) {
+	return s.checkPermission(ctx, req, "service.CheckPermission")
+}
+
+// BatchCheck makes several decisions at once, a bad check fails the whole batch
+func (s *Service) BatchCheck(ctx context.Context, req *svcDto.BatchCheckRequest) (*svcDto.BatchCheckResponse, error) {
+	if len(req.Checks) == 0 || len(req.Checks) > s.authz.MaxBatchSize {
+		s.lg.Error("invalid number of checks in the batch", slog.String("owner", "service.BatchCheck"), slog.Int("checks", len(req.Checks)))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentBatch.Error())
+	}
+	resp := &svcDto.BatchCheckResponse{Results: make([]*svcDto.CheckPermissionResponse, 0, len(req.Checks))}
+	for _, check := range req.Checks {
+		if check == nil {
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentBatch.Error())
+		}
+		result, err := s.checkPermission(ctx, check, "service.BatchCheck")
+		if err != nil {
+			return nil, err
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	return resp, nil
+}
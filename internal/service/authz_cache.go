@@ -0,0 +1,127 @@
+package service
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type cachedDecision struct {
+	decision  *decision
+	expiresAt time.Time
+}
+
+// decisionCache keeps the decisions by user, so that the changes of one user drop only its decisions.
+// The cache is local to the instance, the ttl bounds how long other instances may see a stale decision
+type decisionCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	size  int
+	count int
+	// grows on every invalidation, a decision evaluated before one is not kept
+	generation uint64
+	entries    map[uuid.UUID]map[string]*cachedDecision
+}
+
+func newDecisionCache(ttl time.Duration, size int) *decisionCache {
+	return &decisionCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[uuid.UUID]map[string]*cachedDecision),
+	}
+}
+
+// decisionKey identifies the check of the user, the attributes are sorted so that the key does not depend on the map order.
+// Every part is prefixed with its length, so that no name or value may pass for another attribute or the other map
+func decisionKey(permission string, subject, resource map[string]string) string {
+	var b strings.Builder
+	writePart := func(part string) {
+		b.WriteString(strconv.Itoa(len(part)))
+		b.WriteByte(':')
+		b.WriteString(part)
+	}
+	writePart(permission)
+	for _, attrs := range []map[string]string{subject, resource} {
+		keys := make([]string, 0, len(attrs))
+		for key := range attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteString(strconv.Itoa(len(keys)))
+		b.WriteByte('#')
+		for _, key := range keys {
+			writePart(key)
+			writePart(attrs[key])
+		}
+	}
+	return b.String()
+}
+
+func (c *decisionCache) get(userId uuid.UUID, key string) (*decision, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[userId][key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.decision, true
+}
+
+// currentGeneration is taken before the decision is evaluated and passed to set
+func (c *decisionCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// set keeps the decision evaluated in the generation, unless the cache was invalidated since, as the decision
+// may have been read before the change
+func (c *decisionCache) set(userId uuid.UUID, key string, d *decision, generation uint64) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	// a full cache is dropped as a whole, it is refilled by the next checks
+	if c.count >= c.size {
+		c.entries = make(map[uuid.UUID]map[string]*cachedDecision)
+		c.count = 0
+	}
+	userEntries, ok := c.entries[userId]
+	if !ok {
+		userEntries = make(map[string]*cachedDecision)
+		c.entries[userId] = userEntries
+	}
+	if _, ok := userEntries[key]; !ok {
+		c.count++
+	}
+	userEntries[key] = &cachedDecision{decision: d, expiresAt: time.Now().Add(c.ttl)}
+}
+
+// invalidateUser drops the decisions of the user, e.g. after a role is assigned
+func (c *decisionCache) invalidateUser(userId uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.count -= len(c.entries[userId])
+	delete(c.entries, userId)
+}
+
+// invalidate drops all the decisions, e.g. after a role is granted a permission
+func (c *decisionCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = make(map[uuid.UUID]map[string]*cachedDecision)
+	c.count = 0
+}
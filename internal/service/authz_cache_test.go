@@ -0,0 +1,56 @@
+package service
+
+import "testing"
+
+func TestDecisionKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		permission  string
+		subject     map[string]string
+		resource    map[string]string
+		otherPerm   string
+		otherSubj   map[string]string
+		otherRes    map[string]string
+		wantCollide bool
+	}{
+		{"map order", "doc.read",
+			map[string]string{"id": "u1", "tenant": "t1"}, map[string]string{"owner": "u1", "type": "doc"},
+			"doc.read",
+			map[string]string{"tenant": "t1", "id": "u1"}, map[string]string{"type": "doc", "owner": "u1"},
+			true},
+		{"separator inside a value", "doc.read",
+			nil, map[string]string{"owner": "u1\x01type=doc"},
+			"doc.read",
+			nil, map[string]string{"owner": "u1", "type": "doc"},
+			false},
+		{"equals sign inside a name", "doc.read",
+			nil, map[string]string{"owner=u1": ""},
+			"doc.read",
+			nil, map[string]string{"owner": "u1"},
+			false},
+		{"subject attribute moved to the resource", "doc.read",
+			map[string]string{"id": "u1"}, nil,
+			"doc.read",
+			nil, map[string]string{"id": "u1"},
+			false},
+		{"value moved across the maps", "doc.read",
+			map[string]string{"id": "u1\x00owner=u1"}, nil,
+			"doc.read",
+			map[string]string{"id": "u1"}, map[string]string{"owner": "u1"},
+			false},
+		{"permission merged with the attributes", "doc.read\x00id=u1",
+			nil, nil,
+			"doc.read",
+			map[string]string{"id": "u1"}, nil,
+			false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := decisionKey(tt.permission, tt.subject, tt.resource)
+			otherKey := decisionKey(tt.otherPerm, tt.otherSubj, tt.otherRes)
+			if (key == otherKey) != tt.wantCollide {
+				t.Errorf("decisionKey keys %q and %q, want equal %v", key, otherKey, tt.wantCollide)
+			}
+		})
+	}
+}
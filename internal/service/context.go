@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"math"
+	"net/netip"
+	"ppAuthService/internal/entity"
+	"ppAuthService/pkg/jwt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/realip"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// clientIp returns the address resolved by the realip interceptor, or the peer address
+func clientIp(ctx context.Context) string {
+	if ip, ok := realip.FromContext(ctx); ok {
+		return ip.String()
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if addrPort, err := netip.ParseAddrPort(p.Addr.String()); err == nil {
+			return addrPort.Addr().String()
+		}
+	}
+	return ""
+}
+
+// metadataValue returns the first value of the incoming metadata key
+func metadataValue(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) != 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// bearerToken returns the token of the authorization metadata
+func bearerToken(ctx context.Context) string {
+	scheme, token, ok := strings.Cut(metadataValue(ctx, "authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// setRetryAfter tells the client how many seconds to wait before the next call
+func setRetryAfter(ctx context.Context, d time.Duration) {
+	seconds := int(math.Ceil(d.Seconds()))
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(seconds)))
+}
+
+type claimsKey struct{}
+
+// WithClaims returns the context with the claims of the verified access token of the call
+func WithClaims(ctx context.Context, claims *jwt.TokenClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims put by the authentication interceptor, there are none for the public methods
+func ClaimsFromContext(ctx context.Context) (*jwt.TokenClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*jwt.TokenClaims)
+	return claims, ok
+}
+
+// callerTenantId returns the tenant of the access token of the call, the default tenant for the tokens issued
+// before the tenants
+func callerTenantId(ctx context.Context) *uuid.UUID {
+	if claims, ok := ClaimsFromContext(ctx); ok && claims.Tenant != nil {
+		return claims.Tenant
+	}
+	return &entity.DefaultTenantId
+}
+
+type adminTenantKey struct{}
+
+// adminTenantId returns the tenant the admin of the call manages, put by AuthorizeAdmin
+func adminTenantId(ctx context.Context) *uuid.UUID {
+	if tenantId, ok := ctx.Value(adminTenantKey{}).(*uuid.UUID); ok {
+		return tenantId
+	}
+	return callerTenantId(ctx)
+}
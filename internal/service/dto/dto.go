@@ -0,0 +1,671 @@
+// Requests and responses of the service methods that are not yet described in ppProtos.
+// Field names follow the protobuf JSON mapping, so that the gateway serves them the same way as the proto messages
+package dto
+
+import (
+	"ppAuthService/internal/entity"
+	"time"
+)
+
+type ClearLoginLockoutRequest struct {
+	Login string `json:"login"`
+	Ip    string `json:"ip"`
+}
+type ClearLoginLockoutResponse struct {
+}
+
+type EnrollTotpRequest struct {
+	UserId string `json:"userId"`
+}
+type EnrollTotpResponse struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+type ConfirmTotpRequest struct {
+	UserId string `json:"userId"`
+	Code   string `json:"code"`
+}
+type ConfirmTotpResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+type DisableTotpRequest struct {
+	UserId string `json:"userId"`
+	Code   string `json:"code"`
+}
+type DisableTotpResponse struct {
+}
+
+type RegenerateRecoveryCodesRequest struct {
+	UserId string `json:"userId"`
+	Code   string `json:"code"`
+}
+type RegenerateRecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type ListSessionsRequest struct {
+	UserId string `json:"userId"`
+}
+type Session struct {
+	SessionId     string    `json:"sessionId"`
+	IssuedAt      time.Time `json:"issuedAt"`
+	ExpirationAt  time.Time `json:"expirationAt"`
+	LastRefreshAt time.Time `json:"lastRefreshAt"`
+	LastUsedAt    time.Time `json:"lastUsedAt"`
+	ClientIp      string    `json:"clientIp"`
+	UserAgent     string    `json:"userAgent"`
+	ClientVersion string    `json:"clientVersion"`
+}
+type Device struct {
+	DeviceCode string     `json:"deviceCode"`
+	Sessions   []*Session `json:"sessions"`
+}
+type ListSessionsResponse struct {
+	Devices []*Device `json:"devices"`
+}
+type RevokeSessionRequest struct {
+	UserId    string `json:"userId"`
+	SessionId string `json:"sessionId"`
+}
+type RevokeSessionResponse struct {
+}
+type RevokeAllOtherSessionsRequest struct {
+	UserId string `json:"userId"`
+}
+type RevokeAllOtherSessionsResponse struct {
+	RevokedCount int64 `json:"revokedCount"`
+}
+
+type SetUserMaxDevicesRequest struct {
+	UserId string `json:"userId"`
+	// null removes the override
+	MaxDevices *int `json:"maxDevices"`
+}
+type SetUserMaxDevicesResponse struct {
+}
+
+type RequestPasswordResetRequest struct {
+	Login string `json:"login"`
+}
+type RequestPasswordResetResponse struct {
+}
+type ConfirmPasswordResetRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+type ConfirmPasswordResetResponse struct {
+}
+
+type SetUserStatusRequest struct {
+	UserId string `json:"userId"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+type SetUserStatusResponse struct {
+}
+type GetUserStatusHistoryRequest struct {
+	UserId string `json:"userId"`
+}
+type UserStatusChange struct {
+	OldStatus string    `json:"oldStatus"`
+	NewStatus string    `json:"newStatus"`
+	Reason    string    `json:"reason"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+type GetUserStatusHistoryResponse struct {
+	Changes []*UserStatusChange `json:"changes"`
+}
+
+// AdminUser is the user as the support staff sees it, without the password
+type AdminUser struct {
+	UserId          string     `json:"userId"`
+	TenantId        string     `json:"tenantId"`
+	Login           string     `json:"login"`
+	Status          string     `json:"status"`
+	StatusChangedAt time.Time  `json:"statusChangedAt"`
+	Email           *string    `json:"email"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	PendingEmail    *string    `json:"pendingEmail"`
+	MaxDevices      *int       `json:"maxDevices"`
+	DeletedAt       *time.Time `json:"deletedAt"`
+	CreatedAt       time.Time  `json:"createdAt"`
+}
+
+// ListUsersRequest filters are optional, the created range includes createdFrom and excludes createdTo
+type ListUsersRequest struct {
+	// tenant name, all the tenants when empty
+	Tenant      string     `json:"tenant"`
+	LoginPrefix string     `json:"loginPrefix"`
+	Status      string     `json:"status"`
+	CreatedFrom *time.Time `json:"createdFrom"`
+	CreatedTo   *time.Time `json:"createdTo"`
+	PageSize    int        `json:"pageSize"`
+	// nextCursor of the previous page
+	Cursor string `json:"cursor"`
+}
+type ListUsersResponse struct {
+	Users []*AdminUser `json:"users"`
+	// empty on the last page
+	NextCursor string `json:"nextCursor"`
+}
+
+// GetUserRequest finds the user by the id, or by the login within the tenant
+type GetUserRequest struct {
+	UserId string `json:"userId"`
+	Tenant string `json:"tenant"`
+	Login  string `json:"login"`
+}
+type GetUserResponse struct {
+	User           *AdminUser `json:"user"`
+	Roles          []string   `json:"roles"`
+	MfaEnabled     bool       `json:"mfaEnabled"`
+	ActiveSessions int        `json:"activeSessions"`
+}
+
+// ForceLogoutRequest revokes the sessions of the device, or of all the devices when the device code is empty
+type ForceLogoutRequest struct {
+	UserId     string `json:"userId"`
+	DeviceCode string `json:"deviceCode"`
+	Reason     string `json:"reason"`
+}
+type ForceLogoutResponse struct {
+}
+type ResetPasswordRequest struct {
+	UserId string `json:"userId"`
+	Reason string `json:"reason"`
+}
+type ResetPasswordResponse struct {
+}
+
+// GetUserStatsRequest counts the users of the tenant, or of all the tenants when empty
+type GetUserStatsRequest struct {
+	Tenant string `json:"tenant"`
+}
+type GetUserStatsResponse struct {
+	Total           int64            `json:"total"`
+	ByStatus        map[string]int64 `json:"byStatus"`
+	EmailVerified   int64            `json:"emailVerified"`
+	MfaEnabled      int64            `json:"mfaEnabled"`
+	CreatedLastDay  int64            `json:"createdLastDay"`
+	CreatedLastWeek int64            `json:"createdLastWeek"`
+}
+
+type RestoreUserRequest struct {
+	UserId string `json:"userId"`
+	Reason string `json:"reason"`
+}
+type RestoreUserResponse struct {
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+type VerifyEmailResponse struct {
+	Email string `json:"email"`
+}
+type ChangeEmailRequest struct {
+	UserId   string `json:"userId"`
+	NewEmail string `json:"newEmail"`
+}
+type ChangeEmailResponse struct {
+}
+type ResendEmailVerificationRequest struct {
+	UserId string `json:"userId"`
+}
+type ResendEmailVerificationResponse struct {
+}
+
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	MaxDevices  *int      `json:"maxDevices"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+type Permission struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+type CreateRoleRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// overrides the configured limit of devices for the users of the role, 0 is unlimited
+	MaxDevices *int `json:"maxDevices"`
+}
+type CreateRoleResponse struct {
+	RoleId string `json:"roleId"`
+}
+type DeleteRoleRequest struct {
+	Name string `json:"name"`
+}
+type DeleteRoleResponse struct {
+}
+type ListRolesRequest struct {
+}
+type ListRolesResponse struct {
+	Roles []*Role `json:"roles"`
+}
+type CreatePermissionRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+type CreatePermissionResponse struct {
+	PermissionId string `json:"permissionId"`
+}
+type DeletePermissionRequest struct {
+	Name string `json:"name"`
+}
+type DeletePermissionResponse struct {
+}
+type ListPermissionsRequest struct {
+}
+type ListPermissionsResponse struct {
+	Permissions []*Permission `json:"permissions"`
+}
+type GrantPermissionRequest struct {
+	Role       string `json:"role"`
+	Permission string `json:"permission"`
+	// attribute condition of the grant, e.g. resource.owner == subject.id. Granting again replaces it
+	Condition string `json:"condition"`
+}
+type GrantPermissionResponse struct {
+}
+type RevokePermissionRequest struct {
+	Role       string `json:"role"`
+	Permission string `json:"permission"`
+}
+type RevokePermissionResponse struct {
+}
+type AssignRoleRequest struct {
+	UserId string `json:"userId"`
+	Role   string `json:"role"`
+}
+type AssignRoleResponse struct {
+}
+type UnassignRoleRequest struct {
+	UserId string `json:"userId"`
+	Role   string `json:"role"`
+}
+type UnassignRoleResponse struct {
+}
+type ListUserRolesRequest struct {
+	UserId string `json:"userId"`
+}
+type ListUserRolesResponse struct {
+	Roles []*Role `json:"roles"`
+}
+
+type CheckPermissionRequest struct {
+	UserId     string `json:"userId"`
+	Permission string `json:"permission"`
+	// attributes of the resource the conditions are evaluated with, e.g. owner, tenant
+	Resource map[string]string `json:"resource"`
+	// additional attributes of the subject, id and login are set by the service
+	Subject map[string]string `json:"subject"`
+}
+type CheckPermissionResponse struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+	// only in the debug mode
+	Explanation []string `json:"explanation,omitempty"`
+}
+type BatchCheckRequest struct {
+	Checks []*CheckPermissionRequest `json:"checks"`
+}
+type BatchCheckResponse struct {
+	// in the order of the checks
+	Results []*CheckPermissionResponse `json:"results"`
+}
+
+// RelationTuple is written as object#relation@subject, the object is namespace:id and the subject is
+// namespace:id or namespace:id#relation
+type RelationTuple struct {
+	Object   string `json:"object"`
+	Relation string `json:"relation"`
+	Subject  string `json:"subject"`
+}
+type WriteRelationTuplesRequest struct {
+	Writes  []*RelationTuple `json:"writes"`
+	Deletes []*RelationTuple `json:"deletes"`
+}
+type WriteRelationTuplesResponse struct {
+	Zookie string `json:"zookie"`
+}
+type ReadRelationTuplesRequest struct {
+	// namespace or namespace:id
+	Object   string `json:"object"`
+	Relation string `json:"relation"`
+	Subject  string `json:"subject"`
+}
+type ReadRelationTuplesResponse struct {
+	Tuples []*RelationTuple `json:"tuples"`
+	Zookie string           `json:"zookie"`
+}
+type CheckRelationRequest struct {
+	Object   string `json:"object"`
+	Relation string `json:"relation"`
+	Subject  string `json:"subject"`
+	// the check sees at least the changes of the write that returned the token
+	Zookie string `json:"zookie"`
+}
+type CheckRelationResponse struct {
+	Allowed bool   `json:"allowed"`
+	Zookie  string `json:"zookie"`
+}
+type ExpandRelationRequest struct {
+	Object   string `json:"object"`
+	Relation string `json:"relation"`
+}
+type ExpandRelationResponse struct {
+	Tree   *UsersetNode `json:"tree"`
+	Zookie string       `json:"zookie"`
+}
+
+// UsersetNode is a node of the expanded relation: union of the children, this with the subjects of the tuples,
+// or tuple_to_userset with the expansions of the objects the tuples point to
+type UsersetNode struct {
+	Type     string         `json:"type"`
+	Object   string         `json:"object"`
+	Relation string         `json:"relation"`
+	Subjects []string       `json:"subjects,omitempty"`
+	Children []*UsersetNode `json:"children,omitempty"`
+}
+
+// Tenant lifetimes are duration strings like 15m, empty ones are taken from the configuration
+type Tenant struct {
+	TenantId        string                       `json:"tenantId"`
+	Name            string                       `json:"name"`
+	AccessLifetime  string                       `json:"accessLifetime,omitempty"`
+	RefreshLifetime string                       `json:"refreshLifetime,omitempty"`
+	PasswordPolicy  *entity.TenantPasswordPolicy `json:"passwordPolicy,omitempty"`
+	CreatedAt       time.Time                    `json:"createdAt"`
+}
+type CreateTenantRequest struct {
+	Name            string                       `json:"name"`
+	AccessLifetime  string                       `json:"accessLifetime"`
+	RefreshLifetime string                       `json:"refreshLifetime"`
+	PasswordPolicy  *entity.TenantPasswordPolicy `json:"passwordPolicy"`
+	// the tokens of the tenant are signed with its own key instead of the key of the service
+	OwnSigningKey bool `json:"ownSigningKey"`
+}
+type CreateTenantResponse struct {
+	TenantId string `json:"tenantId"`
+	KeyId    string `json:"keyId,omitempty"`
+}
+
+// UpdateTenantRequest replaces the overrides of the tenant
+type UpdateTenantRequest struct {
+	Name            string                       `json:"name"`
+	AccessLifetime  string                       `json:"accessLifetime"`
+	RefreshLifetime string                       `json:"refreshLifetime"`
+	PasswordPolicy  *entity.TenantPasswordPolicy `json:"passwordPolicy"`
+}
+type UpdateTenantResponse struct {
+}
+type ListTenantsRequest struct {
+}
+type ListTenantsResponse struct {
+	Tenants []*Tenant `json:"tenants"`
+}
+type RotateTenantSigningKeyRequest struct {
+	Name string `json:"name"`
+}
+type RotateTenantSigningKeyResponse struct {
+	KeyId string `json:"keyId"`
+}
+
+// TokenRequest is the token request of the OAuth 2.0 grants, only client_credentials is supported.
+// Empty scope requests all the scopes of the account
+type TokenRequest struct {
+	GrantType    string `json:"grantType"`
+	ClientId     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	// space separated
+	Scope string `json:"scope"`
+}
+type TokenResponse struct {
+	AccessToken string `json:"accessToken"`
+	TokenType   string `json:"tokenType"`
+	ExpiresIn   int64  `json:"expiresIn"`
+	Scope       string `json:"scope"`
+}
+
+type ServiceAccountSecret struct {
+	SecretId     string     `json:"secretId"`
+	ExpirationAt *time.Time `json:"expirationAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+type ServiceAccount struct {
+	ClientId  string                  `json:"clientId"`
+	Name      string                  `json:"name"`
+	Scopes    []string                `json:"scopes"`
+	Disabled  bool                    `json:"disabled"`
+	Secrets   []*ServiceAccountSecret `json:"secrets"`
+	CreatedAt time.Time               `json:"createdAt"`
+}
+
+// CreateServiceAccountRequest secret lifetime is a duration string like 720h, empty takes the configured one
+type CreateServiceAccountRequest struct {
+	Name           string   `json:"name"`
+	Scopes         []string `json:"scopes"`
+	SecretLifetime string   `json:"secretLifetime"`
+}
+
+// CreateServiceAccountResponse has the only copy of the secret
+type CreateServiceAccountResponse struct {
+	ClientId     string     `json:"clientId"`
+	ClientSecret string     `json:"clientSecret"`
+	SecretId     string     `json:"secretId"`
+	ExpirationAt *time.Time `json:"expirationAt"`
+}
+
+// UpdateServiceAccountRequest changes the given fields
+type UpdateServiceAccountRequest struct {
+	ClientId string   `json:"clientId"`
+	Scopes   []string `json:"scopes"`
+	Disabled *bool    `json:"disabled"`
+}
+type UpdateServiceAccountResponse struct {
+}
+type DeleteServiceAccountRequest struct {
+	ClientId string `json:"clientId"`
+}
+type DeleteServiceAccountResponse struct {
+}
+type ListServiceAccountsRequest struct {
+}
+type ListServiceAccountsResponse struct {
+	ServiceAccounts []*ServiceAccount `json:"serviceAccounts"`
+}
+type AddServiceAccountSecretRequest struct {
+	ClientId       string `json:"clientId"`
+	SecretLifetime string `json:"secretLifetime"`
+}
+type AddServiceAccountSecretResponse struct {
+	ClientSecret string     `json:"clientSecret"`
+	SecretId     string     `json:"secretId"`
+	ExpirationAt *time.Time `json:"expirationAt"`
+}
+type RemoveServiceAccountSecretRequest struct {
+	ClientId string `json:"clientId"`
+	SecretId string `json:"secretId"`
+}
+type RemoveServiceAccountSecretResponse struct {
+}
+
+type ApiKey struct {
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"`
+	Scopes       []string   `json:"scopes"`
+	ExpirationAt *time.Time `json:"expirationAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// CreateApiKeyRequest lifetime is a duration string like 720h, empty creates the key that does not expire
+type CreateApiKeyRequest struct {
+	UserId   string   `json:"userId"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Lifetime string   `json:"lifetime"`
+}
+
+// CreateApiKeyResponse has the only copy of the key
+type CreateApiKeyResponse struct {
+	ApiKey       string     `json:"apiKey"`
+	Prefix       string     `json:"prefix"`
+	ExpirationAt *time.Time `json:"expirationAt"`
+}
+type ListApiKeysRequest struct {
+	UserId string `json:"userId"`
+}
+type ListApiKeysResponse struct {
+	ApiKeys []*ApiKey `json:"apiKeys"`
+}
+type RevokeApiKeyRequest struct {
+	UserId string `json:"userId"`
+	Prefix string `json:"prefix"`
+}
+type RevokeApiKeyResponse struct {
+}
+type CreateServiceAccountApiKeyRequest struct {
+	ClientId string   `json:"clientId"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+	Lifetime string   `json:"lifetime"`
+}
+type ListServiceAccountApiKeysRequest struct {
+	ClientId string `json:"clientId"`
+}
+type RevokeServiceAccountApiKeyRequest struct {
+	ClientId string `json:"clientId"`
+	Prefix   string `json:"prefix"`
+}
+
+// ExchangeApiKeyRequest empty scope requests all the scopes of the key
+type ExchangeApiKeyRequest struct {
+	ApiKey string `json:"apiKey"`
+	// space separated
+	Scope string `json:"scope"`
+}
+
+type OAuthClient struct {
+	ClientId     string    `json:"clientId"`
+	Name         string    `json:"name"`
+	ClientType   string    `json:"clientType"`
+	RedirectUris []string  `json:"redirectUris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// CreateOAuthClientRequest client type is public or confidential
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	ClientType   string   `json:"clientType"`
+	RedirectUris []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+}
+
+// CreateOAuthClientResponse has the only copy of the secret, empty for the public client
+type CreateOAuthClientResponse struct {
+	ClientId     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+}
+
+// UpdateOAuthClientRequest replaces the given lists
+type UpdateOAuthClientRequest struct {
+	ClientId     string   `json:"clientId"`
+	RedirectUris []string `json:"redirectUris"`
+	Scopes       []string `json:"scopes"`
+}
+type UpdateOAuthClientResponse struct {
+}
+type DeleteOAuthClientRequest struct {
+	ClientId string `json:"clientId"`
+}
+type DeleteOAuthClientResponse struct {
+}
+type ListOAuthClientsRequest struct {
+}
+type ListOAuthClientsResponse struct {
+	OAuthClients []*OAuthClient `json:"oauthClients"`
+}
+
+// AuthorizeRequest is the authorization request of the code flow together with the credentials
+// entered on the login page. The first step has the login and the password, the second one the mfa token and code
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientId            string
+	RedirectUri         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	Login               string
+	Password            string
+	MfaToken            string
+	MfaCode             string
+}
+
+// AuthorizeResponse has the redirect uri with the code or with the error, or the mfa token when the code
+// is required, or only the client name for the login page
+type AuthorizeResponse struct {
+	ClientName  string
+	RedirectUri string
+	MfaToken    string
+}
+
+// OAuthTokenRequest is the token request of the authorization_code and refresh_token grants
+type OAuthTokenRequest struct {
+	GrantType    string
+	ClientId     string
+	ClientSecret string
+	Code         string
+	RedirectUri  string
+	CodeVerifier string
+	RefreshToken string
+}
+
+// OAuthTokenResponse names are fixed by RFC 6749
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// the OpenID Connect ID token, issued by the code exchange with the openid scope
+	IdToken string `json:"id_token,omitempty"`
+}
+
+// OpenIdConfiguration is the provider metadata of OpenID Connect Discovery 1.0
+type OpenIdConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	AcrValuesSupported                []string `json:"acr_values_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// UserInfoRequest has no fields, the user is the subject of the access token
+type UserInfoRequest struct {
+}
+
+// UserInfoResponse names are fixed by OpenID Connect, the claims beyond sub depend on the scopes of the token
+type UserInfoResponse struct {
+	Sub               string `json:"sub"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Tenant            string `json:"tenant,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
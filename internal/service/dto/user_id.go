@@ -0,0 +1,28 @@
+package dto
+
+// GetUserId is the owner of the request, the authentication interceptor compares it with the subject of the
+// access token, the same way as for the proto messages. For the admin requests it is the user the admin manages,
+// the interceptor compares the tenants
+
+func (r *EnrollTotpRequest) GetUserId() string              { return r.UserId }
+func (r *ConfirmTotpRequest) GetUserId() string             { return r.UserId }
+func (r *DisableTotpRequest) GetUserId() string             { return r.UserId }
+func (r *RegenerateRecoveryCodesRequest) GetUserId() string { return r.UserId }
+func (r *ListSessionsRequest) GetUserId() string            { return r.UserId }
+func (r *RevokeSessionRequest) GetUserId() string           { return r.UserId }
+func (r *RevokeAllOtherSessionsRequest) GetUserId() string  { return r.UserId }
+func (r *ChangeEmailRequest) GetUserId() string             { return r.UserId }
+func (r *ResendEmailVerificationRequest) GetUserId() string { return r.UserId }
+func (r *CreateApiKeyRequest) GetUserId() string            { return r.UserId }
+func (r *ListApiKeysRequest) GetUserId() string             { return r.UserId }
+func (r *RevokeApiKeyRequest) GetUserId() string            { return r.UserId }
+func (r *SetUserMaxDevicesRequest) GetUserId() string       { return r.UserId }
+func (r *SetUserStatusRequest) GetUserId() string           { return r.UserId }
+func (r *GetUserStatusHistoryRequest) GetUserId() string    { return r.UserId }
+func (r *GetUserRequest) GetUserId() string                 { return r.UserId }
+func (r *ForceLogoutRequest) GetUserId() string             { return r.UserId }
+func (r *ResetPasswordRequest) GetUserId() string           { return r.UserId }
+func (r *RestoreUserRequest) GetUserId() string             { return r.UserId }
+func (r *AssignRoleRequest) GetUserId() string              { return r.UserId }
+func (r *UnassignRoleRequest) GetUserId() string            { return r.UserId }
+func (r *ListUserRolesRequest) GetUserId() string           { return r.UserId }
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/mail"
+	"ppAuthService/internal/entity"
+	"ppAuthService/internal/notifier"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/secure"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the email of Register is passed in the metadata, the proto request has no field for it
+const emailKey = "x-email"
+
+// size of the verification token in bytes before encoding
+const emailVerificationTokenSize = 32
+
+// normalizeEmail checks the address and returns it in the lower case, so that the unique index is case insensitive
+func normalizeEmail(email string) (string, bool) {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != strings.TrimSpace(email) {
+		return "", false
+	}
+	return strings.ToLower(address.Address), true
+}
+
+// verifiedEmail returns the address the notifications of the user can be delivered to
+func verifiedEmail(user *entity.User) string {
+	if user.Email == nil || user.EmailVerifiedAt == nil {
+		return ""
+	}
+	return *user.Email
+}
+
+// sendEmailVerification creates the verification token of the email and sends it to that email
+func (s *Service) sendEmailVerification(userId *uuid.UUID, login string, email string, owner string) error {
+	token, err := secure.GenerateToken(emailVerificationTokenSize)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return status.Error(codes.Internal, err.Error())
+	}
+	expirationAt := time.Now().Add(s.email.VerificationTokenLifetime)
+	if err := s.store.AddEmailVerificationToken(&repoDto.AddEmailVerificationToken{
+		UserId:       userId,
+		Email:        email,
+		TokenHash:    secure.GetHash(token),
+		ExpirationAt: expirationAt,
+	}); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := s.notifier.Notify(context.Background(), &notifier.Notification{
+		Type:         notifier.TypeEmailVerification,
+		UserId:       userId,
+		Login:        login,
+		Email:        email,
+		Token:        token,
+		ExpirationAt: expirationAt,
+	}); err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return nil
+}
+
+// checkEmailVerified refuses the login of the users without a verified email when the policy requires it
+func (s *Service) checkEmailVerified(user *entity.User) error {
+	if !s.email.RequireVerified || user.EmailVerifiedAt != nil {
+		return nil
+	}
+	s.lg.Warn("email is not verified", slog.String("owner", "service.Login"), slog.Any("userId", user.UserId))
+	return status.Error(codes.FailedPrecondition, svcErr.ErrEmailNotVerified.Error())
+}
+
+func (s *Service) VerifyEmail(ctx context.Context, req *svcDto.VerifyEmailRequest) (*svcDto.VerifyEmailResponse, error) {
+	if req.Token == "" {
+		s.lg.Error("invalid email verification token value", slog.String("owner", "service.VerifyEmail"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidVerificationToken.Error())
+	}
+	// the token is consumed only together with the email it verifies
+	token, err := s.store.VerifyEmailByToken(secure.GetHash(req.Token), time.Now())
+	if err != nil {
+		if errors.Is(err, repoErr.ErrEmailUniqueViolation) {
+			return nil, status.Error(codes.AlreadyExists, svcErr.ErrEmailAlreadyExists.Error())
+		}
+		// the token is used or expired, or its email is neither pending nor unverified any more
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidVerificationToken.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "email.verify", slog.Any("userId", token.UserId), slog.String("email", token.Email))
+	return &svcDto.VerifyEmailResponse{Email: token.Email}, nil
+}
+
+// ChangeEmail keeps the current email until the new one is verified
+func (s *Service) ChangeEmail(ctx context.Context, req *svcDto.ChangeEmailRequest) (*svcDto.ChangeEmailResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ChangeEmail"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	newEmail, ok := normalizeEmail(req.NewEmail)
+	if !ok {
+		s.lg.Error("invalid email value", slog.String("owner", "service.ChangeEmail"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentEmail.Error())
+	}
+	user, err := s.store.GetUser(&userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.checkUserStatus(user, "service.ChangeEmail"); err != nil {
+		return nil, err
+	}
+	if err := s.checkReauth(ctx, user, "service.ChangeEmail"); err != nil {
+		return nil, err
+	}
+	if err := s.store.UpdateUserPendingEmail(&repoDto.UpdateUserPendingEmail{
+		UserId:       &userId,
+		PendingEmail: newEmail,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.sendEmailVerification(&userId, user.Login, newEmail, "service.ChangeEmail"); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, "email.change", slog.Any("userId", userId), slog.String("newEmail", newEmail))
+	return &svcDto.ChangeEmailResponse{}, nil
+}
+
+// ResendEmailVerification sends a new token for the pending email, or for the current one while it is not verified,
+// at most once in the cooldown
+func (s *Service) ResendEmailVerification(ctx context.Context, req *svcDto.ResendEmailVerificationRequest) (*svcDto.ResendEmailVerificationResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ResendEmailVerification"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	user, err := s.store.GetUser(&userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var email string
+	switch {
+	case user.PendingEmail != nil:
+		email = *user.PendingEmail
+	case user.Email != nil && user.EmailVerifiedAt == nil:
+		email = *user.Email
+	default:
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrNoEmailToVerify.Error())
+	}
+	lastSentAt, err := s.store.GetLastEmailVerificationTokenCreatedAt(&userId)
+	if err != nil && !errors.Is(err, repoErr.ErrRecordNotFound) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err == nil {
+		if wait := time.Until(lastSentAt.Add(s.email.ResendCooldown)); wait > 0 {
+			s.lg.Warn("verification email was sent recently", slog.String("owner", "service.ResendEmailVerification"), slog.Any("userId", userId))
+			setRetryAfter(ctx, wait)
+			return nil, status.Error(codes.ResourceExhausted, svcErr.ErrEmailResendTooSoon.Error())
+		}
+	}
+	if err := s.sendEmailVerification(&userId, user.Login, email, "service.ResendEmailVerification"); err != nil {
+		return nil, err
+	}
+	return &svcDto.ResendEmailVerificationResponse{}, nil
+}
@@ -12,4 +12,86 @@ var (
 	ErrTokenRevoked              = errors.New("token is revoke")
 	ErrUserNotFound              = errors.New("user not found")
 	ErrTokenNotFound             = errors.New("token not found")
+	ErrTooManyLoginAttempts      = errors.New("too many failed login attempts, retry later")
+	ErrLoginLocked               = errors.New("login is temporarily locked")
+	ErrInvalidArgumentLockout    = errors.New("login or ip value is required")
+	ErrInvalidArgumentSessionId  = errors.New("invalid session id value")
+	ErrSessionNotFound           = errors.New("session not found")
+	ErrTooManyDevices            = errors.New("maximum number of devices is reached")
+	ErrInvalidArgumentMaxDevices = errors.New("invalid max devices value")
+	ErrUserPending               = errors.New("user account is not activated")
+	ErrUserLocked                = errors.New("user account is locked")
+	ErrUserDisabled              = errors.New("user account is disabled")
+	ErrUserDeleted               = errors.New("user account is deleted")
+	ErrInvalidArgumentStatus     = errors.New("invalid user status value")
+	ErrInvalidArgumentReason     = errors.New("reason is required")
+	ErrRestoreWindowExpired      = errors.New("restore period of the user is over")
+	ErrStatusTransition          = errors.New("user status transition is not allowed")
+	ErrInvalidArgumentEmail      = errors.New("invalid email value")
+	ErrEmailAlreadyExists        = errors.New("email already exists")
+	ErrEmailNotVerified          = errors.New("email is not verified")
+	ErrInvalidVerificationToken  = errors.New("invalid or expired email verification token")
+	ErrNoEmailToVerify           = errors.New("there is no email to verify")
+	ErrEmailResendTooSoon        = errors.New("verification email was sent recently, retry later")
+	ErrInvalidArgumentRole       = errors.New("invalid role name value")
+	ErrInvalidArgumentPermission = errors.New("invalid permission name value")
+	ErrRoleAlreadyExists         = errors.New("role already exists")
+	ErrPermissionAlreadyExists   = errors.New("permission already exists")
+	ErrRoleNotFound              = errors.New("role not found")
+	ErrPermissionNotFound        = errors.New("permission not found")
+	ErrRoleOrPermissionNotFound  = errors.New("role or permission not found")
+	ErrUserOrRoleNotFound        = errors.New("user or role not found")
+	ErrInvalidArgumentCondition  = errors.New("invalid condition value")
+	ErrInvalidArgumentBatch      = errors.New("invalid number of checks in the batch")
+	ErrRelationsNotConfigured    = errors.New("relation namespaces are not configured")
+	ErrInvalidArgumentObject     = errors.New("invalid object value")
+	ErrInvalidArgumentRelation   = errors.New("invalid relation value")
+	ErrInvalidArgumentSubject    = errors.New("invalid subject value")
+	ErrInvalidArgumentZookie     = errors.New("invalid consistency token value")
+	ErrInvalidArgumentTuples     = errors.New("invalid number of tuples in the write")
+	ErrRelationDepthExceeded     = errors.New("relation check is too deep")
+	ErrRelationCheckTooLarge     = errors.New("relation check reads too many tuples")
+	ErrReauthRequired            = errors.New("current password or recent authentication is required")
+	ErrInvalidCurrentPassword    = errors.New("invalid current password")
+	ErrPasswordPolicy            = errors.New("password does not meet the password policy")
+	ErrInvalidResetToken         = errors.New("invalid or expired password reset token")
+	ErrMfaRequired               = errors.New("mfa code is required")
+	ErrInvalidMfaToken           = errors.New("invalid mfa token")
+	ErrInvalidMfaCode            = errors.New("invalid mfa code")
+	ErrMfaAlreadyEnabled         = errors.New("mfa is already enabled")
+	ErrMfaNotEnabled             = errors.New("mfa is not enabled")
+	ErrMfaNotEnrolled            = errors.New("mfa enrolment not found")
+	ErrTenantNotFound            = errors.New("tenant not found")
+	ErrTenantAlreadyExists       = errors.New("tenant already exists")
+	ErrInvalidArgumentTenant     = errors.New("invalid tenant name value")
+	ErrInvalidArgumentLifetime   = errors.New("invalid lifetime value")
+	ErrInvalidArgumentPolicy     = errors.New("invalid password policy value")
+	ErrTenantKeysNotConfigured   = errors.New("tenant signing keys are not configured")
+	ErrAccessTokenRequired       = errors.New("access token is required")
+	ErrInvalidAccessToken        = errors.New("invalid access token")
+	ErrAdminRoleRequired         = errors.New("admin role is required")
+	ErrNotTokenOwner             = errors.New("access token does not belong to the user")
+	ErrInvalidArgumentCursor     = errors.New("invalid cursor value")
+	ErrInvalidArgumentPageSize   = errors.New("invalid page size value")
+	ErrInvalidArgumentCreated    = errors.New("invalid created range value")
+	ErrInvalidArgumentLogin      = errors.New("invalid login value")
+	ErrUnsupportedGrantType      = errors.New("unsupported grant type")
+	ErrInvalidClient             = errors.New("invalid client credentials")
+	ErrInvalidArgumentScope      = errors.New("invalid scope value")
+	ErrInvalidArgumentName       = errors.New("invalid name value")
+	ErrInvalidArgumentClientId   = errors.New("invalid client id value")
+	ErrServiceAccountNotFound    = errors.New("service account not found")
+	ErrSecretNotFound            = errors.New("secret not found")
+	ErrTooManySecrets            = errors.New("too many unexpired secrets")
+	ErrInvalidApiKey             = errors.New("invalid api key")
+	ErrApiKeyNotFound            = errors.New("api key not found")
+	ErrTooManyApiKeys            = errors.New("too many unexpired api keys")
+	ErrInvalidOAuthClient        = errors.New("invalid oauth client")
+	ErrOAuthClientNotFound       = errors.New("oauth client not found")
+	ErrInvalidRedirectUri        = errors.New("invalid redirect uri value")
+	ErrInvalidArgumentClientType = errors.New("invalid client type value")
+	ErrInvalidGrant              = errors.New("invalid authorization grant")
+	ErrInsufficientScope         = errors.New("access token has no openid scope")
+	ErrDelegatedTokenNotAllowed  = errors.New("api key, service account and scoped tokens can not call the method")
+	ErrScopeRequired             = errors.New("access token has no scope of the method")
 )
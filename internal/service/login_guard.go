@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// backoff returns the delay required after failedCount failures
+func (s *Service) backoff(failedCount int, threshold int) time.Duration {
+	if failedCount < threshold {
+		return 0
+	}
+	shift := failedCount - threshold
+	if shift > 30 {
+		return s.loginGuard.BackoffMax
+	}
+	delay := s.loginGuard.BackoffBase << shift
+	if delay > s.loginGuard.BackoffMax {
+		return s.loginGuard.BackoffMax
+	}
+	return delay
+}
+
+type loginAttemptKey struct {
+	keyType          string
+	key              string
+	backoffThreshold int
+	lockoutThreshold int
+}
+
+func (s *Service) loginAttemptKeys(login string, ip string) []loginAttemptKey {
+	keys := []loginAttemptKey{{entity.LoginAttemptKeyTypeLogin, login, s.loginGuard.LoginBackoffThreshold, s.loginGuard.LoginLockoutThreshold}}
+	if ip != "" {
+		keys = append(keys, loginAttemptKey{entity.LoginAttemptKeyTypeIp, ip, s.loginGuard.IpBackoffThreshold, s.loginGuard.IpLockoutThreshold})
+	}
+	return keys
+}
+
+// checkLoginAttempts refuses the login while the login or the ip is locked or in backoff
+func (s *Service) checkLoginAttempts(ctx context.Context, login string, ip string) error {
+	now := time.Now()
+	for _, key := range s.loginAttemptKeys(login, ip) {
+		loginAttempt, err := s.store.GetLoginAttempt(key.keyType, key.key)
+		if err != nil {
+			if errors.Is(err, repoErr.ErrRecordNotFound) {
+				continue
+			}
+			return status.Error(codes.Internal, err.Error())
+		}
+		if loginAttempt.LastFailedAt.Before(now.Add(-s.loginGuard.ResetAfter)) {
+			continue
+		}
+		if loginAttempt.LockedUntil != nil && now.Before(*loginAttempt.LockedUntil) {
+			s.lg.Warn("login is locked", slog.String("owner", "service.Login"), slog.String("keyType", key.keyType), slog.String("key", key.key))
+			setRetryAfter(ctx, loginAttempt.LockedUntil.Sub(now))
+			return status.Error(codes.PermissionDenied, svcErr.ErrLoginLocked.Error())
+		}
+		retryAt := loginAttempt.LastFailedAt.Add(s.backoff(loginAttempt.FailedCount, key.backoffThreshold))
+		if now.Before(retryAt) {
+			s.lg.Warn("login attempt in backoff", slog.String("owner", "service.Login"), slog.String("keyType", key.keyType), slog.String("key", key.key))
+			setRetryAfter(ctx, retryAt.Sub(now))
+			return status.Error(codes.ResourceExhausted, svcErr.ErrTooManyLoginAttempts.Error())
+		}
+	}
+	return nil
+}
+
+// addLoginFailure counts the failure and locks the login or the ip once the lockout threshold is reached
+func (s *Service) addLoginFailure(ctx context.Context, login string, ip string) {
+	s.audit(ctx, "login.failure", slog.String("login", login))
+	now := time.Now()
+	for _, key := range s.loginAttemptKeys(login, ip) {
+		loginAttempt, err := s.store.AddLoginAttemptFailure(&repoDto.AddLoginAttemptFailure{
+			KeyType:     key.keyType,
+			Key:         key.key,
+			FailedAt:    now,
+			ResetBefore: now.Add(-s.loginGuard.ResetAfter),
+		})
+		if err != nil {
+			continue
+		}
+		if loginAttempt.FailedCount < key.lockoutThreshold {
+			continue
+		}
+		if err := s.store.UpdateLoginAttemptLockedUntil(&repoDto.UpdateLoginAttemptLockedUntil{
+			KeyType:     key.keyType,
+			Key:         key.key,
+			LockedUntil: now.Add(s.loginGuard.LockoutDuration),
+		}); err != nil {
+			continue
+		}
+		s.lg.Warn("login is locked out", slog.String("owner", "service.Login"), slog.String("keyType", key.keyType), slog.String("key", key.key), slog.Int("failedCount", loginAttempt.FailedCount))
+	}
+}
+
+// resetLoginFailures forgets the failures of the login after a successful login. The ip counter is kept,
+// otherwise one known account would be enough to keep guessing the others from the same address
+func (s *Service) resetLoginFailures(login string) {
+	if err := s.store.RemoveLoginAttempt(entity.LoginAttemptKeyTypeLogin, login); err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.resetLoginFailures"), slog.String("login", login))
+	}
+}
+
+func (s *Service) ClearLoginLockout(ctx context.Context, req *svcDto.ClearLoginLockoutRequest) (*svcDto.ClearLoginLockoutResponse, error) {
+	if req.Login == "" && req.Ip == "" {
+		s.lg.Error("invalid lockout key value", slog.String("owner", "service.ClearLoginLockout"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentLockout.Error())
+	}
+	// the ip counter is shared by all the tenants, only the admins of the default tenant clear it
+	if req.Ip != "" {
+		if err := s.checkTenantsAdmin(ctx, "service.ClearLoginLockout"); err != nil {
+			return nil, err
+		}
+	}
+	if req.Login != "" {
+		if err := s.store.RemoveLoginAttempt(entity.LoginAttemptKeyTypeLogin, tenantLogin(adminTenantId(ctx), req.Login)); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if req.Ip != "" {
+		if err := s.store.RemoveLoginAttempt(entity.LoginAttemptKeyTypeIp, req.Ip); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	s.audit(ctx, "admin.lockout.clear", slog.Any("tenantId", adminTenantId(ctx)), slog.String("login", req.Login), slog.String("ip", req.Ip))
+	return &svcDto.ClearLoginLockoutResponse{}, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"ppAuthService/internal/config"
+	"ppAuthService/internal/entity"
+	"ppAuthService/internal/repository"
+	repoErr "ppAuthService/internal/repository/err"
+	svcErr "ppAuthService/internal/service/err"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testLoginGuard = &config.LoginGuard{
+	LoginBackoffThreshold: 3,
+	LoginLockoutThreshold: 10,
+	IpBackoffThreshold:    20,
+	IpLockoutThreshold:    100,
+	BackoffBase:           time.Second,
+	BackoffMax:            300 * time.Second,
+	LockoutDuration:       900 * time.Second,
+	ResetAfter:            24 * time.Hour,
+}
+
+func TestBackoff(t *testing.T) {
+	s := &Service{loginGuard: testLoginGuard}
+	tests := []struct {
+		failedCount int
+		want        time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{11, 256 * time.Second},
+		{12, 300 * time.Second},
+		{40, 300 * time.Second},
+		// the shift does not overflow
+		{100, 300 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := s.backoff(tt.failedCount, testLoginGuard.LoginBackoffThreshold); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failedCount, got, tt.want)
+		}
+	}
+}
+
+// loginAttemptStore answers GetLoginAttempt from the attempts in memory
+type loginAttemptStore struct {
+	repository.Repository
+	loginAttempts map[string]*entity.LoginAttempt
+}
+
+func (s *loginAttemptStore) GetLoginAttempt(keyType string, key string) (*entity.LoginAttempt, error) {
+	if loginAttempt, ok := s.loginAttempts[keyType+":"+key]; ok {
+		return loginAttempt, nil
+	}
+	return nil, repoErr.ErrRecordNotFound
+}
+
+// headerStream keeps the header metadata set by the call
+type headerStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestCheckLoginAttempts(t *testing.T) {
+	now := time.Now()
+	lockedUntil := now.Add(10 * time.Minute)
+	expiredLock := now.Add(-time.Minute)
+	tests := []struct {
+		name         string
+		loginAttempt *entity.LoginAttempt
+		code         codes.Code
+		err          error
+		retryAfter   string
+	}{
+		{name: "no failures", code: codes.OK},
+		{name: "below the backoff threshold", code: codes.OK,
+			loginAttempt: &entity.LoginAttempt{FailedCount: 2, LastFailedAt: now}},
+		{name: "backoff", code: codes.ResourceExhausted, err: svcErr.ErrTooManyLoginAttempts, retryAfter: "4",
+			loginAttempt: &entity.LoginAttempt{FailedCount: 5, LastFailedAt: now}},
+		{name: "backoff passed", code: codes.OK,
+			loginAttempt: &entity.LoginAttempt{FailedCount: 5, LastFailedAt: now.Add(-5 * time.Second)}},
+		{name: "lockout", code: codes.PermissionDenied, err: svcErr.ErrLoginLocked, retryAfter: "600",
+			loginAttempt: &entity.LoginAttempt{FailedCount: 10, LastFailedAt: now, LockedUntil: &lockedUntil}},
+		{name: "lockout passed", code: codes.OK,
+			loginAttempt: &entity.LoginAttempt{FailedCount: 10, LastFailedAt: now.Add(-20 * time.Minute), LockedUntil: &expiredLock}},
+		{name: "failures reset", code: codes.OK,
+			loginAttempt: &entity.LoginAttempt{FailedCount: 10, LastFailedAt: now.Add(-25 * time.Hour), LockedUntil: &lockedUntil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &loginAttemptStore{loginAttempts: map[string]*entity.LoginAttempt{}}
+			if tt.loginAttempt != nil {
+				store.loginAttempts[entity.LoginAttemptKeyTypeLogin+":alice"] = tt.loginAttempt
+			}
+			s := &Service{store: store, loginGuard: testLoginGuard, lg: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			stream := &headerStream{}
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+			err := s.checkLoginAttempts(ctx, "alice", "")
+			if status.Code(err) != tt.code {
+				t.Fatalf("checkLoginAttempts() error = %v, want code %v", err, tt.code)
+			}
+			if tt.err != nil && status.Convert(err).Message() != tt.err.Error() {
+				t.Errorf("checkLoginAttempts() error = %v, want %v", err, tt.err)
+			}
+			if got := strings.Join(stream.header.Get("retry-after"), ","); got != tt.retryAfter {
+				t.Errorf("checkLoginAttempts() retry-after = %v, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
+
+// the ip is checked with its own thresholds
+func TestCheckLoginAttemptsIp(t *testing.T) {
+	now := time.Now()
+	store := &loginAttemptStore{loginAttempts: map[string]*entity.LoginAttempt{
+		entity.LoginAttemptKeyTypeIp + ":10.0.0.5": {FailedCount: 19, LastFailedAt: now},
+		entity.LoginAttemptKeyTypeIp + ":10.0.0.6": {FailedCount: 20, LastFailedAt: now},
+	}}
+	s := &Service{store: store, loginGuard: testLoginGuard, lg: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	if err := s.checkLoginAttempts(context.Background(), "alice", "10.0.0.5"); err != nil {
+		t.Errorf("checkLoginAttempts() below the ip threshold error = %v", err)
+	}
+	if err := s.checkLoginAttempts(context.Background(), "alice", "10.0.0.6"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("checkLoginAttempts() at the ip threshold error = %v, want %v", err, codes.ResourceExhausted)
+	}
+}
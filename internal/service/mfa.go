@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/jwt"
+	"ppAuthService/pkg/secure"
+	"ppAuthService/pkg/totp"
+	"time"
+
+	proto "github.com/MedvedevEA/ppProtos/gen/auth"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The second step of Login is the same Login call with the challenge token and the code in the metadata
+const (
+	mfaTokenKey   = "x-mfa-token"
+	mfaCodeKey    = "x-mfa-code"
+	mfaMethodsKey = "x-mfa-methods"
+	// number of the recovery codes left after one of them was used
+	mfaRecoveryCodesRemainingKey = "x-mfa-recovery-codes-remaining"
+)
+
+// getConfirmedUserMfa returns nil when the user has no confirmed mfa
+func (s *Service) getConfirmedUserMfa(userId *uuid.UUID) (*entity.UserMfa, error) {
+	userMfa, err := s.store.GetUserMfa(userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !userMfa.IsConfirmed {
+		return nil, nil
+	}
+	return userMfa, nil
+}
+
+// createMfaToken creates the challenge token of the second step of the login
+func (s *Service) createMfaToken(userId *uuid.UUID, deviceCode string, owner string) (string, error) {
+	mfaTokenString, _, err := jwt.CreateToken(userId, deviceCode, "mfa", s.mfa.ChallengeLifetime, s.signingKey)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	return mfaTokenString, nil
+}
+
+// mfaChallenge passes the challenge token to the client in the metadata and stops the first step of Login
+func (s *Service) mfaChallenge(ctx context.Context, userId *uuid.UUID, deviceCode string) error {
+	if isOAuthDeviceCode(deviceCode) {
+		s.lg.Error("invalid device code value", slog.String("owner", "service.Login"))
+		return status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentDeviceCode.Error())
+	}
+	mfaTokenString, err := s.createMfaToken(userId, deviceCode, "service.Login")
+	if err != nil {
+		return err
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(mfaTokenKey, mfaTokenString, mfaMethodsKey, "totp", mfaMethodsKey, "recovery-code"))
+	return status.Error(codes.FailedPrecondition, svcErr.ErrMfaRequired.Error())
+}
+
+// loginMfa is the second step of Login. The challenge tokens of the authorization requests of the OAuth clients
+// are completed only by Authorize
+func (s *Service) loginMfa(ctx context.Context, mfaToken string, code string) (*proto.LoginResponse, error) {
+	user, claims, err := s.checkLoginMfa(ctx, mfaToken, code, "service.Login")
+	if err != nil {
+		return nil, err
+	}
+	if isOAuthDeviceCode(claims.DeviceCode) {
+		s.lg.Error("mfa token of an OAuth client", slog.String("owner", "service.Login"))
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidMfaToken.Error())
+	}
+	return s.login(ctx, user, claims.DeviceCode, "service.Login")
+}
+
+// checkLoginMfa verifies the challenge token and the code under the brute-force protection
+func (s *Service) checkLoginMfa(ctx context.Context, mfaToken string, code string, owner string) (*entity.User, *jwt.TokenClaims, error) {
+	claims, err := s.ParseToken(mfaToken)
+	if err != nil || claims.TokenType != "mfa" {
+		s.lg.Error("mfa token verification error", slog.String("owner", owner))
+		return nil, nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidMfaToken.Error())
+	}
+	user, err := s.store.GetUser(claims.Sub)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidMfaToken.Error())
+		}
+		return nil, nil, status.Error(codes.Internal, err.Error())
+	}
+	ip := clientIp(ctx)
+	if err := s.checkLoginAttempts(ctx, tenantLogin(user.TenantId, user.Login), ip); err != nil {
+		return nil, nil, err
+	}
+	if err := s.checkUserStatus(user, owner); err != nil {
+		return nil, nil, err
+	}
+	userMfa, err := s.getConfirmedUserMfa(user.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if userMfa == nil {
+		return nil, nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidMfaToken.Error())
+	}
+	ok, err := s.verifyMfaCode(ctx, userMfa, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		s.lg.Error("mfa code verification error", slog.String("owner", owner))
+		s.addLoginFailure(ctx, tenantLogin(user.TenantId, user.Login), ip)
+		return nil, nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidMfaCode.Error())
+	}
+	s.resetLoginFailures(tenantLogin(user.TenantId, user.Login))
+	return user, claims, nil
+}
+
+// checkUserMfaCode verifies the code of the mfa management calls under the same brute-force protection as
+// the second step of the login, so the code can not be guessed with them either
+func (s *Service) checkUserMfaCode(ctx context.Context, userId *uuid.UUID, verify func() (bool, error), owner string) error {
+	user, err := s.store.GetUser(userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	login, ip := tenantLogin(user.TenantId, user.Login), clientIp(ctx)
+	if err := s.checkLoginAttempts(ctx, login, ip); err != nil {
+		return err
+	}
+	ok, err := verify()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.lg.Error("mfa code verification error", slog.String("owner", owner), slog.Any("userId", userId))
+		s.addLoginFailure(ctx, login, ip)
+		return status.Error(codes.InvalidArgument, svcErr.ErrInvalidMfaCode.Error())
+	}
+	s.resetLoginFailures(login)
+	return nil
+}
+
+// verifyMfaCode accepts either the TOTP code or one of the recovery codes
+func (s *Service) verifyMfaCode(ctx context.Context, userMfa *entity.UserMfa, code string) (bool, error) {
+	if isTotpCode(code) {
+		return s.verifyTotp(userMfa, code)
+	}
+	return s.useRecoveryCode(ctx, userMfa.UserId, code)
+}
+
+// verifyTotp checks the code and marks its step as used, so the code can not be replayed
+func (s *Service) verifyTotp(userMfa *entity.UserMfa, code string) (bool, error) {
+	secret, err := secure.Decrypt(userMfa.Secret, s.mfaKey)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.verifyTotp"))
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	step, ok := totp.Validate(secret, code, time.Now(), s.mfa.Skew)
+	if !ok {
+		return false, nil
+	}
+	if err := s.store.UpdateUserMfaLastUsedStep(&repoDto.UpdateUserMfaLastUsedStep{
+		UserId:       userMfa.UserId,
+		LastUsedStep: step,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.lg.Warn("mfa code is reused", slog.String("owner", "service.verifyTotp"), slog.Any("userId", userMfa.UserId))
+			return false, nil
+		}
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	return true, nil
+}
+
+func (s *Service) EnrollTotp(ctx context.Context, req *svcDto.EnrollTotpRequest) (*svcDto.EnrollTotpResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.EnrollTotp"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	user, err := s.store.GetUser(&userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.checkUserStatus(user, "service.EnrollTotp"); err != nil {
+		return nil, err
+	}
+	// a stolen access token must not be enough to put a second factor of someone else on the account
+	if err := s.checkReauth(ctx, user, "service.EnrollTotp"); err != nil {
+		return nil, err
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.EnrollTotp"))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	encryptedSecret, err := secure.Encrypt(secret, s.mfaKey)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.EnrollTotp"))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.store.SetUserMfa(&repoDto.SetUserMfa{
+		UserId: &userId,
+		Secret: encryptedSecret,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrUniqueViolation) {
+			return nil, status.Error(codes.AlreadyExists, svcErr.ErrMfaAlreadyEnabled.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &svcDto.EnrollTotpResponse{
+		Secret: secret,
+		Uri:    totp.Uri(s.mfa.Issuer, user.Login, secret),
+	}, nil
+}
+func (s *Service) ConfirmTotp(ctx context.Context, req *svcDto.ConfirmTotpRequest) (*svcDto.ConfirmTotpResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ConfirmTotp"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	userMfa, err := s.store.GetUserMfa(&userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrMfaNotEnrolled.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if userMfa.IsConfirmed {
+		return nil, status.Error(codes.AlreadyExists, svcErr.ErrMfaAlreadyEnabled.Error())
+	}
+	if err := s.checkUserMfaCode(ctx, &userId, func() (bool, error) {
+		return s.verifyTotp(userMfa, req.Code)
+	}, "service.ConfirmTotp"); err != nil {
+		return nil, err
+	}
+	recoveryCodes, codeHashes, err := s.generateRecoveryCodes("service.ConfirmTotp")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.ConfirmUserMfa(&repoDto.SetMfaRecoveryCodes{
+		UserId:     &userId,
+		CodeHashes: codeHashes,
+	}); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.lg.Info("mfa is enabled", slog.String("owner", "service.ConfirmTotp"), slog.Any("userId", userId))
+	return &svcDto.ConfirmTotpResponse{RecoveryCodes: recoveryCodes}, nil
+}
+func (s *Service) DisableTotp(ctx context.Context, req *svcDto.DisableTotpRequest) (*svcDto.DisableTotpResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.DisableTotp"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	userMfa, err := s.getConfirmedUserMfa(&userId)
+	if err != nil {
+		return nil, err
+	}
+	if userMfa == nil {
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrMfaNotEnabled.Error())
+	}
+	if err := s.checkUserMfaCode(ctx, &userId, func() (bool, error) {
+		return s.verifyMfaCode(ctx, userMfa, req.Code)
+	}, "service.DisableTotp"); err != nil {
+		return nil, err
+	}
+	if err := s.store.RemoveUserMfa(&userId); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.store.RemoveMfaRecoveryCodes(&userId); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.lg.Info("mfa is disabled", slog.String("owner", "service.DisableTotp"), slog.Any("userId", userId))
+	return &svcDto.DisableTotpResponse{}, nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/secure"
+	"ppAuthService/pkg/totp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// recovery codes look like k7d2m-q9xfa, the alphabet has no look-alike characters
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+func isTotpCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// generateRecoveryCode picks the characters uniformly: the random bytes past the largest multiple of the alphabet
+// size are dropped, otherwise the first characters of the alphabet would be more likely
+func generateRecoveryCode() (string, error) {
+	limit := byte(256 - 256%len(recoveryCodeAlphabet))
+	code := make([]byte, 0, 10)
+	b := make([]byte, 16)
+	for len(code) < cap(code) {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		for _, c := range b {
+			if c < limit && len(code) < cap(code) {
+				code = append(code, recoveryCodeAlphabet[int(c)%len(recoveryCodeAlphabet)])
+			}
+		}
+	}
+	return string(code[:5]) + "-" + string(code[5:]), nil
+}
+
+// normalizeRecoveryCode makes the code case and separator insensitive
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// generateRecoveryCodes returns a new set of the recovery codes and their hashes, only the hashes are stored
+func (s *Service) generateRecoveryCodes(owner string) ([]string, []string, error) {
+	recoveryCodes := make([]string, 0, s.mfa.RecoveryCodesCount)
+	codeHashes := make([]string, 0, s.mfa.RecoveryCodesCount)
+	for range s.mfa.RecoveryCodesCount {
+		recoveryCode, err := generateRecoveryCode()
+		if err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", owner))
+			return nil, nil, status.Error(codes.Internal, err.Error())
+		}
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+		codeHashes = append(codeHashes, secure.GetHash(normalizeRecoveryCode(recoveryCode)))
+	}
+	return recoveryCodes, codeHashes, nil
+}
+
+// setRecoveryCodes replaces the recovery codes of the user with a new set and returns it
+func (s *Service) setRecoveryCodes(userId *uuid.UUID, owner string) ([]string, error) {
+	recoveryCodes, codeHashes, err := s.generateRecoveryCodes(owner)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.SetMfaRecoveryCodes(&repoDto.SetMfaRecoveryCodes{
+		UserId:     userId,
+		CodeHashes: codeHashes,
+	}); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return recoveryCodes, nil
+}
+
+// useRecoveryCode marks the code as used and warns the user when few codes remain
+func (s *Service) useRecoveryCode(ctx context.Context, userId *uuid.UUID, code string) (bool, error) {
+	ip := clientIp(ctx)
+	if err := s.store.UseMfaRecoveryCode(&repoDto.UseMfaRecoveryCode{
+		UserId:   userId,
+		CodeHash: secure.GetHash(normalizeRecoveryCode(code)),
+		UsedIp:   ip,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	remaining, err := s.store.GetUnusedMfaRecoveryCodeCount(userId)
+	if err != nil {
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	s.lg.Info("mfa recovery code is used", slog.String("owner", "service.useRecoveryCode"), slog.Any("userId", userId), slog.String("ip", ip), slog.Int64("remaining", remaining))
+	if remaining <= int64(s.mfa.RecoveryCodesWarnThreshold) {
+		s.lg.Warn("few mfa recovery codes remain", slog.String("owner", "service.useRecoveryCode"), slog.Any("userId", userId), slog.Int64("remaining", remaining))
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(mfaRecoveryCodesRemainingKey, strconv.FormatInt(remaining, 10)))
+	return true, nil
+}
+
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, req *svcDto.RegenerateRecoveryCodesRequest) (*svcDto.RegenerateRecoveryCodesResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.RegenerateRecoveryCodes"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	userMfa, err := s.getConfirmedUserMfa(&userId)
+	if err != nil {
+		return nil, err
+	}
+	if userMfa == nil {
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrMfaNotEnabled.Error())
+	}
+	if err := s.checkUserMfaCode(ctx, &userId, func() (bool, error) {
+		return s.verifyMfaCode(ctx, userMfa, req.Code)
+	}, "service.RegenerateRecoveryCodes"); err != nil {
+		return nil, err
+	}
+	recoveryCodes, err := s.setRecoveryCodes(&userId, "service.RegenerateRecoveryCodes")
+	if err != nil {
+		return nil, err
+	}
+	s.lg.Info("mfa recovery codes are regenerated", slog.String("owner", "service.RegenerateRecoveryCodes"), slog.Any("userId", userId))
+	return &svcDto.RegenerateRecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
@@ -0,0 +1,527 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/url"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/jwt"
+	"ppAuthService/pkg/secure"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grantTypeAuthorizationCode = "authorization_code"
+	grantTypeRefreshToken      = "refresh_token"
+	codeChallengeMethodS256    = "S256"
+	// sizes of the client id, the client secret and the authorization code in bytes before encoding
+	oauthClientIdSize     = 12
+	oauthClientSecretSize = 32
+	authorizationCodeSize = 32
+)
+
+// the PKCE code verifier and the S256 challenge, RFC 7636
+var codeVerifierRegexp = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// the prefix of the device codes of the OAuth clients, reserved for them
+const oauthDevicePrefix = "oauth:"
+
+// oauthDeviceCode is the device of the sessions of the OAuth client, so the device limit, the session list and
+// the refresh token reuse detection work the same way as for the first-party logins
+func oauthDeviceCode(clientId string) string {
+	return oauthDevicePrefix + clientId
+}
+
+// isOAuthDeviceCode reports whether the device code is the one of an OAuth client, the first-party calls
+// must not use such a device
+func isOAuthDeviceCode(deviceCode string) bool {
+	return strings.HasPrefix(deviceCode, oauthDevicePrefix)
+}
+
+// oauthClientId returns the client of the device code, empty for the first-party devices
+func oauthClientId(deviceCode string) string {
+	clientId, ok := strings.CutPrefix(deviceCode, oauthDevicePrefix)
+	if !ok {
+		return ""
+	}
+	return clientId
+}
+
+// authorizeRedirect returns the redirect uri with the parameters added to its query
+func authorizeRedirect(redirectUri string, params url.Values) string {
+	u, err := url.Parse(redirectUri)
+	if err != nil {
+		return redirectUri
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, value := range values {
+			if value != "" {
+				query.Add(key, value)
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// authorizeError is the error that is passed back to the client by the redirect, RFC 6749 4.1.2.1
+func authorizeError(redirectUri string, errorCode string, state string) *svcDto.AuthorizeResponse {
+	return &svcDto.AuthorizeResponse{RedirectUri: authorizeRedirect(redirectUri, url.Values{"error": {errorCode}, "state": {state}})}
+}
+
+// validRedirectUri accepts the absolute uri without the fragment, plain http only for the loopback
+func validRedirectUri(redirectUri string) bool {
+	u, err := url.Parse(redirectUri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" || u.Opaque != "" {
+		return false
+	}
+	if u.Scheme == "http" {
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return true
+}
+
+// verifyCodeChallenge compares the S256 hash of the verifier with the challenge of the code
+func verifyCodeChallenge(codeVerifier string, codeChallenge string) bool {
+	if !codeVerifierRegexp.MatchString(codeVerifier) {
+		return false
+	}
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(hash[:])), []byte(codeChallenge)) == 1
+}
+
+// validAuthorizationCode checks the code taken by the token request of the client. The redirect uri is compared
+// only when the authorization request sent it (RFC 6749 4.1.3), a verifier is refused for a code without a challenge
+func validAuthorizationCode(code *entity.OAuthAuthorizationCode, oauthClient *entity.OAuthClient, redirectUri string, codeVerifier string, now time.Time) bool {
+	valid := *code.OAuthClientId == *oauthClient.OAuthClientId &&
+		code.ExpirationAt.After(now) &&
+		(!code.RedirectUriSent || code.RedirectUri == redirectUri)
+	if code.CodeChallenge != nil {
+		return valid && verifyCodeChallenge(codeVerifier, *code.CodeChallenge)
+	}
+	return valid && codeVerifier == ""
+}
+
+func (s *Service) parseRedirectUris(redirectUris []string, owner string) ([]string, error) {
+	if len(redirectUris) == 0 {
+		s.lg.Error("redirect uri is required", slog.String("owner", owner))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidRedirectUri.Error())
+	}
+	for _, redirectUri := range redirectUris {
+		if !validRedirectUri(redirectUri) {
+			s.lg.Error("invalid redirect uri value", slog.String("owner", owner), slog.String("redirectUri", redirectUri))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidRedirectUri.Error())
+		}
+	}
+	return slices.Compact(slices.Sorted(slices.Values(redirectUris))), nil
+}
+
+// getOAuthClient returns the client of the tenant the admin of the call manages
+func (s *Service) getOAuthClient(ctx context.Context, clientId string) (*entity.OAuthClient, error) {
+	if clientId == "" {
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentClientId.Error())
+	}
+	oauthClient, err := s.store.GetOAuthClientByClientId(clientId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrOAuthClientNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if *oauthClient.TenantId != *adminTenantId(ctx) {
+		return nil, status.Error(codes.NotFound, svcErr.ErrOAuthClientNotFound.Error())
+	}
+	return oauthClient, nil
+}
+
+func (s *Service) CreateOAuthClient(ctx context.Context, req *svcDto.CreateOAuthClientRequest) (*svcDto.CreateOAuthClientResponse, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		s.lg.Error("invalid name value", slog.String("owner", "service.CreateOAuthClient"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentName.Error())
+	}
+	if req.ClientType != entity.OAuthClientTypePublic && req.ClientType != entity.OAuthClientTypeConfidential {
+		s.lg.Error("invalid client type value", slog.String("owner", "service.CreateOAuthClient"), slog.String("clientType", req.ClientType))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentClientType.Error())
+	}
+	redirectUris, err := s.parseRedirectUris(req.RedirectUris, "service.CreateOAuthClient")
+	if err != nil {
+		return nil, err
+	}
+	scopes, err := s.parseScopes(req.Scopes, "service.CreateOAuthClient")
+	if err != nil {
+		return nil, err
+	}
+	clientId, err := secure.GenerateToken(oauthClientIdSize)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.CreateOAuthClient"))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	var clientSecret string
+	var secretHash *string
+	if req.ClientType == entity.OAuthClientTypeConfidential {
+		if clientSecret, err = secure.GenerateToken(oauthClientSecretSize); err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", "service.CreateOAuthClient"))
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		hash := secure.GetHash(clientSecret)
+		secretHash = &hash
+	}
+	if _, err := s.store.AddOAuthClient(&repoDto.AddOAuthClient{
+		TenantId:     adminTenantId(ctx),
+		ClientId:     clientId,
+		Name:         req.Name,
+		ClientType:   req.ClientType,
+		SecretHash:   secretHash,
+		RedirectUris: redirectUris,
+		Scopes:       scopes,
+	}); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "oauth.client.create", slog.String("clientId", clientId), slog.String("name", req.Name), slog.String("clientType", req.ClientType))
+	return &svcDto.CreateOAuthClientResponse{ClientId: clientId, ClientSecret: clientSecret}, nil
+}
+func (s *Service) UpdateOAuthClient(ctx context.Context, req *svcDto.UpdateOAuthClientRequest) (*svcDto.UpdateOAuthClientResponse, error) {
+	oauthClient, err := s.getOAuthClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	dto := &repoDto.UpdateOAuthClient{OAuthClientId: oauthClient.OAuthClientId}
+	if req.RedirectUris != nil {
+		if dto.RedirectUris, err = s.parseRedirectUris(req.RedirectUris, "service.UpdateOAuthClient"); err != nil {
+			return nil, err
+		}
+	}
+	if req.Scopes != nil {
+		if dto.Scopes, err = s.parseScopes(req.Scopes, "service.UpdateOAuthClient"); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.store.UpdateOAuthClient(dto); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrOAuthClientNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "oauth.client.update", slog.String("clientId", req.ClientId), slog.Any("redirectUris", dto.RedirectUris), slog.Any("scopes", dto.Scopes))
+	return &svcDto.UpdateOAuthClientResponse{}, nil
+}
+
+// DeleteOAuthClient removes the client and revokes the refresh tokens of its sessions with all the users
+func (s *Service) DeleteOAuthClient(ctx context.Context, req *svcDto.DeleteOAuthClientRequest) (*svcDto.DeleteOAuthClientResponse, error) {
+	oauthClient, err := s.getOAuthClient(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.RemoveOAuthClient(&repoDto.RemoveOAuthClient{
+		OAuthClientId: oauthClient.OAuthClientId,
+		DeviceCode:    oauthDeviceCode(oauthClient.ClientId),
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrOAuthClientNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "oauth.client.delete", slog.String("clientId", req.ClientId))
+	return &svcDto.DeleteOAuthClientResponse{}, nil
+}
+func (s *Service) ListOAuthClients(ctx context.Context, req *svcDto.ListOAuthClientsRequest) (*svcDto.ListOAuthClientsResponse, error) {
+	oauthClients, err := s.store.GetOAuthClients(adminTenantId(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.ListOAuthClientsResponse{OAuthClients: make([]*svcDto.OAuthClient, 0, len(oauthClients))}
+	for _, oauthClient := range oauthClients {
+		resp.OAuthClients = append(resp.OAuthClients, &svcDto.OAuthClient{
+			ClientId:     oauthClient.ClientId,
+			Name:         oauthClient.Name,
+			ClientType:   oauthClient.ClientType,
+			RedirectUris: oauthClient.RedirectUris,
+			Scopes:       oauthClient.Scopes,
+			CreatedAt:    oauthClient.CreatedAt,
+		})
+	}
+	return resp, nil
+}
+
+// authorizeRequest is the checked authorization request
+type authorizeRequest struct {
+	client      *entity.OAuthClient
+	redirectUri string
+	// the redirect uri was sent and not taken from the only registered one
+	redirectUriSent bool
+	scopes          []string
+	// nil when the confidential client does not use PKCE
+	codeChallenge *string
+	// nil when the client did not send the nonce
+	nonce *string
+}
+
+// checkAuthorizeRequest returns the error for the unknown client and the unregistered redirect uri, the user
+// must not be redirected then. The other errors are returned as the redirect to the client
+func (s *Service) checkAuthorizeRequest(req *svcDto.AuthorizeRequest, owner string) (*authorizeRequest, *svcDto.AuthorizeResponse, error) {
+	oauthClient, err := s.store.GetOAuthClientByClientId(req.ClientId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.lg.Error("oauth client not found", slog.String("owner", owner), slog.String("clientId", req.ClientId))
+			return nil, nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidOAuthClient.Error())
+		}
+		return nil, nil, status.Error(codes.Internal, err.Error())
+	}
+	redirectUri := req.RedirectUri
+	// the redirect uri may be omitted when the client has only one
+	if redirectUri == "" && len(oauthClient.RedirectUris) == 1 {
+		redirectUri = oauthClient.RedirectUris[0]
+	}
+	if !slices.Contains(oauthClient.RedirectUris, redirectUri) {
+		s.lg.Error("redirect uri is not registered", slog.String("owner", owner), slog.String("clientId", req.ClientId), slog.String("redirectUri", redirectUri))
+		return nil, nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidRedirectUri.Error())
+	}
+	if req.ResponseType != "code" {
+		return nil, authorizeError(redirectUri, "unsupported_response_type", req.State), nil
+	}
+	scopes := append([]string{}, oauthClient.Scopes...)
+	if req.Scope != "" {
+		if scopes, err = s.parseScopes(strings.Fields(req.Scope), owner); err != nil {
+			return nil, authorizeError(redirectUri, "invalid_scope", req.State), nil
+		}
+		for _, scope := range scopes {
+			if !slices.Contains(oauthClient.Scopes, scope) {
+				s.lg.Error("scope is not allowed", slog.String("owner", owner), slog.String("clientId", req.ClientId), slog.String("scope", scope))
+				return nil, authorizeError(redirectUri, "invalid_scope", req.State), nil
+			}
+		}
+	}
+	request := &authorizeRequest{client: oauthClient, redirectUri: redirectUri, redirectUriSent: req.RedirectUri != "", scopes: scopes}
+	if req.CodeChallenge != "" {
+		// the plain method is not supported, it protects nothing once the request leaks
+		if req.CodeChallengeMethod != codeChallengeMethodS256 || !codeVerifierRegexp.MatchString(req.CodeChallenge) {
+			s.lg.Error("invalid code challenge", slog.String("owner", owner), slog.String("clientId", req.ClientId), slog.String("method", req.CodeChallengeMethod))
+			return nil, authorizeError(redirectUri, "invalid_request", req.State), nil
+		}
+		request.codeChallenge = &req.CodeChallenge
+	} else if oauthClient.ClientType == entity.OAuthClientTypePublic {
+		s.lg.Error("code challenge is required", slog.String("owner", owner), slog.String("clientId", req.ClientId))
+		return nil, authorizeError(redirectUri, "invalid_request", req.State), nil
+	}
+	if req.Nonce != "" {
+		if len(req.Nonce) > maxNonceLength {
+			s.lg.Error("invalid nonce", slog.String("owner", owner), slog.String("clientId", req.ClientId))
+			return nil, authorizeError(redirectUri, "invalid_request", req.State), nil
+		}
+		request.nonce = &req.Nonce
+	}
+	return request, nil, nil
+}
+
+// CheckAuthorize checks the authorization request before the login page is shown
+func (s *Service) CheckAuthorize(ctx context.Context, req *svcDto.AuthorizeRequest) (*svcDto.AuthorizeResponse, error) {
+	request, resp, err := s.checkAuthorizeRequest(req, "service.CheckAuthorize")
+	if err != nil || resp != nil {
+		return resp, err
+	}
+	return &svcDto.AuthorizeResponse{ClientName: request.client.Name}, nil
+}
+
+// Authorize authenticates the user of the login page the same way as Login does and redirects to the client
+// with the authorization code. The user with mfa gets the mfa token and repeats the call with the code
+func (s *Service) Authorize(ctx context.Context, req *svcDto.AuthorizeRequest) (*svcDto.AuthorizeResponse, error) {
+	request, resp, err := s.checkAuthorizeRequest(req, "service.Authorize")
+	if err != nil || resp != nil {
+		return resp, err
+	}
+	deviceCode := oauthDeviceCode(request.client.ClientId)
+	var user *entity.User
+	acr := acrPassword
+	if req.MfaToken != "" {
+		var claims *jwt.TokenClaims
+		if user, claims, err = s.checkLoginMfa(ctx, req.MfaToken, req.MfaCode, "service.Authorize"); err != nil {
+			return nil, err
+		}
+		if claims.DeviceCode != deviceCode {
+			s.lg.Error("mfa token of another client", slog.String("owner", "service.Authorize"), slog.String("clientId", req.ClientId))
+			return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidMfaToken.Error())
+		}
+		acr = acrMfa
+	} else {
+		tenant, err := s.getTenant(request.client.TenantId)
+		if err != nil {
+			return nil, err
+		}
+		if user, err = s.checkLoginPassword(ctx, tenant, req.Login, req.Password, "service.Authorize"); err != nil {
+			return nil, err
+		}
+		userMfa, err := s.getConfirmedUserMfa(user.UserId)
+		if err != nil {
+			return nil, err
+		}
+		if userMfa != nil {
+			mfaToken, err := s.createMfaToken(user.UserId, deviceCode, "service.Authorize")
+			if err != nil {
+				return nil, err
+			}
+			return &svcDto.AuthorizeResponse{ClientName: request.client.Name, MfaToken: mfaToken}, nil
+		}
+		s.resetLoginFailures(tenantLogin(tenant.TenantId, req.Login))
+	}
+	code, err := secure.GenerateToken(authorizationCodeSize)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.Authorize"))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	now := time.Now()
+	if err := s.store.AddOAuthAuthorizationCode(&repoDto.AddOAuthAuthorizationCode{
+		CodeHash:        secure.GetHash(code),
+		OAuthClientId:   request.client.OAuthClientId,
+		UserId:          user.UserId,
+		RedirectUri:     request.redirectUri,
+		RedirectUriSent: request.redirectUriSent,
+		Scopes:          request.scopes,
+		CodeChallenge:   request.codeChallenge,
+		Nonce:           request.nonce,
+		Acr:             acr,
+		AuthTime:        now,
+		ExpirationAt:    now.Add(s.oauth.CodeLifetime),
+	}); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "oauth.authorize", slog.Any("userId", user.UserId), slog.String("clientId", request.client.ClientId), slog.Any("scopes", request.scopes))
+	return &svcDto.AuthorizeResponse{RedirectUri: authorizeRedirect(request.redirectUri, url.Values{"code": {code}, "state": {req.State}})}, nil
+}
+
+// authenticateOAuthClient checks the secret of the confidential client, the public client is identified only
+func (s *Service) authenticateOAuthClient(ctx context.Context, clientId string, clientSecret string) (*entity.OAuthClient, error) {
+	oauthClient, err := s.store.GetOAuthClientByClientId(clientId)
+	if err != nil && !errors.Is(err, repoErr.ErrRecordNotFound) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err != nil || (oauthClient.ClientType == entity.OAuthClientTypeConfidential &&
+		(oauthClient.SecretHash == nil || !secure.CheckHash(clientSecret, *oauthClient.SecretHash))) {
+		s.lg.Error("oauth client verification error", slog.String("owner", "service.OAuthToken"), slog.String("clientId", clientId))
+		s.audit(ctx, "oauth.token.failure", slog.String("clientId", clientId))
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidClient.Error())
+	}
+	return oauthClient, nil
+}
+
+// OAuthToken is the token endpoint of the authorization_code and refresh_token grants
+func (s *Service) OAuthToken(ctx context.Context, req *svcDto.OAuthTokenRequest) (*svcDto.OAuthTokenResponse, error) {
+	oauthClient, err := s.authenticateOAuthClient(ctx, req.ClientId, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	switch req.GrantType {
+	case grantTypeAuthorizationCode:
+		return s.authorizationCodeGrant(ctx, oauthClient, req)
+	case grantTypeRefreshToken:
+		return s.refreshTokenGrant(ctx, oauthClient, req)
+	}
+	s.lg.Error("unsupported grant type", slog.String("owner", "service.OAuthToken"), slog.String("grantType", req.GrantType))
+	return nil, status.Error(codes.InvalidArgument, svcErr.ErrUnsupportedGrantType.Error())
+}
+
+// authorizationCodeGrant exchanges the code for the tokens of a new session of the client
+func (s *Service) authorizationCodeGrant(ctx context.Context, oauthClient *entity.OAuthClient, req *svcDto.OAuthTokenRequest) (*svcDto.OAuthTokenResponse, error) {
+	if req.Code == "" {
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidGrant.Error())
+	}
+	code, err := s.store.TakeOAuthAuthorizationCode(secure.GetHash(req.Code))
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.audit(ctx, "oauth.token.failure", slog.String("clientId", oauthClient.ClientId))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidGrant.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !validAuthorizationCode(code, oauthClient, req.RedirectUri, req.CodeVerifier, time.Now()) {
+		s.lg.Error("authorization code verification error", slog.String("owner", "service.OAuthToken"), slog.String("clientId", oauthClient.ClientId))
+		s.audit(ctx, "oauth.token.failure", slog.String("clientId", oauthClient.ClientId), slog.Any("userId", code.UserId))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidGrant.Error())
+	}
+	user, err := s.store.GetUser(code.UserId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidGrant.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.checkUserStatus(user, "service.OAuthToken"); err != nil {
+		return nil, err
+	}
+	deviceCode := oauthDeviceCode(oauthClient.ClientId)
+	sessionId := uuid.New()
+	accessTokenString, refreshTokenString, err := s.createTokens(ctx, user, deviceCode, &session{
+		sessionId: &sessionId,
+		issuedAt:  code.AuthTime,
+		client:    getClientInfo(ctx),
+		clientId:  oauthClient.ClientId,
+		scopes:    code.Scopes,
+		newDevice: true,
+	}, "service.OAuthToken")
+	if err != nil {
+		return nil, err
+	}
+	var idTokenString string
+	if slices.Contains(code.Scopes, scopeOpenId) {
+		if idTokenString, err = s.createIdToken(user, oauthClient, code, accessTokenString, "service.OAuthToken"); err != nil {
+			return nil, err
+		}
+	}
+	s.audit(ctx, "oauth.token", slog.Any("userId", user.UserId), slog.String("clientId", oauthClient.ClientId), slog.Any("sessionId", sessionId))
+	return s.oauthTokenResponse(accessTokenString, refreshTokenString, idTokenString, code.Scopes)
+}
+
+// refreshTokenGrant rotates the refresh token of the client, the new tokens keep the granted scopes
+func (s *Service) refreshTokenGrant(ctx context.Context, oauthClient *entity.OAuthClient, req *svcDto.OAuthTokenRequest) (*svcDto.OAuthTokenResponse, error) {
+	claims, err := s.ParseToken(req.RefreshToken)
+	if err != nil || claims.TokenType != "refresh" || claims.Jti == nil {
+		s.lg.Error("refresh token verification error", slog.String("owner", "service.OAuthToken"), slog.String("clientId", oauthClient.ClientId))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidGrant.Error())
+	}
+	deviceCode := oauthDeviceCode(oauthClient.ClientId)
+	accessTokenString, refreshTokenString, refreshToken, err := s.rotateRefreshToken(ctx, claims.Jti, &deviceCode, "service.OAuthToken")
+	if err != nil {
+		if code := status.Code(err); code == codes.NotFound || code == codes.Unauthenticated {
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidGrant.Error())
+		}
+		return nil, err
+	}
+	// the ID token is issued by the code exchange only, the client keeps it for the session
+	return s.oauthTokenResponse(accessTokenString, refreshTokenString, "", refreshToken.Scopes)
+}
+func (s *Service) oauthTokenResponse(accessTokenString string, refreshTokenString string, idTokenString string, scopes []string) (*svcDto.OAuthTokenResponse, error) {
+	claims, err := s.ParseToken(accessTokenString)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.OAuthToken"))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &svcDto.OAuthTokenResponse{
+		AccessToken:  accessTokenString,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(claims.ExpiresAt.Time).Round(time.Second).Seconds()),
+		RefreshToken: refreshTokenString,
+		Scope:        strings.Join(scopes, " "),
+		IdToken:      idTokenString,
+	}, nil
+}
+
+// RemoveExpiredOAuthCodes removes the authorization codes that were not exchanged in time
+func (s *Service) RemoveExpiredOAuthCodes() (int64, error) {
+	return s.store.RemoveOAuthAuthorizationCodesByExpirationAt(time.Now())
+}
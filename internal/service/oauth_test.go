@@ -0,0 +1,110 @@
+package service
+
+import (
+	"ppAuthService/internal/entity"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the verifier and the challenge of RFC 7636 appendix B
+const (
+	rfcCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	rfcCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	tests := []struct {
+		name          string
+		codeVerifier  string
+		codeChallenge string
+		want          bool
+	}{
+		{"rfc 7636 vector", rfcCodeVerifier, rfcCodeChallenge, true},
+		{"other verifier", "eBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", rfcCodeChallenge, false},
+		{"plain method", rfcCodeVerifier, rfcCodeVerifier, false},
+		{"short verifier", "abc", "ungWv48Bz-pBQUDeXa4iI7ADYaOWF3qctBD_YfIAFa0", false},
+		{"verifier with invalid characters", "dBjftJeZ4CVP+mB92K27uhbUJU1p1r/wW1gFWFOEjXk", rfcCodeChallenge, false},
+		{"empty verifier", "", rfcCodeChallenge, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeChallenge(tt.codeVerifier, tt.codeChallenge); got != tt.want {
+				t.Errorf("verifyCodeChallenge(%q, %q) = %v, want %v", tt.codeVerifier, tt.codeChallenge, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidRedirectUri(t *testing.T) {
+	tests := []struct {
+		redirectUri string
+		want        bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"com.example.app:/callback", true},
+		{"http://localhost:8080/callback", true},
+		{"http://127.0.0.1/callback", true},
+		{"http://[::1]:8080/callback", true},
+		{"http://app.example.com/callback", false},
+		{"http://localhost.example.com/callback", false},
+		{"https://app.example.com/callback#fragment", false},
+		{"/callback", false},
+		{"mailto:user@example.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.redirectUri, func(t *testing.T) {
+			if got := validRedirectUri(tt.redirectUri); got != tt.want {
+				t.Errorf("validRedirectUri(%q) = %v, want %v", tt.redirectUri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidAuthorizationCode(t *testing.T) {
+	now := time.Now()
+	oauthClientId, otherClientId := uuid.New(), uuid.New()
+	oauthClient := &entity.OAuthClient{OAuthClientId: &oauthClientId}
+	codeChallenge := rfcCodeChallenge
+	newCode := func(redirectUriSent bool, codeChallenge *string) *entity.OAuthAuthorizationCode {
+		return &entity.OAuthAuthorizationCode{
+			OAuthClientId:   &oauthClientId,
+			RedirectUri:     "https://app.example.com/callback",
+			RedirectUriSent: redirectUriSent,
+			CodeChallenge:   codeChallenge,
+			ExpirationAt:    now.Add(time.Minute),
+		}
+	}
+	expired := newCode(true, &codeChallenge)
+	expired.ExpirationAt = now.Add(-time.Second)
+	foreign := newCode(true, &codeChallenge)
+	foreign.OAuthClientId = &otherClientId
+	tests := []struct {
+		name         string
+		code         *entity.OAuthAuthorizationCode
+		redirectUri  string
+		codeVerifier string
+		want         bool
+	}{
+		{"pkce", newCode(true, &codeChallenge), "https://app.example.com/callback", rfcCodeVerifier, true},
+		{"wrong verifier", newCode(true, &codeChallenge), "https://app.example.com/callback", "eBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", false},
+		{"missing verifier", newCode(true, &codeChallenge), "https://app.example.com/callback", "", false},
+		{"sent redirect uri differs", newCode(true, &codeChallenge), "https://app.example.com/other", rfcCodeVerifier, false},
+		{"sent redirect uri omitted", newCode(true, &codeChallenge), "", rfcCodeVerifier, false},
+		{"omitted redirect uri not sent", newCode(false, &codeChallenge), "", rfcCodeVerifier, true},
+		{"omitted redirect uri sent anyway", newCode(false, &codeChallenge), "https://app.example.com/other", rfcCodeVerifier, true},
+		{"no challenge", newCode(true, nil), "https://app.example.com/callback", "", true},
+		{"verifier for a code without a challenge", newCode(true, nil), "https://app.example.com/callback", rfcCodeVerifier, false},
+		{"expired", expired, "https://app.example.com/callback", rfcCodeVerifier, false},
+		{"code of another client", foreign, "https://app.example.com/callback", rfcCodeVerifier, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validAuthorizationCode(tt.code, oauthClient, tt.redirectUri, tt.codeVerifier, now); got != tt.want {
+				t.Errorf("validAuthorizationCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
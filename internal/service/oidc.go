@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/jwt"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	scopeOpenId  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+	// authentication context classes: the password only, the password and the second factor
+	acrPassword = "1"
+	acrMfa      = "2"
+	// the longest nonce of the authorization request
+	maxNonceLength = 512
+)
+
+// OpenIdConfiguration returns the discovery document, the endpoints are the routes of the gateway under the issuer
+func (s *Service) OpenIdConfiguration() *svcDto.OpenIdConfiguration {
+	issuer := strings.TrimSuffix(s.oauth.Issuer, "/")
+	return &svcDto.OpenIdConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JwksUri:                           issuer + "/oauth/jwks.json",
+		ScopesSupported:                   []string{scopeOpenId, scopeProfile, scopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{grantTypeAuthorizationCode, grantTypeRefreshToken},
+		SubjectTypesSupported:             []string{"public"},
+		IdTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{codeChallengeMethodS256},
+		AcrValuesSupported:                []string{acrPassword, acrMfa},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "acr", "amr", "azp", "preferred_username", "tenant", "email", "email_verified"},
+	}
+}
+
+// OpenIdJwks returns the key of the service, which signs the ID tokens of all the tenants, and the own keys of the
+// tenants, which sign their access tokens. The clients have one issuer and one key set, the kid picks the key
+func (s *Service) OpenIdJwks() ([]*jwt.Jwk, error) {
+	storedKeys, err := s.store.GetTenantSigningKeys()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	jwks := make([]*jwt.Jwk, 0, len(storedKeys)+1)
+	jwks = append(jwks, jwt.NewJwk(s.signingKey.Id, &s.signingKey.PrivateKey.PublicKey))
+	for _, storedKey := range storedKeys {
+		key, err := s.decryptTenantSigningKey(storedKey)
+		if err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", "service.OpenIdJwks"), slog.String("keyId", storedKey.KeyId))
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		jwks = append(jwks, jwt.NewJwk(key.signingKey.Id, &key.signingKey.PrivateKey.PublicKey))
+	}
+	return jwks, nil
+}
+
+// createIdToken creates the ID token of the code exchange for the client, at_hash binds it to the access token
+func (s *Service) createIdToken(user *entity.User, oauthClient *entity.OAuthClient, code *entity.OAuthAuthorizationCode, accessTokenString string, owner string) (string, error) {
+	amr := []string{"pwd"}
+	if code.Acr == acrMfa {
+		amr = append(amr, "otp", "mfa")
+	}
+	idTokenClaims := &jwt.IdTokenClaims{
+		AtHash: jwt.AtHash(accessTokenString),
+		Acr:    code.Acr,
+		Amr:    amr,
+		Azp:    oauthClient.ClientId,
+	}
+	idTokenClaims.Issuer = strings.TrimSuffix(s.oauth.Issuer, "/")
+	idTokenClaims.Subject = user.UserId.String()
+	idTokenClaims.Audience = []string{oauthClient.ClientId}
+	if code.Nonce != nil {
+		idTokenClaims.Nonce = *code.Nonce
+	}
+	idTokenString, err := jwt.CreateIdToken(idTokenClaims, code.AuthTime, s.oauth.IdTokenLifetime, s.signingKey)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	return idTokenString, nil
+}
+
+// UserInfo returns the claims of the user of the access token, the token must have the openid scope. The
+// profile scope adds the login and the tenant, the email scope adds the email
+func (s *Service) UserInfo(ctx context.Context, req *svcDto.UserInfoRequest) (*svcDto.UserInfoResponse, error) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrAccessTokenRequired.Error())
+	}
+	scopes := strings.Fields(claims.Scope)
+	if claims.SubjectType != "" || !slices.Contains(scopes, scopeOpenId) {
+		s.lg.Error("openid scope is required", slog.String("owner", "service.UserInfo"), slog.Any("sub", claims.Sub))
+		return nil, status.Error(codes.PermissionDenied, svcErr.ErrInsufficientScope.Error())
+	}
+	user, err := s.store.GetUser(claims.Sub)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidAccessToken.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.checkUserStatus(user, "service.UserInfo"); err != nil {
+		return nil, err
+	}
+	resp := &svcDto.UserInfoResponse{Sub: user.UserId.String()}
+	if slices.Contains(scopes, scopeProfile) {
+		tenant, err := s.getTenant(user.TenantId)
+		if err != nil {
+			return nil, err
+		}
+		resp.PreferredUsername = user.Login
+		resp.Tenant = tenant.Name
+	}
+	if slices.Contains(scopes, scopeEmail) && user.Email != nil {
+		emailVerified := user.EmailVerifiedAt != nil
+		resp.Email = *user.Email
+		resp.EmailVerified = &emailVerified
+	}
+	return resp, nil
+}
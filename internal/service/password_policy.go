@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/secure"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkPassword validates the new password against the password policy of the tenant and, for an existing user,
+// against the previous passwords. All the failed rules are returned at once as the field violations of the field
+func (s *Service) checkPassword(field string, newPassword string, login string, user *entity.User, tenant *entity.Tenant, owner string) error {
+	violations := s.tenantPasswordPolicy(tenant).Validate(newPassword, login)
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: violation.Description,
+			Reason:      violation.Rule,
+		})
+	}
+	if s.breachIndex != nil {
+		count, err := s.breachIndex.Lookup(newPassword)
+		if err != nil {
+			// the index is an additional check, the password is not refused because of it
+			s.lg.Error(err.Error(), slog.String("owner", owner))
+		} else if count >= s.breachMinCount && count > 0 {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Description: fmt.Sprintf("password appeared %d times in known data breaches", count),
+				Reason:      "breached",
+			})
+		}
+	}
+	if user != nil && s.passwordHistoryDepth > 0 {
+		reused, err := s.isPasswordReused(user, newPassword)
+		if err != nil {
+			return err
+		}
+		if reused {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Description: "password must differ from the previous passwords",
+				Reason:      "history",
+			})
+		}
+	}
+	if len(badRequest.FieldViolations) == 0 {
+		return nil
+	}
+	s.lg.Error("password policy violation", slog.String("owner", owner), slog.Int("violations", len(badRequest.FieldViolations)))
+	st, err := status.New(codes.InvalidArgument, svcErr.ErrPasswordPolicy.Error()).WithDetails(badRequest)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, svcErr.ErrPasswordPolicy.Error())
+	}
+	return st.Err()
+}
+
+// isPasswordReused compares the password with the current one and the ones in the history
+func (s *Service) isPasswordReused(user *entity.User, newPassword string) (bool, error) {
+	if secure.CheckHash(newPassword, user.Password) {
+		return true, nil
+	}
+	passwords, err := s.store.GetPasswordHistory(user.UserId, s.passwordHistoryDepth)
+	if err != nil {
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	for _, passwordHash := range passwords {
+		if secure.CheckHash(newPassword, passwordHash) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addPasswordHistory remembers the new password of the user. The password is already set, so the failure is only logged
+func (s *Service) addPasswordHistory(userId *uuid.UUID, passwordHash string) {
+	if s.passwordHistoryDepth <= 0 {
+		return
+	}
+	if err := s.store.AddPasswordHistory(&repoDto.AddPasswordHistory{
+		UserId:   userId,
+		Password: passwordHash,
+		Depth:    s.passwordHistoryDepth,
+	}); err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.addPasswordHistory"), slog.Any("userId", userId))
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	"ppAuthService/internal/notifier"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/secure"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// size of the reset token in bytes before encoding
+const passwordResetTokenSize = 32
+
+// RequestPasswordReset answers the same way whether the login exists or not, so it can not be used to enumerate accounts
+func (s *Service) RequestPasswordReset(ctx context.Context, req *svcDto.RequestPasswordResetRequest) (*svcDto.RequestPasswordResetResponse, error) {
+	tenant, err := s.requestTenant(ctx, "service.RequestPasswordReset")
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.store.GetUserByLogin(tenant.TenantId, req.Login)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.audit(ctx, "password.reset.request", slog.String("login", req.Login), slog.Bool("userFound", false))
+			return &svcDto.RequestPasswordResetResponse{}, nil
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// a deleted user can not be restored by a reset, so it is answered like a missing login
+	if user.Status == entity.UserStatusDeleted {
+		s.audit(ctx, "password.reset.request", slog.String("login", req.Login), slog.Bool("userFound", false))
+		return &svcDto.RequestPasswordResetResponse{}, nil
+	}
+	if err := s.sendPasswordReset(user, "service.RequestPasswordReset"); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, "password.reset.request", slog.String("login", req.Login), slog.Bool("userFound", true), slog.Any("userId", user.UserId))
+	return &svcDto.RequestPasswordResetResponse{}, nil
+}
+
+// sendPasswordReset creates the reset token and passes it to the notifier
+func (s *Service) sendPasswordReset(user *entity.User, owner string) error {
+	token, err := secure.GenerateToken(passwordResetTokenSize)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return status.Error(codes.Internal, err.Error())
+	}
+	expirationAt := time.Now().Add(s.passwordReset.TokenLifetime)
+	if err := s.store.AddPasswordResetToken(&repoDto.AddPasswordResetToken{
+		UserId:       user.UserId,
+		TokenHash:    secure.GetHash(token),
+		ExpirationAt: expirationAt,
+	}); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	// the delivery does not hold the response, otherwise the response time would tell that the account exists
+	go func() {
+		if err := s.notifier.Notify(context.Background(), &notifier.Notification{
+			Type:         notifier.TypePasswordReset,
+			UserId:       user.UserId,
+			Login:        user.Login,
+			Email:        verifiedEmail(user),
+			Token:        token,
+			ExpirationAt: expirationAt,
+		}); err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", owner))
+		}
+	}()
+	return nil
+}
+
+// ConfirmPasswordReset sets the new password, logs the user out everywhere and invalidates the other reset tokens
+func (s *Service) ConfirmPasswordReset(ctx context.Context, req *svcDto.ConfirmPasswordResetRequest) (*svcDto.ConfirmPasswordResetResponse, error) {
+	if req.Token == "" {
+		s.lg.Error("invalid password reset token value", slog.String("owner", "service.ConfirmPasswordReset"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidResetToken.Error())
+	}
+	tokenHash := secure.GetHash(req.Token)
+	resetToken, err := s.store.GetPasswordResetToken(tokenHash, time.Now())
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.audit(ctx, "password.reset.failure")
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidResetToken.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	user, err := s.store.GetUser(resetToken.UserId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// a reset does not bring back a user that can not log in
+	if err := s.checkUserStatus(user, "service.ConfirmPasswordReset"); err != nil {
+		s.audit(ctx, "password.reset.failure", slog.Any("userId", user.UserId), slog.String("status", user.Status))
+		return nil, err
+	}
+	// the token is consumed only by a password that passes the policy
+	tenant, err := s.getTenant(user.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPassword("newPassword", req.NewPassword, user.Login, user, tenant, "service.ConfirmPasswordReset"); err != nil {
+		return nil, err
+	}
+	// the token may be used concurrently, only one of the calls consumes it and sets the password
+	hashNewPassword := secure.GetHash(req.NewPassword)
+	if err := s.store.ResetPasswordByToken(&repoDto.ResetPasswordByToken{
+		TokenHash: tokenHash,
+		UserId:    resetToken.UserId,
+		Password:  hashNewPassword,
+		Now:       time.Now(),
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.audit(ctx, "password.reset.failure")
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidResetToken.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.addPasswordHistory(resetToken.UserId, hashNewPassword)
+	if err := s.store.RevokeRefreshTokensByUserIdAndDeviceCode(&repoDto.RevokeRefreshTokensByUserIdAndDeviceCode{
+		UserId:     resetToken.UserId,
+		DeviceCode: nil,
+	}); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.store.RemovePasswordResetTokensByUserId(resetToken.UserId); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "password.reset", slog.Any("userId", resetToken.UserId))
+	return &svcDto.ConfirmPasswordResetResponse{}, nil
+}
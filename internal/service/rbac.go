@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/condition"
+	"regexp"
+	"slices"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the role and permission names go to the claims of the tokens, so they are short and printable, e.g. users:read
+var rbacNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,63}$`)
+
+// userAuthorization returns the roles and the permissions of the user for the access token. They are bounded,
+// so that the size of the token stays predictable; the cut is reported by the truncated flag.
+// Conditional grants depend on the resource and are left to CheckPermission
+func (s *Service) userAuthorization(userId *uuid.UUID) ([]string, []string, bool, error) {
+	roles, err := s.store.GetRolesByUserId(userId)
+	if err != nil {
+		return nil, nil, false, status.Error(codes.Internal, err.Error())
+	}
+	grants, err := s.store.GetGrantsByUserId(userId)
+	if err != nil {
+		return nil, nil, false, status.Error(codes.Internal, err.Error())
+	}
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+	var permissions []string
+	for _, grant := range grants {
+		if grant.Condition == "" {
+			permissions = append(permissions, grant.Permission)
+		}
+	}
+	slices.Sort(permissions)
+	permissions = slices.Compact(permissions)
+	truncated := false
+	if len(roleNames) > s.token.MaxRolesClaim {
+		roleNames = roleNames[:s.token.MaxRolesClaim]
+		truncated = true
+	}
+	if len(permissions) > s.token.MaxPermissionsClaim {
+		permissions = permissions[:s.token.MaxPermissionsClaim]
+		truncated = true
+	}
+	if truncated {
+		s.lg.Warn("roles and permissions do not fit into the token", slog.String("owner", "service.userAuthorization"), slog.Any("userId", userId), slog.Int("roles", len(roles)))
+	}
+	return roleNames, permissions, truncated, nil
+}
+
+func toRoleDto(role *entity.Role) *svcDto.Role {
+	return &svcDto.Role{
+		Name:        role.Name,
+		Description: role.Description,
+		MaxDevices:  role.MaxDevices,
+		Permissions: role.Permissions,
+		CreatedAt:   role.CreatedAt,
+	}
+}
+
+func (s *Service) CreateRole(ctx context.Context, req *svcDto.CreateRoleRequest) (*svcDto.CreateRoleResponse, error) {
+	if !rbacNameRegexp.MatchString(req.Name) {
+		s.lg.Error("invalid role name value", slog.String("owner", "service.CreateRole"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentRole.Error())
+	}
+	if req.MaxDevices != nil && *req.MaxDevices < 0 {
+		s.lg.Error("invalid max devices value", slog.String("owner", "service.CreateRole"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentMaxDevices.Error())
+	}
+	roleId, err := s.store.AddRole(&repoDto.AddRole{
+		TenantId:    adminTenantId(ctx),
+		Name:        req.Name,
+		Description: req.Description,
+		MaxDevices:  req.MaxDevices,
+	})
+	if err != nil {
+		if errors.Is(err, repoErr.ErrUniqueViolation) {
+			return nil, status.Error(codes.AlreadyExists, svcErr.ErrRoleAlreadyExists.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "role.create", slog.String("role", req.Name))
+	return &svcDto.CreateRoleResponse{RoleId: roleId.String()}, nil
+}
+func (s *Service) DeleteRole(ctx context.Context, req *svcDto.DeleteRoleRequest) (*svcDto.DeleteRoleResponse, error) {
+	if err := s.store.RemoveRole(adminTenantId(ctx), req.Name); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrRoleNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.decisionCache.invalidate()
+	s.audit(ctx, "role.delete", slog.String("role", req.Name))
+	return &svcDto.DeleteRoleResponse{}, nil
+}
+func (s *Service) ListRoles(ctx context.Context, req *svcDto.ListRolesRequest) (*svcDto.ListRolesResponse, error) {
+	roles, err := s.store.GetRoles(adminTenantId(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.ListRolesResponse{Roles: make([]*svcDto.Role, 0, len(roles))}
+	for _, role := range roles {
+		resp.Roles = append(resp.Roles, toRoleDto(role))
+	}
+	return resp, nil
+}
+func (s *Service) CreatePermission(ctx context.Context, req *svcDto.CreatePermissionRequest) (*svcDto.CreatePermissionResponse, error) {
+	if !rbacNameRegexp.MatchString(req.Name) {
+		s.lg.Error("invalid permission name value", slog.String("owner", "service.CreatePermission"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentPermission.Error())
+	}
+	permissionId, err := s.store.AddPermission(&repoDto.AddPermission{
+		TenantId:    adminTenantId(ctx),
+		Name:        req.Name,
+		Description: req.Description,
+	})
+	if err != nil {
+		if errors.Is(err, repoErr.ErrUniqueViolation) {
+			return nil, status.Error(codes.AlreadyExists, svcErr.ErrPermissionAlreadyExists.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "permission.create", slog.String("permission", req.Name))
+	return &svcDto.CreatePermissionResponse{PermissionId: permissionId.String()}, nil
+}
+func (s *Service) DeletePermission(ctx context.Context, req *svcDto.DeletePermissionRequest) (*svcDto.DeletePermissionResponse, error) {
+	if err := s.store.RemovePermission(adminTenantId(ctx), req.Name); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrPermissionNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.decisionCache.invalidate()
+	s.audit(ctx, "permission.delete", slog.String("permission", req.Name))
+	return &svcDto.DeletePermissionResponse{}, nil
+}
+func (s *Service) ListPermissions(ctx context.Context, req *svcDto.ListPermissionsRequest) (*svcDto.ListPermissionsResponse, error) {
+	permissions, err := s.store.GetPermissions(adminTenantId(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.ListPermissionsResponse{Permissions: make([]*svcDto.Permission, 0, len(permissions))}
+	for _, permission := range permissions {
+		resp.Permissions = append(resp.Permissions, &svcDto.Permission{
+			Name:        permission.Name,
+			Description: permission.Description,
+			CreatedAt:   permission.CreatedAt,
+		})
+	}
+	return resp, nil
+}
+func (s *Service) GrantPermission(ctx context.Context, req *svcDto.GrantPermissionRequest) (*svcDto.GrantPermissionResponse, error) {
+	cond, err := condition.Parse(req.Condition)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.GrantPermission"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentCondition.Error())
+	}
+	if err := s.store.AddRolePermission(&repoDto.RolePermission{
+		TenantId:   adminTenantId(ctx),
+		Role:       req.Role,
+		Permission: req.Permission,
+		Condition:  cond.String(),
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrRoleOrPermissionNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.decisionCache.invalidate()
+	s.audit(ctx, "permission.grant", slog.String("role", req.Role), slog.String("permission", req.Permission), slog.String("condition", cond.String()))
+	return &svcDto.GrantPermissionResponse{}, nil
+}
+func (s *Service) RevokePermission(ctx context.Context, req *svcDto.RevokePermissionRequest) (*svcDto.RevokePermissionResponse, error) {
+	if err := s.store.RemoveRolePermission(&repoDto.RolePermission{
+		TenantId:   adminTenantId(ctx),
+		Role:       req.Role,
+		Permission: req.Permission,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrRoleOrPermissionNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.decisionCache.invalidate()
+	s.audit(ctx, "permission.revoke", slog.String("role", req.Role), slog.String("permission", req.Permission))
+	return &svcDto.RevokePermissionResponse{}, nil
+}
+func (s *Service) AssignRole(ctx context.Context, req *svcDto.AssignRoleRequest) (*svcDto.AssignRoleResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.AssignRole"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	if err := s.store.AddUserRole(&repoDto.UserRole{
+		UserId: &userId,
+		Role:   req.Role,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserOrRoleNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.decisionCache.invalidateUser(userId)
+	s.audit(ctx, "role.assign", slog.Any("userId", userId), slog.String("role", req.Role))
+	return &svcDto.AssignRoleResponse{}, nil
+}
+func (s *Service) UnassignRole(ctx context.Context, req *svcDto.UnassignRoleRequest) (*svcDto.UnassignRoleResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.UnassignRole"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	if err := s.store.RemoveUserRole(&repoDto.UserRole{
+		UserId: &userId,
+		Role:   req.Role,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserOrRoleNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.decisionCache.invalidateUser(userId)
+	s.audit(ctx, "role.unassign", slog.Any("userId", userId), slog.String("role", req.Role))
+	return &svcDto.UnassignRoleResponse{}, nil
+}
+func (s *Service) ListUserRoles(ctx context.Context, req *svcDto.ListUserRolesRequest) (*svcDto.ListUserRolesResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ListUserRoles"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	roles, err := s.store.GetRolesByUserId(&userId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.ListUserRolesResponse{Roles: make([]*svcDto.Role, 0, len(roles))}
+	for _, role := range roles {
+		resp.Roles = append(resp.Roles, toRoleDto(role))
+	}
+	return resp, nil
+}
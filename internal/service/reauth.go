@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/secure"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the current password is passed in the metadata, the proto requests have no field for it
+const currentPasswordKey = "x-current-password"
+
+// checkReauth requires the caller to prove being the user: either the current password in the metadata,
+// or a first-party bearer access token of the user issued by a login not older than the reauth window.
+// Wrong passwords count toward the brute-force limits of the login
+func (s *Service) checkReauth(ctx context.Context, user *entity.User, owner string) error {
+	if currentPassword := metadataValue(ctx, currentPasswordKey); currentPassword != "" {
+		ip := clientIp(ctx)
+		if err := s.checkLoginAttempts(ctx, tenantLogin(user.TenantId, user.Login), ip); err != nil {
+			return err
+		}
+		if !secure.CheckHash(currentPassword, user.Password) {
+			s.lg.Error("hash verification error", slog.String("owner", owner))
+			s.addLoginFailure(ctx, tenantLogin(user.TenantId, user.Login), ip)
+			return status.Error(codes.Unauthenticated, svcErr.ErrInvalidCurrentPassword.Error())
+		}
+		s.resetLoginFailures(tenantLogin(user.TenantId, user.Login))
+		return nil
+	}
+	// the token is verified by the authentication interceptor
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		if *claims.Sub != *user.UserId || claims.IsDelegated() {
+			s.lg.Error("access token verification error", slog.String("owner", owner))
+			return status.Error(codes.Unauthenticated, svcErr.ErrReauthRequired.Error())
+		}
+		if claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > s.reauthWindow {
+			s.lg.Warn("authentication is not recent", slog.String("owner", owner), slog.Any("userId", user.UserId))
+			return status.Error(codes.Unauthenticated, svcErr.ErrReauthRequired.Error())
+		}
+		return nil
+	}
+	return status.Error(codes.Unauthenticated, svcErr.ErrReauthRequired.Error())
+}
@@ -0,0 +1,431 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"log/slog"
+	repoDto "ppAuthService/internal/repository/dto"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/rebac"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// the consistency token carries the revision of the tuples, it is opaque for the clients
+func encodeZookie(revision int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(revision, 10)))
+}
+func decodeZookie(zookie string) (int64, bool) {
+	value, err := base64.RawURLEncoding.DecodeString(zookie)
+	if err != nil {
+		return 0, false
+	}
+	revision, err := strconv.ParseInt(string(value), 10, 64)
+	return revision, err == nil && revision >= 0
+}
+
+type cachedCheck struct {
+	allowed bool
+	// the check saw the changes up to the revision
+	revision  int64
+	expiresAt time.Time
+}
+
+// checkCache keeps the relation checks of the instance. The writes of the instance drop it, the writes of other
+// instances are seen after the ttl, or at once by the checks with a newer consistency token
+type checkCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*cachedCheck
+}
+
+func (c *checkCache) get(key string, minRevision int64) (*cachedCheck, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.revision < minRevision || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry, true
+}
+func (c *checkCache) set(key string, allowed bool, revision int64) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= c.size {
+		c.entries = make(map[string]*cachedCheck)
+	}
+	c.entries[key] = &cachedCheck{allowed: allowed, revision: revision, expiresAt: time.Now().Add(c.ttl)}
+}
+func (c *checkCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+}
+
+// relationWalk is the state of one check or expansion within the tuples of the tenant. The check visits each
+// object#relation once, the expansion once along the current path, so cycles of usersets end instead of failing
+// on the depth. The reads of the tuples are counted, so that a wide graph is bounded as well as a deep one
+type relationWalk struct {
+	tenantId *uuid.UUID
+	visited  map[string]struct{}
+	lookups  int
+}
+
+func (w *relationWalk) visit(object rebac.Object, relation string) bool {
+	key := object.String() + "#" + relation
+	if _, ok := w.visited[key]; ok {
+		return false
+	}
+	w.visited[key] = struct{}{}
+	return true
+}
+
+// leave takes the object#relation off the current path of the expansion, so that another path may reach it again
+func (w *relationWalk) leave(object rebac.Object, relation string) {
+	delete(w.visited, object.String()+"#"+relation)
+}
+
+func (s *Service) relation(object rebac.Object, relation string) *rebac.Relation {
+	namespace, ok := s.namespaces[object.Namespace]
+	if !ok {
+		return nil
+	}
+	return namespace.Relations[relation]
+}
+
+// relationTuples returns the tuples of the object with the relation, the filter narrows the query
+func (s *Service) relationTuples(w *relationWalk, object rebac.Object, relation string, filter *repoDto.GetRelationTuples) ([]rebac.Tuple, error) {
+	if w.lookups++; w.lookups > s.rebac.MaxLookups {
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrRelationCheckTooLarge.Error())
+	}
+	if filter == nil {
+		filter = new(repoDto.GetRelationTuples)
+	}
+	filter.TenantId, filter.Namespace, filter.ObjectId, filter.Relation = w.tenantId, object.Namespace, &object.Id, &relation
+	relationTuples, err := s.store.GetRelationTuples(filter)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	tuples := make([]rebac.Tuple, 0, len(relationTuples))
+	for _, relationTuple := range relationTuples {
+		tuples = append(tuples, rebac.Tuple{
+			Object:   object,
+			Relation: relation,
+			Subject:  rebac.Subject{Namespace: relationTuple.SubjectNamespace, Id: relationTuple.SubjectId, Relation: relationTuple.SubjectRelation},
+		})
+	}
+	return tuples, nil
+}
+
+func (s *Service) checkRelation(w *relationWalk, object rebac.Object, relation string, subject rebac.Subject, depth int) (bool, error) {
+	if depth > s.rebac.MaxDepth {
+		return false, status.Error(codes.FailedPrecondition, svcErr.ErrRelationDepthExceeded.Error())
+	}
+	// the userset is a member of itself
+	if subject.Relation == relation && subject.Object() == object {
+		return true, nil
+	}
+	rel := s.relation(object, relation)
+	if rel == nil || !w.visit(object, relation) {
+		return false, nil
+	}
+	for _, userset := range rel.Rewrite {
+		switch userset.Type {
+		case rebac.This:
+			// the tuple of the subject itself, then the usersets it may be a member of
+			direct, err := s.relationTuples(w, object, relation, &repoDto.GetRelationTuples{
+				SubjectNamespace: &subject.Namespace,
+				SubjectId:        &subject.Id,
+				SubjectRelation:  &subject.Relation,
+				Limit:            1,
+			})
+			if err != nil {
+				return false, err
+			}
+			if len(direct) != 0 {
+				return true, nil
+			}
+			usersets, err := s.relationTuples(w, object, relation, &repoDto.GetRelationTuples{UsersetsOnly: true})
+			if err != nil {
+				return false, err
+			}
+			for _, tuple := range usersets {
+				ok, err := s.checkRelation(w, tuple.Subject.Object(), tuple.Subject.Relation, subject, depth+1)
+				if err != nil || ok {
+					return ok, err
+				}
+			}
+		case rebac.ComputedUserset:
+			ok, err := s.checkRelation(w, object, userset.Relation, subject, depth+1)
+			if err != nil || ok {
+				return ok, err
+			}
+		case rebac.TupleToUserset:
+			tuples, err := s.relationTuples(w, object, userset.Tupleset, nil)
+			if err != nil {
+				return false, err
+			}
+			for _, tuple := range tuples {
+				ok, err := s.checkRelation(w, tuple.Subject.Object(), userset.Relation, subject, depth+1)
+				if err != nil || ok {
+					return ok, err
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+func (s *Service) expandRelation(w *relationWalk, object rebac.Object, relation string, depth int) (*svcDto.UsersetNode, error) {
+	if depth > s.rebac.MaxDepth {
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrRelationDepthExceeded.Error())
+	}
+	node := &svcDto.UsersetNode{Type: "union", Object: object.String(), Relation: relation}
+	rel := s.relation(object, relation)
+	// only a cycle ends here, a node shared by two paths is expanded under both
+	if rel == nil || !w.visit(object, relation) {
+		return node, nil
+	}
+	defer w.leave(object, relation)
+	for _, userset := range rel.Rewrite {
+		switch userset.Type {
+		case rebac.This:
+			tuples, err := s.relationTuples(w, object, relation, nil)
+			if err != nil {
+				return nil, err
+			}
+			leaf := &svcDto.UsersetNode{Type: "this", Object: object.String(), Relation: relation, Subjects: make([]string, 0, len(tuples))}
+			for _, tuple := range tuples {
+				leaf.Subjects = append(leaf.Subjects, tuple.Subject.String())
+			}
+			node.Children = append(node.Children, leaf)
+		case rebac.ComputedUserset:
+			child, err := s.expandRelation(w, object, userset.Relation, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+		case rebac.TupleToUserset:
+			tuples, err := s.relationTuples(w, object, userset.Tupleset, nil)
+			if err != nil {
+				return nil, err
+			}
+			child := &svcDto.UsersetNode{Type: "tuple_to_userset", Object: object.String(), Relation: userset.String()}
+			for _, tuple := range tuples {
+				grandchild, err := s.expandRelation(w, tuple.Subject.Object(), userset.Relation, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				child.Children = append(child.Children, grandchild)
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+	return node, nil
+}
+
+// parseRelationTuple validates the tuple against the namespaces, only the relations with "this" can be written
+func (s *Service) parseRelationTuple(t *svcDto.RelationTuple, owner string) (*repoDto.RelationTuple, error) {
+	if t == nil {
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentObject.Error())
+	}
+	object, err := rebac.ParseObject(t.Object)
+	if err != nil || s.namespaces[object.Namespace] == nil {
+		s.lg.Error("invalid object value", slog.String("owner", owner), slog.String("object", t.Object))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentObject.Error())
+	}
+	if rel := s.relation(object, t.Relation); rel == nil || !rel.Direct() {
+		s.lg.Error("invalid relation value", slog.String("owner", owner), slog.String("relation", t.Relation))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentRelation.Error())
+	}
+	subject, err := rebac.ParseSubject(t.Subject)
+	if err != nil || s.namespaces[subject.Namespace] == nil || (subject.Relation != "" && s.relation(subject.Object(), subject.Relation) == nil) {
+		s.lg.Error("invalid subject value", slog.String("owner", owner), slog.String("subject", t.Subject))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentSubject.Error())
+	}
+	return &repoDto.RelationTuple{
+		Namespace:        object.Namespace,
+		ObjectId:         object.Id,
+		Relation:         t.Relation,
+		SubjectNamespace: subject.Namespace,
+		SubjectId:        subject.Id,
+		SubjectRelation:  subject.Relation,
+	}, nil
+}
+
+// parseObjectRelation validates the object and the relation of the check and the expansion
+func (s *Service) parseObjectRelation(objectValue string, relation string, owner string) (rebac.Object, error) {
+	if s.namespaces == nil {
+		return rebac.Object{}, status.Error(codes.FailedPrecondition, svcErr.ErrRelationsNotConfigured.Error())
+	}
+	object, err := rebac.ParseObject(objectValue)
+	if err != nil || s.namespaces[object.Namespace] == nil {
+		s.lg.Error("invalid object value", slog.String("owner", owner), slog.String("object", objectValue))
+		return rebac.Object{}, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentObject.Error())
+	}
+	if s.relation(object, relation) == nil {
+		s.lg.Error("invalid relation value", slog.String("owner", owner), slog.String("relation", relation))
+		return rebac.Object{}, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentRelation.Error())
+	}
+	return object, nil
+}
+
+func (s *Service) relationRevision() (int64, error) {
+	revision, err := s.store.GetRelationTupleRevision()
+	if err != nil {
+		return -1, status.Error(codes.Internal, err.Error())
+	}
+	return revision, nil
+}
+
+func (s *Service) WriteRelationTuples(ctx context.Context, req *svcDto.WriteRelationTuplesRequest) (*svcDto.WriteRelationTuplesResponse, error) {
+	if s.namespaces == nil {
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrRelationsNotConfigured.Error())
+	}
+	size := len(req.Writes) + len(req.Deletes)
+	if size == 0 || size > s.rebac.MaxWriteSize {
+		s.lg.Error("invalid number of tuples in the write", slog.String("owner", "service.WriteRelationTuples"), slog.Int("tuples", size))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentTuples.Error())
+	}
+	dto := &repoDto.WriteRelationTuples{
+		TenantId: adminTenantId(ctx),
+		Writes:   make([]*repoDto.RelationTuple, 0, len(req.Writes)),
+		Deletes:  make([]*repoDto.RelationTuple, 0, len(req.Deletes)),
+	}
+	for _, t := range req.Writes {
+		tuple, err := s.parseRelationTuple(t, "service.WriteRelationTuples")
+		if err != nil {
+			return nil, err
+		}
+		dto.Writes = append(dto.Writes, tuple)
+	}
+	for _, t := range req.Deletes {
+		tuple, err := s.parseRelationTuple(t, "service.WriteRelationTuples")
+		if err != nil {
+			return nil, err
+		}
+		dto.Deletes = append(dto.Deletes, tuple)
+	}
+	revision, err := s.store.WriteRelationTuples(dto)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.checkCache.invalidate()
+	s.audit(ctx, "relation.write", slog.Int("writes", len(dto.Writes)), slog.Int("deletes", len(dto.Deletes)), slog.Int64("revision", revision))
+	return &svcDto.WriteRelationTuplesResponse{Zookie: encodeZookie(revision)}, nil
+}
+func (s *Service) ReadRelationTuples(ctx context.Context, req *svcDto.ReadRelationTuplesRequest) (*svcDto.ReadRelationTuplesResponse, error) {
+	if s.namespaces == nil {
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrRelationsNotConfigured.Error())
+	}
+	dto := &repoDto.GetRelationTuples{TenantId: callerTenantId(ctx), Limit: s.rebac.MaxReadSize}
+	if strings.Contains(req.Object, ":") {
+		object, err := rebac.ParseObject(req.Object)
+		if err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", "service.ReadRelationTuples"))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentObject.Error())
+		}
+		dto.Namespace, dto.ObjectId = object.Namespace, &object.Id
+	} else {
+		dto.Namespace = req.Object
+	}
+	if s.namespaces[dto.Namespace] == nil {
+		s.lg.Error("invalid object value", slog.String("owner", "service.ReadRelationTuples"), slog.String("object", req.Object))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentObject.Error())
+	}
+	if req.Relation != "" {
+		dto.Relation = &req.Relation
+	}
+	if req.Subject != "" {
+		subject, err := rebac.ParseSubject(req.Subject)
+		if err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", "service.ReadRelationTuples"))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentSubject.Error())
+		}
+		dto.SubjectNamespace, dto.SubjectId, dto.SubjectRelation = &subject.Namespace, &subject.Id, &subject.Relation
+	}
+	// the revision is taken first, the tuples read are at least as new
+	revision, err := s.relationRevision()
+	if err != nil {
+		return nil, err
+	}
+	tuples, err := s.store.GetRelationTuples(dto)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.ReadRelationTuplesResponse{
+		Tuples: make([]*svcDto.RelationTuple, 0, len(tuples)),
+		Zookie: encodeZookie(revision),
+	}
+	for _, tuple := range tuples {
+		resp.Tuples = append(resp.Tuples, &svcDto.RelationTuple{
+			Object:   rebac.Object{Namespace: tuple.Namespace, Id: tuple.ObjectId}.String(),
+			Relation: tuple.Relation,
+			Subject:  rebac.Subject{Namespace: tuple.SubjectNamespace, Id: tuple.SubjectId, Relation: tuple.SubjectRelation}.String(),
+		})
+	}
+	return resp, nil
+}
+func (s *Service) CheckRelation(ctx context.Context, req *svcDto.CheckRelationRequest) (*svcDto.CheckRelationResponse, error) {
+	object, err := s.parseObjectRelation(req.Object, req.Relation, "service.CheckRelation")
+	if err != nil {
+		return nil, err
+	}
+	subject, err := rebac.ParseSubject(req.Subject)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.CheckRelation"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentSubject.Error())
+	}
+	var minRevision int64
+	if req.Zookie != "" {
+		var ok bool
+		if minRevision, ok = decodeZookie(req.Zookie); !ok {
+			s.lg.Error("invalid consistency token value", slog.String("owner", "service.CheckRelation"))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentZookie.Error())
+		}
+	}
+	tenantId := callerTenantId(ctx)
+	key := tenantId.String() + "/" + rebac.Tuple{Object: object, Relation: req.Relation, Subject: subject}.String()
+	if entry, ok := s.checkCache.get(key, minRevision); ok {
+		return &svcDto.CheckRelationResponse{Allowed: entry.allowed, Zookie: encodeZookie(entry.revision)}, nil
+	}
+	revision, err := s.relationRevision()
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := s.checkRelation(&relationWalk{tenantId: tenantId, visited: make(map[string]struct{})}, object, req.Relation, subject, 0)
+	if err != nil {
+		return nil, err
+	}
+	s.checkCache.set(key, allowed, revision)
+	return &svcDto.CheckRelationResponse{Allowed: allowed, Zookie: encodeZookie(revision)}, nil
+}
+func (s *Service) ExpandRelation(ctx context.Context, req *svcDto.ExpandRelationRequest) (*svcDto.ExpandRelationResponse, error) {
+	object, err := s.parseObjectRelation(req.Object, req.Relation, "service.ExpandRelation")
+	if err != nil {
+		return nil, err
+	}
+	revision, err := s.relationRevision()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := s.expandRelation(&relationWalk{tenantId: callerTenantId(ctx), visited: make(map[string]struct{})}, object, req.Relation, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &svcDto.ExpandRelationResponse{Tree: tree, Zookie: encodeZookie(revision)}, nil
+}
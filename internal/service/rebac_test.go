@@ -0,0 +1,234 @@
+package service
+
+import (
+	"ppAuthService/internal/config"
+	"ppAuthService/internal/entity"
+	"ppAuthService/internal/repository"
+	repoDto "ppAuthService/internal/repository/dto"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/rebac"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testNamespaces = `
+namespace group {
+	relation member
+}
+namespace folder {
+	relation owner
+	relation viewer = this | owner
+}
+namespace doc {
+	relation parent
+	relation owner
+	relation editor = this | owner
+	relation viewer = this | editor | parent->viewer
+}
+`
+
+// tupleStore answers GetRelationTuples from the tuples in memory with the filters of the query
+type tupleStore struct {
+	repository.Repository
+	tuples []rebac.Tuple
+	reads  int
+}
+
+func (s *tupleStore) GetRelationTuples(dto *repoDto.GetRelationTuples) ([]*entity.RelationTuple, error) {
+	s.reads++
+	var relationTuples []*entity.RelationTuple
+	for _, tuple := range s.tuples {
+		if tuple.Object.Namespace != dto.Namespace || (dto.ObjectId != nil && tuple.Object.Id != *dto.ObjectId) ||
+			(dto.Relation != nil && tuple.Relation != *dto.Relation) ||
+			(dto.SubjectNamespace != nil && tuple.Subject.Namespace != *dto.SubjectNamespace) ||
+			(dto.SubjectId != nil && tuple.Subject.Id != *dto.SubjectId) ||
+			(dto.SubjectRelation != nil && tuple.Subject.Relation != *dto.SubjectRelation) ||
+			(dto.UsersetsOnly && tuple.Subject.Relation == "") {
+			continue
+		}
+		relationTuples = append(relationTuples, &entity.RelationTuple{
+			Namespace:        tuple.Object.Namespace,
+			ObjectId:         tuple.Object.Id,
+			Relation:         tuple.Relation,
+			SubjectNamespace: tuple.Subject.Namespace,
+			SubjectId:        tuple.Subject.Id,
+			SubjectRelation:  tuple.Subject.Relation,
+		})
+		if dto.Limit != 0 && len(relationTuples) == dto.Limit {
+			break
+		}
+	}
+	return relationTuples, nil
+}
+
+func mustTuple(t *testing.T, object, relation, subject string) rebac.Tuple {
+	t.Helper()
+	parsedObject, err := rebac.ParseObject(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedSubject, err := rebac.ParseSubject(subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rebac.Tuple{Object: parsedObject, Relation: relation, Subject: parsedSubject}
+}
+
+func TestCheckRelation(t *testing.T) {
+	namespaces, err := rebac.Parse(strings.NewReader(testNamespaces))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuples := []rebac.Tuple{
+		mustTuple(t, "group:eng", "member", "user:alice"),
+		mustTuple(t, "group:all", "member", "group:eng#member"),
+		mustTuple(t, "folder:root", "owner", "user:bob"),
+		mustTuple(t, "doc:readme", "parent", "folder:root"),
+		mustTuple(t, "doc:readme", "owner", "user:carol"),
+		mustTuple(t, "doc:readme", "viewer", "group:all#member"),
+		// a cycle of usersets
+		mustTuple(t, "group:a", "member", "group:b#member"),
+		mustTuple(t, "group:b", "member", "group:a#member"),
+	}
+	tests := []struct {
+		name       string
+		object     string
+		relation   string
+		subject    string
+		maxDepth   int
+		maxLookups int
+		want       bool
+		code       codes.Code
+		err        error
+	}{
+		{name: "direct tuple", object: "group:eng", relation: "member", subject: "user:alice", want: true},
+		{name: "nested userset", object: "group:all", relation: "member", subject: "user:alice", want: true},
+		{name: "computed userset", object: "doc:readme", relation: "editor", subject: "user:carol", want: true},
+		{name: "computed userset chain", object: "doc:readme", relation: "viewer", subject: "user:carol", want: true},
+		{name: "tuple to userset", object: "doc:readme", relation: "viewer", subject: "user:bob", want: true},
+		{name: "userset of the subject", object: "doc:readme", relation: "viewer", subject: "group:eng#member", want: true},
+		{name: "userset member of itself", object: "group:eng", relation: "member", subject: "group:eng#member", want: true},
+		{name: "no relation", object: "doc:readme", relation: "editor", subject: "user:alice", want: false},
+		{name: "unknown relation", object: "doc:readme", relation: "admin", subject: "user:carol", want: false},
+		{name: "cycle", object: "group:a", relation: "member", subject: "user:alice", want: false},
+		{name: "too deep", object: "doc:readme", relation: "viewer", subject: "user:alice", maxDepth: 1,
+			code: codes.FailedPrecondition, err: svcErr.ErrRelationDepthExceeded},
+		{name: "too many lookups", object: "doc:readme", relation: "viewer", subject: "user:alice", maxLookups: 2,
+			code: codes.FailedPrecondition, err: svcErr.ErrRelationCheckTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rebacConfig := &config.Rebac{MaxDepth: 16, MaxLookups: 1000}
+			if tt.maxDepth != 0 {
+				rebacConfig.MaxDepth = tt.maxDepth
+			}
+			if tt.maxLookups != 0 {
+				rebacConfig.MaxLookups = tt.maxLookups
+			}
+			s := &Service{store: &tupleStore{tuples: tuples}, rebac: rebacConfig, namespaces: namespaces}
+			object, err := rebac.ParseObject(tt.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subject, err := rebac.ParseSubject(tt.subject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.checkRelation(&relationWalk{visited: make(map[string]struct{})}, object, tt.relation, subject, 0)
+			if tt.err != nil {
+				if status.Code(err) != tt.code || status.Convert(err).Message() != tt.err.Error() {
+					t.Fatalf("checkRelation() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkRelation() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("checkRelation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// the single subjects of a wide relation are not read, only the tuple of the checked subject and the usersets
+func TestCheckRelationReadsUsersetsOnly(t *testing.T) {
+	namespaces, err := rebac.Parse(strings.NewReader(testNamespaces))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &tupleStore{}
+	for i := 0; i < 100; i++ {
+		store.tuples = append(store.tuples, mustTuple(t, "group:big", "member", "user:u"+strings.Repeat("x", i)))
+	}
+	s := &Service{store: store, rebac: &config.Rebac{MaxDepth: 16, MaxLookups: 1000}, namespaces: namespaces}
+	ok, err := s.checkRelation(&relationWalk{visited: make(map[string]struct{})}, rebac.Object{Namespace: "group", Id: "big"}, "member", rebac.Subject{Namespace: "user", Id: "nobody"}, 0)
+	if err != nil || ok {
+		t.Fatalf("checkRelation() = %v, %v, want false", ok, err)
+	}
+	if store.reads != 2 {
+		t.Errorf("checkRelation() read the tuples %d times, want 2", store.reads)
+	}
+}
+
+// usersetSubjects returns the subjects of the "this" leaves under the node
+func usersetSubjects(node *svcDto.UsersetNode) []string {
+	subjects := append([]string(nil), node.Subjects...)
+	for _, child := range node.Children {
+		subjects = append(subjects, usersetSubjects(child)...)
+	}
+	return subjects
+}
+
+// the relation reached by two paths is expanded under both of them, only a cycle ends the expansion
+func TestExpandRelation(t *testing.T) {
+	namespaces, err := rebac.Parse(strings.NewReader(`
+namespace group {
+	relation member
+}
+namespace doc {
+	relation owner
+	relation editor = this | owner
+	relation viewer = this | editor | owner
+}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuples := []rebac.Tuple{
+		mustTuple(t, "doc:readme", "owner", "user:carol"),
+		mustTuple(t, "doc:readme", "editor", "user:dave"),
+		mustTuple(t, "group:a", "member", "group:b#member"),
+		mustTuple(t, "group:b", "member", "group:a#member"),
+	}
+	tests := []struct {
+		name     string
+		object   string
+		relation string
+		subjects []string
+	}{
+		{name: "diamond", object: "doc:readme", relation: "viewer", subjects: []string{"user:dave", "user:carol", "user:carol"}},
+		{name: "cycle", object: "group:a", relation: "member", subjects: []string{"group:b#member"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{store: &tupleStore{tuples: tuples}, rebac: &config.Rebac{MaxDepth: 16, MaxLookups: 1000}, namespaces: namespaces}
+			object, err := rebac.ParseObject(tt.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree, err := s.expandRelation(&relationWalk{visited: make(map[string]struct{})}, object, tt.relation, 0)
+			if err != nil {
+				t.Fatalf("expandRelation() error = %v", err)
+			}
+			if got := usersetSubjects(tree); !slices.Equal(got, tt.subjects) {
+				t.Errorf("expandRelation() subjects = %v, want %v", got, tt.subjects)
+			}
+		})
+	}
+}
@@ -2,17 +2,23 @@ package service
 
 import (
 	"context"
-	"crypto/rsa"
+	"encoding/base64"
 	"errors"
 	"log"
 	"log/slog"
 	"ppAuthService/internal/config"
+	"ppAuthService/internal/entity"
+	"ppAuthService/internal/notifier"
 	"ppAuthService/internal/repository"
 	repoDto "ppAuthService/internal/repository/dto"
 	repoErr "ppAuthService/internal/repository/err"
 	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/breach"
 	"ppAuthService/pkg/jwt"
+	"ppAuthService/pkg/password"
+	"ppAuthService/pkg/rebac"
 	"ppAuthService/pkg/secure"
+	"sync"
 	"time"
 
 	proto "github.com/MedvedevEA/ppProtos/gen/auth"
@@ -24,38 +30,178 @@ import (
 type Service struct {
 	proto.UnimplementedAuthServiceServer
 	store           repository.Repository
-	privateKey      *rsa.PrivateKey
+	signingKey      *jwt.SigningKey
 	accessLifetime  time.Duration
 	refrashLifetime time.Duration
-	lg              *slog.Logger
+	reauthWindow    time.Duration
+	token           *config.Token
+	loginGuard      *config.LoginGuard
+	mfa             *config.Mfa
+	mfaKey          []byte
+	session         *config.Session
+	unregister      *config.Unregister
+	email           *config.Email
+	passwordReset   *config.PasswordReset
+	notifier        notifier.Notifier
+	passwordPolicy  *password.Policy
+	// number of the previous passwords that can not be used again
+	passwordHistoryDepth int
+	breachIndex          *breach.Index
+	breachMinCount       int
+	authz                *config.Authz
+	decisionCache        *decisionCache
+	rebac                *config.Rebac
+	// nil when the relation tuples are not configured
+	namespaces map[string]*rebac.Namespace
+	checkCache *checkCache
+	tenant     *config.Tenant
+	admin      *config.Admin
+	// settings of the service accounts and the client credentials grant
+	serviceAccount *config.ServiceAccount
+	apiKey         *config.ApiKey
+	oauth          *config.OAuth
+	// nil when the tenants can not have own signing keys
+	tenantKeyEncryptionKey []byte
+	// decrypted own keys of the tenants by the key id
+	tenantSigningKeys sync.Map
+	// kids of the tokens that are not in the store
+	unknownKeyIds unknownKeyIds
+	lg            *slog.Logger
 }
 
-func MustNew(store repository.Repository, lg *slog.Logger, cfg *config.Token) *Service {
-	privateKey, err := secure.LoadPrivateKey(cfg.PrivateKeyPath)
+func MustNew(store repository.Repository, notifier notifier.Notifier, lg *slog.Logger, cfg *config.Config) *Service {
+	privateKey, err := secure.LoadPrivateKey(cfg.Token.PrivateKeyPath)
 	if err != nil {
 		log.Fatalf("failed to initialize service: %v\n", err)
 	}
+	mfaKey, err := base64.StdEncoding.DecodeString(cfg.Mfa.EncryptionKey)
+	if err != nil || len(mfaKey) != 32 {
+		log.Fatalf("failed to initialize service: mfa encryption key must be base64 encoded 32 bytes\n")
+	}
+	switch cfg.Session.EvictionPolicy {
+	case evictionPolicyReject, evictionPolicyEvictLru:
+	default:
+		log.Fatalf("failed to initialize service: invalid session eviction policy %q\n", cfg.Session.EvictionPolicy)
+	}
+	switch cfg.Unregister.LoginPolicy {
+	case loginPolicyReserve, loginPolicyFree:
+	default:
+		log.Fatalf("failed to initialize service: invalid unregister login policy %q\n", cfg.Unregister.LoginPolicy)
+	}
+	var commonPasswords map[string]struct{}
+	if cfg.PasswordPolicy.CommonPasswordsPath != "" {
+		commonPasswords, err = password.LoadCommonPasswords(cfg.PasswordPolicy.CommonPasswordsPath)
+		if err != nil {
+			log.Fatalf("failed to initialize service: %v\n", err)
+		}
+	}
+	var breachIndex *breach.Index
+	if cfg.PasswordPolicy.BreachIndexPath != "" {
+		breachIndex, err = breach.Open(cfg.PasswordPolicy.BreachIndexPath)
+		if err != nil {
+			log.Fatalf("failed to initialize service: %v\n", err)
+		}
+		lg.Info("breach index is loaded", slog.String("owner", "service.MustNew"), slog.Int64("hashes", breachIndex.Len()))
+	}
+	var tenantKeyEncryptionKey []byte
+	if cfg.Tenant.SigningKeyEncryptionKey != "" {
+		tenantKeyEncryptionKey, err = base64.StdEncoding.DecodeString(cfg.Tenant.SigningKeyEncryptionKey)
+		if err != nil || len(tenantKeyEncryptionKey) != 32 {
+			log.Fatalf("failed to initialize service: tenant signing key encryption key must be base64 encoded 32 bytes\n")
+		}
+	}
+	var namespaces map[string]*rebac.Namespace
+	if cfg.Rebac.NamespacesPath != "" {
+		namespaces, err = rebac.Load(cfg.Rebac.NamespacesPath)
+		if err != nil {
+			log.Fatalf("failed to initialize service: %v\n", err)
+		}
+	}
 
 	return &Service{
 		store:           store,
-		privateKey:      privateKey,
-		accessLifetime:  cfg.AccessLifetime,
-		refrashLifetime: cfg.RefreshLifetime,
-		lg:              lg,
+		signingKey:      &jwt.SigningKey{Id: jwt.KeyId(&privateKey.PublicKey), PrivateKey: privateKey},
+		accessLifetime:  cfg.Token.AccessLifetime,
+		refrashLifetime: cfg.Token.RefreshLifetime,
+		reauthWindow:    cfg.Token.ReauthWindow,
+		token:           &cfg.Token,
+		loginGuard:      &cfg.LoginGuard,
+		mfa:             &cfg.Mfa,
+		mfaKey:          mfaKey,
+		session:         &cfg.Session,
+		unregister:      &cfg.Unregister,
+		email:           &cfg.Email,
+		passwordReset:   &cfg.PasswordReset,
+		notifier:        notifier,
+		passwordPolicy: &password.Policy{
+			MinLength:       cfg.PasswordPolicy.MinLength,
+			MaxLength:       cfg.PasswordPolicy.MaxLength,
+			RequireLower:    cfg.PasswordPolicy.RequireLower,
+			RequireUpper:    cfg.PasswordPolicy.RequireUpper,
+			RequireDigit:    cfg.PasswordPolicy.RequireDigit,
+			RequireSymbol:   cfg.PasswordPolicy.RequireSymbol,
+			DisallowLogin:   cfg.PasswordPolicy.DisallowLogin,
+			CommonPasswords: commonPasswords,
+		},
+		passwordHistoryDepth:   cfg.PasswordPolicy.HistoryDepth,
+		breachIndex:            breachIndex,
+		breachMinCount:         cfg.PasswordPolicy.BreachMinCount,
+		authz:                  &cfg.Authz,
+		decisionCache:          newDecisionCache(cfg.Authz.CacheTtl, cfg.Authz.CacheSize),
+		rebac:                  &cfg.Rebac,
+		namespaces:             namespaces,
+		checkCache:             &checkCache{ttl: cfg.Rebac.CacheTtl, size: cfg.Rebac.CacheSize},
+		tenant:                 &cfg.Tenant,
+		admin:                  &cfg.Admin,
+		serviceAccount:         &cfg.ServiceAccount,
+		apiKey:                 &cfg.ApiKey,
+		oauth:                  &cfg.OAuth,
+		tenantKeyEncryptionKey: tenantKeyEncryptionKey,
+		lg:                     lg,
 	}
 }
 
 func (s *Service) Register(ctx context.Context, req *proto.RegisterRequest) (*proto.RegisterResponse, error) {
+	var email *string
+	if value := metadataValue(ctx, emailKey); value != "" {
+		normalizedEmail, ok := normalizeEmail(value)
+		if !ok {
+			s.lg.Error("invalid email value", slog.String("owner", "service.Register"))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentEmail.Error())
+		}
+		email = &normalizedEmail
+	} else if s.email.RequireVerified {
+		s.lg.Error("email is required", slog.String("owner", "service.Register"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentEmail.Error())
+	}
+	tenant, err := s.requestTenant(ctx, "service.Register")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPassword("password", req.Password, req.Login, nil, tenant, "service.Register"); err != nil {
+		return nil, err
+	}
+	hashPassword := secure.GetHash(req.Password)
 	userId, err := s.store.AddUser(&repoDto.AddUser{
+		TenantId: tenant.TenantId,
 		Login:    req.Login,
-		Password: secure.GetHash(req.Password),
+		Password: hashPassword,
+		Email:    email,
 	})
 	if err != nil {
 		if errors.Is(err, repoErr.ErrUniqueViolation) {
 			return nil, status.Error(codes.AlreadyExists, svcErr.ErrLoginAlreadyExists.Error())
 		}
+		if errors.Is(err, repoErr.ErrEmailUniqueViolation) {
+			return nil, status.Error(codes.AlreadyExists, svcErr.ErrEmailAlreadyExists.Error())
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	s.addPasswordHistory(userId, hashPassword)
+	if email != nil {
+		// the user is registered, a failed delivery can be repeated with ResendEmailVerification
+		s.sendEmailVerification(userId, req.Login, *email, "service.Register")
+	}
 	return &proto.RegisterResponse{UserId: userId.String()}, nil
 }
 func (s *Service) Unregister(ctx context.Context, req *proto.UnregisterRequest) (*proto.UnregisterResponse, error) {
@@ -64,59 +210,160 @@ func (s *Service) Unregister(ctx context.Context, req *proto.UnregisterRequest)
 		s.lg.Error(err.Error(), slog.String("owner", "service.Unregister"))
 		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
 	}
-	if err := s.store.RemoveUser(&userId); err != nil {
+	user, err := s.store.GetUser(&userId)
+	if err != nil {
 		if errors.Is(err, repoErr.ErrRecordNotFound) {
 			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
 		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	if user.Status == entity.UserStatusDeleted {
+		return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+	}
+	if err := s.checkReauth(ctx, user, "service.Unregister"); err != nil {
+		return nil, err
+	}
+	if err := s.deleteUser(ctx, user, "unregister", "service.Unregister"); err != nil {
+		return nil, err
+	}
 	return &proto.UnregisterResponse{}, nil
 
 }
 func (s *Service) Login(ctx context.Context, req *proto.LoginRequest) (*proto.LoginResponse, error) {
-	if req.DeviceCode == "" {
+	if mfaToken := metadataValue(ctx, mfaTokenKey); mfaToken != "" {
+		return s.loginMfa(ctx, mfaToken, metadataValue(ctx, mfaCodeKey))
+	}
+	if req.DeviceCode == "" || isOAuthDeviceCode(req.DeviceCode) {
 		s.lg.Error("invalid device code value", slog.String("owner", "service.Login"))
 		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentDeviceCode.Error())
 	}
-	user, err := s.store.GetUserByLogin(req.Login)
+	tenant, err := s.requestTenant(ctx, "service.Login")
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.checkLoginPassword(ctx, tenant, req.Login, req.Password, "service.Login")
+	if err != nil {
+		return nil, err
+	}
+	userMfa, err := s.getConfirmedUserMfa(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if userMfa != nil {
+		return nil, s.mfaChallenge(ctx, user.UserId, req.DeviceCode)
+	}
+	s.resetLoginFailures(tenantLogin(tenant.TenantId, req.Login))
+	return s.login(ctx, user, req.DeviceCode, "service.Login")
+}
+
+// checkLoginPassword finds the user by the login and checks the password under the brute-force protection.
+// The failures are counted, the caller resets them once the whole login succeeds
+func (s *Service) checkLoginPassword(ctx context.Context, tenant *entity.Tenant, loginValue string, password string, owner string) (*entity.User, error) {
+	login := tenantLogin(tenant.TenantId, loginValue)
+	ip := clientIp(ctx)
+	if err := s.checkLoginAttempts(ctx, login, ip); err != nil {
+		return nil, err
+	}
+	user, err := s.store.GetUserByLogin(tenant.TenantId, loginValue)
 	if err != nil {
 		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.addLoginFailure(ctx, login, ip)
 			return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidLoginOrPassword.Error())
 		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	if !secure.CheckHash(req.Password, user.Password) {
-		s.lg.Error("hash verification error", slog.String("owner", "service.Login"))
+	if !secure.CheckHash(password, user.Password) {
+		s.lg.Error("hash verification error", slog.String("owner", owner))
+		s.addLoginFailure(ctx, login, ip)
 		return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidLoginOrPassword.Error())
 	}
-	if err := s.store.RevokeRefreshTokensByUserIdAndDeviceCode(&repoDto.RevokeRefreshTokensByUserIdAndDeviceCode{
-		UserId:     user.UserId,
-		DeviceCode: &req.DeviceCode,
-	}); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	// the status is told only to the one who knows the password
+	if err := s.checkUserStatus(user, owner); err != nil {
+		return nil, err
+	}
+	if err := s.checkEmailVerified(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// login revokes the previous tokens of the device and issues a new token pair
+func (s *Service) login(ctx context.Context, user *entity.User, deviceCode string, owner string) (*proto.LoginResponse, error) {
+	userId := user.UserId
+	sessionId := uuid.New()
+	accessTokenString, refreshTokenString, err := s.createTokens(ctx, user, deviceCode, &session{
+		sessionId: &sessionId,
+		issuedAt:  time.Now(),
+		client:    getClientInfo(ctx),
+		newDevice: true,
+	}, owner)
+	if err != nil {
+		return nil, err
+	}
+	s.audit(ctx, "login", slog.Any("userId", userId), slog.String("deviceCode", deviceCode), slog.Any("sessionId", sessionId))
+	return &proto.LoginResponse{AccessToken: accessTokenString, RefreshToken: refreshTokenString}, nil
+}
+
+// createTokens creates the access and refresh tokens with the lifetimes and the signing key of the tenant of the user
+// and saves the refresh token as a part of the session
+func (s *Service) createTokens(ctx context.Context, user *entity.User, deviceCode string, session *session, owner string) (string, string, error) {
+	userId := user.UserId
+	tenant, err := s.getTenant(user.TenantId)
+	if err != nil {
+		return "", "", err
+	}
+	accessLifetime, refreshLifetime := s.tokenLifetimes(tenant)
+	signingKey, err := s.tokenSigningKey(tenant, owner)
+	if err != nil {
+		return "", "", err
 	}
 	//access token
-	accessTokenString, _, err := jwt.CreateToken(user.UserId, req.DeviceCode, "access", s.accessLifetime, s.privateKey)
+	roles, permissions, truncated, err := s.userAuthorization(userId)
 	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "service.Login"))
-		return nil, status.Error(codes.Internal, err.Error())
+		return "", "", err
+	}
+	options := []jwt.Option{jwt.WithAuthorization(roles, permissions, truncated), jwt.WithTenant(user.TenantId), jwt.WithScopes(session.scopes)}
+	if session.clientId == "" {
+		options = append(options, jwt.WithAuthTime(session.issuedAt))
+	} else {
+		// the token of a third-party client is bound to it and never proves a recent login
+		options = append(options, jwt.WithClient(session.clientId))
+	}
+	accessTokenString, _, err := jwt.CreateToken(userId, deviceCode, "access", accessLifetime, signingKey, options...)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return "", "", status.Error(codes.Internal, err.Error())
 	}
 	//refresh token
-	refreshTokenString, refreshTokenClaims, err := jwt.CreateToken(user.UserId, req.DeviceCode, "refresh", s.refrashLifetime, s.privateKey)
+	refreshTokenString, refreshTokenClaims, err := jwt.CreateToken(userId, deviceCode, "refresh", refreshLifetime, signingKey, jwt.WithTenant(user.TenantId))
 	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "service.Login"))
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return "", "", status.Error(codes.Internal, err.Error())
 	}
-	if err := s.store.AddRefreshTokenWithRefreshTokenId(&repoDto.AddRefreshTokenWithRefreshTokenId{
+	refreshToken := &repoDto.AddRefreshTokenWithRefreshTokenId{
 		RefreshTokenId: refreshTokenClaims.Jti,
 		UserId:         refreshTokenClaims.Sub,
 		DeviceCode:     refreshTokenClaims.DeviceCode,
 		ExpirationAt:   refreshTokenClaims.ExpiresAt.Time,
 		IsRevoke:       false,
-	}); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		SessionId:      session.sessionId,
+		IssuedAt:       session.issuedAt,
+		ClientIp:       session.client.ip,
+		UserAgent:      session.client.userAgent,
+		ClientVersion:  session.client.clientVersion,
+		LastUsedAt:     time.Now(),
+		Scopes:         session.scopes,
 	}
-
-	return &proto.LoginResponse{AccessToken: accessTokenString, RefreshToken: refreshTokenString}, nil
+	if session.newDevice {
+		if err := s.addDeviceRefreshToken(ctx, user, refreshToken, owner); err != nil {
+			return "", "", err
+		}
+		return accessTokenString, refreshTokenString, nil
+	}
+	if err := s.store.AddRefreshTokenWithRefreshTokenId(refreshToken); err != nil {
+		return "", "", status.Error(codes.Internal, err.Error())
+	}
+	return accessTokenString, refreshTokenString, nil
 }
 func (s *Service) Logout(ctx context.Context, req *proto.LogoutRequest) (*proto.LogoutResponse, error) {
 	userId, err := uuid.Parse(req.UserId)
@@ -124,7 +371,7 @@ func (s *Service) Logout(ctx context.Context, req *proto.LogoutRequest) (*proto.
 		s.lg.Error(err.Error(), slog.String("owner", "service.Logout"))
 		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
 	}
-	if req.DeviceCode == "" {
+	if req.DeviceCode == "" || isOAuthDeviceCode(req.DeviceCode) {
 		s.lg.Error("invalid device code value", slog.String("owner", "service.Logout"))
 		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentDeviceCode.Error())
 	}
@@ -134,6 +381,7 @@ func (s *Service) Logout(ctx context.Context, req *proto.LogoutRequest) (*proto.
 	}); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	s.audit(ctx, "logout", slog.Any("userId", userId), slog.String("deviceCode", req.DeviceCode))
 	return &proto.LogoutResponse{}, nil
 }
 func (s *Service) UpdatePassword(ctx context.Context, req *proto.UpdatePasswordRequest) (*proto.UpdatePasswordResponse, error) {
@@ -142,6 +390,26 @@ func (s *Service) UpdatePassword(ctx context.Context, req *proto.UpdatePasswordR
 		s.lg.Error(err.Error(), slog.String("owner", "service.UpdatePassword"))
 		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
 	}
+	user, err := s.store.GetUser(&userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.checkUserStatus(user, "service.UpdatePassword"); err != nil {
+		return nil, err
+	}
+	if err := s.checkReauth(ctx, user, "service.UpdatePassword"); err != nil {
+		return nil, err
+	}
+	tenant, err := s.getTenant(user.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPassword("newPassword", req.NewPassword, user.Login, user, tenant, "service.UpdatePassword"); err != nil {
+		return nil, err
+	}
 	hashNewPassword := secure.GetHash(req.NewPassword)
 	if err = s.store.UpdateUser(&repoDto.UpdateUser{
 		UserId:   &userId,
@@ -158,6 +426,7 @@ func (s *Service) UpdatePassword(ctx context.Context, req *proto.UpdatePasswordR
 	}); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	s.addPasswordHistory(&userId, hashNewPassword)
 	return &proto.UpdatePasswordResponse{}, nil
 }
 func (s *Service) RefreshToken(ctx context.Context, req *proto.RefreshTokenRequest) (*proto.RefreshTokenResponse, error) {
@@ -166,49 +435,66 @@ func (s *Service) RefreshToken(ctx context.Context, req *proto.RefreshTokenReque
 		s.lg.Error(err.Error(), slog.String("owner", "service.RefreshToken"))
 		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentTokenId.Error())
 	}
-	refreshToken, err := s.store.GetRefreshToken(&refreshTokenId)
+	accessTokenString, refreshTokenString, _, err := s.rotateRefreshToken(ctx, &refreshTokenId, nil, "service.RefreshToken")
+	if err != nil {
+		return nil, err
+	}
+	return &proto.RefreshTokenResponse{
+		AccessToken:  accessTokenString,
+		RefreshToken: refreshTokenString,
+	}, nil
+}
+
+// rotateRefreshToken revokes the refresh token and issues a new token pair of the same session. The reuse of
+// a revoked token revokes all the tokens of the device. deviceCode, when set, is the device the token must belong to,
+// otherwise the token must be a first-party one: the tokens of the OAuth clients are refreshed only by the refresh_token
+// grant, which authenticates the client
+func (s *Service) rotateRefreshToken(ctx context.Context, refreshTokenId *uuid.UUID, deviceCode *string, owner string) (string, string, *entity.RefreshToken, error) {
+	refreshToken, err := s.store.GetRefreshToken(refreshTokenId)
 	if err != nil {
 		if errors.Is(err, repoErr.ErrRecordNotFound) {
-			return nil, status.Error(codes.NotFound, svcErr.ErrTokenNotFound.Error())
+			return "", "", nil, status.Error(codes.NotFound, svcErr.ErrTokenNotFound.Error())
 		}
-		return nil, status.Error(codes.Internal, err.Error())
+		return "", "", nil, status.Error(codes.Internal, err.Error())
+	}
+	if (deviceCode != nil && refreshToken.DeviceCode != *deviceCode) || (deviceCode == nil && isOAuthDeviceCode(refreshToken.DeviceCode)) {
+		s.lg.Error("token belongs to another device", slog.String("owner", owner), slog.Any("tokenId", refreshToken.RefreshTokenId))
+		return "", "", nil, status.Error(codes.NotFound, svcErr.ErrTokenNotFound.Error())
+	}
+	user, err := s.store.GetUser(refreshToken.UserId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return "", "", nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return "", "", nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.checkUserStatus(user, owner); err != nil {
+		return "", "", nil, err
 	}
 	if refreshToken.IsRevoke {
 		if err := s.store.RevokeRefreshTokensByUserIdAndDeviceCode(&repoDto.RevokeRefreshTokensByUserIdAndDeviceCode{
 			UserId:     refreshToken.UserId,
 			DeviceCode: &refreshToken.DeviceCode,
 		}); err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+			return "", "", nil, status.Error(codes.Internal, err.Error())
 		}
-		s.lg.Info("token is revoked", slog.String("owner", "service.RefreshToken"), slog.Any("tokenId", refreshToken.RefreshTokenId))
-		return nil, status.Error(codes.Unauthenticated, svcErr.ErrTokenRevoked.Error())
+		s.lg.Info("token is revoked", slog.String("owner", owner), slog.Any("tokenId", refreshToken.RefreshTokenId))
+		s.audit(ctx, "token.reuse", slog.Any("userId", refreshToken.UserId), slog.String("deviceCode", refreshToken.DeviceCode), slog.Any("sessionId", refreshToken.SessionId))
+		return "", "", nil, status.Error(codes.Unauthenticated, svcErr.ErrTokenRevoked.Error())
 	}
-	if err := s.store.RevokeRefreshTokenByRefreshTokenId(&refreshTokenId); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err := s.store.RevokeRefreshTokenByRefreshTokenId(refreshTokenId); err != nil {
+		return "", "", nil, status.Error(codes.Internal, err.Error())
 	}
-	//access token
-	accessTokenString, _, err := jwt.CreateToken(refreshToken.UserId, refreshToken.DeviceCode, "access", s.accessLifetime, s.privateKey)
+	accessTokenString, refreshTokenString, err := s.createTokens(ctx, user, refreshToken.DeviceCode, &session{
+		sessionId: refreshToken.SessionId,
+		issuedAt:  refreshToken.IssuedAt,
+		client:    getClientInfo(ctx),
+		clientId:  oauthClientId(refreshToken.DeviceCode),
+		scopes:    refreshToken.Scopes,
+	}, owner)
 	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "service.RefreshToken"))
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-	//refresh token
-	refreshTokenString, refreshTokenClaims, err := jwt.CreateToken(refreshToken.UserId, refreshToken.DeviceCode, "refresh", s.refrashLifetime, s.privateKey)
-	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "service.RefreshToken"))
-		return nil, status.Error(codes.Internal, err.Error())
+		return "", "", nil, err
 	}
-	if err := s.store.AddRefreshTokenWithRefreshTokenId(&repoDto.AddRefreshTokenWithRefreshTokenId{
-		RefreshTokenId: refreshTokenClaims.Jti,
-		UserId:         refreshTokenClaims.Sub,
-		DeviceCode:     refreshTokenClaims.DeviceCode,
-		ExpirationAt:   refreshTokenClaims.ExpiresAt.Time,
-		IsRevoke:       false,
-	}); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
-	}
-	return &proto.RefreshTokenResponse{
-		AccessToken:  accessTokenString,
-		RefreshToken: refreshTokenString,
-	}, nil
+	s.audit(ctx, "token.refresh", slog.Any("userId", refreshToken.UserId), slog.String("deviceCode", refreshToken.DeviceCode), slog.Any("sessionId", refreshToken.SessionId), slog.String("previousIp", refreshToken.ClientIp))
+	return accessTokenString, refreshTokenString, refreshToken, nil
 }
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/jwt"
+	"ppAuthService/pkg/secure"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grantTypeClientCredentials = "client_credentials"
+	clientIdPrefix             = "sa-"
+	// sizes of the client id and the secret in bytes before encoding
+	clientIdSize     = 12
+	clientSecretSize = 32
+)
+
+var scopeRegexp = regexp.MustCompile(`^[a-z][a-z0-9_.:-]*$`)
+
+// parseScopes validates the scopes and returns them sorted without duplicates
+func (s *Service) parseScopes(scopes []string, owner string) ([]string, error) {
+	parsed := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !scopeRegexp.MatchString(scope) {
+			s.lg.Error("invalid scope value", slog.String("owner", owner), slog.String("scope", scope))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentScope.Error())
+		}
+		parsed = append(parsed, scope)
+	}
+	slices.Sort(parsed)
+	return slices.Compact(parsed), nil
+}
+
+// secretExpirationAt returns the expiration of the new secret, nil for the secret that does not expire
+func (s *Service) secretExpirationAt(lifetimeValue string, owner string) (*time.Time, error) {
+	lifetime := s.serviceAccount.SecretLifetime
+	if lifetimeValue != "" {
+		var err error
+		if lifetime, err = time.ParseDuration(lifetimeValue); err != nil || lifetime < time.Second {
+			s.lg.Error("invalid lifetime value", slog.String("owner", owner), slog.String("lifetime", lifetimeValue))
+			return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentLifetime.Error())
+		}
+	}
+	if lifetime == 0 {
+		return nil, nil
+	}
+	expirationAt := time.Now().Add(lifetime)
+	return &expirationAt, nil
+}
+
+func isSecretExpired(secret *entity.ServiceAccountSecret, now time.Time) bool {
+	return secret.ExpirationAt != nil && !secret.ExpirationAt.After(now)
+}
+
+// getServiceAccount returns the account of the tenant the admin of the call manages
+func (s *Service) getServiceAccount(ctx context.Context, clientId string) (*entity.ServiceAccount, error) {
+	if clientId == "" {
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentClientId.Error())
+	}
+	serviceAccount, err := s.store.GetServiceAccountByClientId(clientId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrServiceAccountNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if *serviceAccount.TenantId != *adminTenantId(ctx) {
+		return nil, status.Error(codes.NotFound, svcErr.ErrServiceAccountNotFound.Error())
+	}
+	return serviceAccount, nil
+}
+
+// Token issues the access token by the OAuth 2.0 grant
+func (s *Service) Token(ctx context.Context, req *svcDto.TokenRequest) (*svcDto.TokenResponse, error) {
+	switch req.GrantType {
+	case grantTypeClientCredentials:
+		return s.clientCredentials(ctx, req)
+	}
+	s.lg.Error("unsupported grant type", slog.String("owner", "service.Token"), slog.String("grantType", req.GrantType))
+	return nil, status.Error(codes.InvalidArgument, svcErr.ErrUnsupportedGrantType.Error())
+}
+
+// clientCredentials issues the access token of the service account. There is no refresh token,
+// the account repeats the grant when the token expires
+func (s *Service) clientCredentials(ctx context.Context, req *svcDto.TokenRequest) (*svcDto.TokenResponse, error) {
+	serviceAccount, err := s.store.GetServiceAccountByClientId(req.ClientId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.audit(ctx, "service_account.token.failure", slog.String("clientId", req.ClientId))
+			return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidClient.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	secrets, err := s.store.GetServiceAccountSecrets(serviceAccount.ServiceAccountId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	now := time.Now()
+	valid := false
+	for _, secret := range secrets {
+		if !isSecretExpired(secret, now) && secure.CheckHash(req.ClientSecret, secret.SecretHash) {
+			valid = true
+			break
+		}
+	}
+	if !valid || serviceAccount.IsDisabled {
+		s.lg.Error("client credentials verification error", slog.String("owner", "service.Token"), slog.String("clientId", req.ClientId), slog.Bool("disabled", serviceAccount.IsDisabled))
+		s.audit(ctx, "service_account.token.failure", slog.String("clientId", req.ClientId))
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrInvalidClient.Error())
+	}
+	scopes, err := s.requestedScopes(req.Scope, append([]string{}, serviceAccount.Scopes...), "service.Token")
+	if err != nil {
+		return nil, err
+	}
+	// the token is signed with the key of the tenant, so the JWKS of the tenant verifies it
+	tenant, err := s.getTenant(serviceAccount.TenantId)
+	if err != nil {
+		return nil, err
+	}
+	signingKey, err := s.tokenSigningKey(tenant, "service.Token")
+	if err != nil {
+		return nil, err
+	}
+	accessTokenString, _, err := jwt.CreateToken(serviceAccount.ServiceAccountId, "", "access", s.serviceAccount.TokenLifetime, signingKey, jwt.WithSubjectType(jwt.SubjectTypeServiceAccount), jwt.WithTenant(serviceAccount.TenantId), jwt.WithScopes(scopes))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.Token"))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "service_account.token", slog.String("clientId", req.ClientId), slog.Any("scopes", scopes))
+	return &svcDto.TokenResponse{
+		AccessToken: accessTokenString,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.serviceAccount.TokenLifetime.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+func (s *Service) CreateServiceAccount(ctx context.Context, req *svcDto.CreateServiceAccountRequest) (*svcDto.CreateServiceAccountResponse, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		s.lg.Error("invalid name value", slog.String("owner", "service.CreateServiceAccount"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentName.Error())
+	}
+	scopes, err := s.parseScopes(req.Scopes, "service.CreateServiceAccount")
+	if err != nil {
+		return nil, err
+	}
+	expirationAt, err := s.secretExpirationAt(req.SecretLifetime, "service.CreateServiceAccount")
+	if err != nil {
+		return nil, err
+	}
+	clientId, err := secure.GenerateToken(clientIdSize)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.CreateServiceAccount"))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	clientId = clientIdPrefix + clientId
+	clientSecret, err := secure.GenerateToken(clientSecretSize)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.CreateServiceAccount"))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	serviceAccountId, err := s.store.AddServiceAccount(&repoDto.AddServiceAccount{
+		TenantId:           adminTenantId(ctx),
+		ClientId:           clientId,
+		Name:               req.Name,
+		Scopes:             scopes,
+		SecretHash:         secure.GetHash(clientSecret),
+		SecretExpirationAt: expirationAt,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	secrets, err := s.store.GetServiceAccountSecrets(serviceAccountId)
+	if err != nil || len(secrets) == 0 {
+		return nil, status.Error(codes.Internal, svcErr.ErrInternalServerError.Error())
+	}
+	s.audit(ctx, "service_account.create", slog.String("clientId", clientId), slog.String("name", req.Name), slog.Any("scopes", scopes))
+	return &svcDto.CreateServiceAccountResponse{
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		SecretId:     secrets[0].ServiceAccountSecretId.String(),
+		ExpirationAt: expirationAt,
+	}, nil
+}
+func (s *Service) UpdateServiceAccount(ctx context.Context, req *svcDto.UpdateServiceAccountRequest) (*svcDto.UpdateServiceAccountResponse, error) {
+	serviceAccount, err := s.getServiceAccount(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	dto := &repoDto.UpdateServiceAccount{
+		ServiceAccountId: serviceAccount.ServiceAccountId,
+		IsDisabled:       req.Disabled,
+	}
+	if req.Scopes != nil {
+		if dto.Scopes, err = s.parseScopes(req.Scopes, "service.UpdateServiceAccount"); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.store.UpdateServiceAccount(dto); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrServiceAccountNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "service_account.update", slog.String("clientId", req.ClientId), slog.Any("scopes", dto.Scopes), slog.Any("disabled", req.Disabled))
+	return &svcDto.UpdateServiceAccountResponse{}, nil
+}
+func (s *Service) DeleteServiceAccount(ctx context.Context, req *svcDto.DeleteServiceAccountRequest) (*svcDto.DeleteServiceAccountResponse, error) {
+	serviceAccount, err := s.getServiceAccount(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.RemoveServiceAccount(serviceAccount.ServiceAccountId); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrServiceAccountNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "service_account.delete", slog.String("clientId", req.ClientId))
+	return &svcDto.DeleteServiceAccountResponse{}, nil
+}
+
+// ListServiceAccounts returns the accounts with the unexpired secrets, the values of the secrets are never returned
+func (s *Service) ListServiceAccounts(ctx context.Context, req *svcDto.ListServiceAccountsRequest) (*svcDto.ListServiceAccountsResponse, error) {
+	serviceAccounts, err := s.store.GetServiceAccounts(adminTenantId(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	now := time.Now()
+	resp := &svcDto.ListServiceAccountsResponse{ServiceAccounts: make([]*svcDto.ServiceAccount, 0, len(serviceAccounts))}
+	for _, serviceAccount := range serviceAccounts {
+		secrets, err := s.store.GetServiceAccountSecrets(serviceAccount.ServiceAccountId)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		serviceAccountDto := &svcDto.ServiceAccount{
+			ClientId:  serviceAccount.ClientId,
+			Name:      serviceAccount.Name,
+			Scopes:    serviceAccount.Scopes,
+			Disabled:  serviceAccount.IsDisabled,
+			Secrets:   []*svcDto.ServiceAccountSecret{},
+			CreatedAt: serviceAccount.CreatedAt,
+		}
+		for _, secret := range secrets {
+			if isSecretExpired(secret, now) {
+				continue
+			}
+			serviceAccountDto.Secrets = append(serviceAccountDto.Secrets, &svcDto.ServiceAccountSecret{
+				SecretId:     secret.ServiceAccountSecretId.String(),
+				ExpirationAt: secret.ExpirationAt,
+				CreatedAt:    secret.CreatedAt,
+			})
+		}
+		resp.ServiceAccounts = append(resp.ServiceAccounts, serviceAccountDto)
+	}
+	return resp, nil
+}
+
+// AddServiceAccountSecret adds one more secret, so that the clients can move to it before the old one is removed
+func (s *Service) AddServiceAccountSecret(ctx context.Context, req *svcDto.AddServiceAccountSecretRequest) (*svcDto.AddServiceAccountSecretResponse, error) {
+	serviceAccount, err := s.getServiceAccount(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	expirationAt, err := s.secretExpirationAt(req.SecretLifetime, "service.AddServiceAccountSecret")
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := s.store.GetServiceAccountSecrets(serviceAccount.ServiceAccountId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	now := time.Now()
+	unexpired := 0
+	for _, secret := range secrets {
+		if !isSecretExpired(secret, now) {
+			unexpired++
+		}
+	}
+	if unexpired >= s.serviceAccount.MaxSecrets {
+		s.lg.Error("too many unexpired secrets", slog.String("owner", "service.AddServiceAccountSecret"), slog.String("clientId", req.ClientId))
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrTooManySecrets.Error())
+	}
+	clientSecret, err := secure.GenerateToken(clientSecretSize)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.AddServiceAccountSecret"))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	secretId, err := s.store.AddServiceAccountSecret(&repoDto.AddServiceAccountSecret{
+		ServiceAccountId: serviceAccount.ServiceAccountId,
+		SecretHash:       secure.GetHash(clientSecret),
+		ExpirationAt:     expirationAt,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "service_account.secret.add", slog.String("clientId", req.ClientId), slog.Any("secretId", secretId))
+	return &svcDto.AddServiceAccountSecretResponse{
+		ClientSecret: clientSecret,
+		SecretId:     secretId.String(),
+		ExpirationAt: expirationAt,
+	}, nil
+}
+func (s *Service) RemoveServiceAccountSecret(ctx context.Context, req *svcDto.RemoveServiceAccountSecretRequest) (*svcDto.RemoveServiceAccountSecretResponse, error) {
+	serviceAccount, err := s.getServiceAccount(ctx, req.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	secretId, err := uuid.Parse(req.SecretId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.RemoveServiceAccountSecret"))
+		return nil, status.Error(codes.NotFound, svcErr.ErrSecretNotFound.Error())
+	}
+	if err := s.store.RemoveServiceAccountSecret(serviceAccount.ServiceAccountId, &secretId); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrSecretNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "service_account.secret.remove", slog.String("clientId", req.ClientId), slog.Any("secretId", secretId))
+	return &svcDto.RemoveServiceAccountSecretResponse{}, nil
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	evictionPolicyReject   = "reject"
+	evictionPolicyEvictLru = "evict-lru"
+)
+
+// maxDevices returns the limit of devices for the user, 0 is unlimited. The limit of the user overrides
+// the limits of the roles, the most generous role limit overrides the configured one
+func (s *Service) maxDevices(user *entity.User, roles []*entity.Role) int {
+	if user.MaxDevices != nil {
+		return *user.MaxDevices
+	}
+	maxDevices := -1
+	for _, role := range roles {
+		if role.MaxDevices == nil {
+			continue
+		}
+		if *role.MaxDevices == 0 {
+			return 0
+		}
+		maxDevices = max(maxDevices, *role.MaxDevices)
+	}
+	if maxDevices != -1 {
+		return maxDevices
+	}
+	return s.session.MaxDevices
+}
+
+// addDeviceRefreshToken saves the refresh token of a new login of the device. The previous tokens of the device are
+// revoked and room for the device is made according to the eviction policy, all with the insert of the token
+func (s *Service) addDeviceRefreshToken(ctx context.Context, user *entity.User, refreshToken *repoDto.AddRefreshTokenWithRefreshTokenId, owner string) error {
+	roles, err := s.store.GetRolesByUserId(user.UserId)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	maxDevices := s.maxDevices(user, roles)
+	evictedDeviceCodes, err := s.store.AddDeviceRefreshToken(&repoDto.AddDeviceRefreshToken{
+		RefreshToken: refreshToken,
+		MaxDevices:   maxDevices,
+		EvictLru:     s.session.EvictionPolicy == evictionPolicyEvictLru,
+	})
+	if err != nil {
+		if errors.Is(err, repoErr.ErrLimitExceeded) {
+			s.lg.Warn("maximum number of devices is reached", slog.String("owner", owner), slog.Any("userId", user.UserId), slog.Int("maxDevices", maxDevices))
+			return status.Error(codes.FailedPrecondition, svcErr.ErrTooManyDevices.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, deviceCode := range evictedDeviceCodes {
+		s.audit(ctx, "session.evict", slog.Any("userId", user.UserId), slog.String("deviceCode", deviceCode))
+	}
+	return nil
+}
+
+func (s *Service) ListSessions(ctx context.Context, req *svcDto.ListSessionsRequest) (*svcDto.ListSessionsResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.ListSessions"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	sessions, err := s.store.GetSessionsByUserId(&userId, time.Now())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// the sessions are ordered by device code
+	devices := []*svcDto.Device{}
+	for _, session := range sessions {
+		if len(devices) == 0 || devices[len(devices)-1].DeviceCode != session.DeviceCode {
+			devices = append(devices, &svcDto.Device{DeviceCode: session.DeviceCode})
+		}
+		device := devices[len(devices)-1]
+		device.Sessions = append(device.Sessions, &svcDto.Session{
+			SessionId:     session.SessionId.String(),
+			IssuedAt:      session.IssuedAt,
+			ExpirationAt:  session.ExpirationAt,
+			LastRefreshAt: session.LastRefreshAt,
+			LastUsedAt:    session.LastUsedAt,
+			ClientIp:      session.ClientIp,
+			UserAgent:     session.UserAgent,
+			ClientVersion: session.ClientVersion,
+		})
+	}
+	return &svcDto.ListSessionsResponse{Devices: devices}, nil
+}
+func (s *Service) RevokeSession(ctx context.Context, req *svcDto.RevokeSessionRequest) (*svcDto.RevokeSessionResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.RevokeSession"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	sessionId, err := uuid.Parse(req.SessionId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.RevokeSession"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentSessionId.Error())
+	}
+	if err := s.store.RevokeRefreshTokensBySessionId(&repoDto.RevokeRefreshTokensBySessionId{
+		UserId:    &userId,
+		SessionId: &sessionId,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrSessionNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "session.revoke", slog.Any("userId", userId), slog.Any("sessionId", sessionId))
+	return &svcDto.RevokeSessionResponse{}, nil
+}
+func (s *Service) RevokeAllOtherSessions(ctx context.Context, req *svcDto.RevokeAllOtherSessionsRequest) (*svcDto.RevokeAllOtherSessionsResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.RevokeAllOtherSessions"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	// the device of the access token of the call is kept, the request can not name another one
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, svcErr.ErrAccessTokenRequired.Error())
+	}
+	if claims.DeviceCode == "" {
+		s.lg.Error("invalid device code value", slog.String("owner", "service.RevokeAllOtherSessions"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentDeviceCode.Error())
+	}
+	revokedCount, err := s.store.RevokeRefreshTokensExceptDeviceCode(&repoDto.RevokeRefreshTokensExceptDeviceCode{
+		UserId:     &userId,
+		DeviceCode: claims.DeviceCode,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "session.revoke_others", slog.Any("userId", userId), slog.String("deviceCode", claims.DeviceCode), slog.Int64("revokedCount", revokedCount))
+	return &svcDto.RevokeAllOtherSessionsResponse{RevokedCount: revokedCount}, nil
+}
+func (s *Service) SetUserMaxDevices(ctx context.Context, req *svcDto.SetUserMaxDevicesRequest) (*svcDto.SetUserMaxDevicesResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.SetUserMaxDevices"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	if req.MaxDevices != nil && *req.MaxDevices < 0 {
+		s.lg.Error("invalid max devices value", slog.String("owner", "service.SetUserMaxDevices"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentMaxDevices.Error())
+	}
+	if err := s.store.UpdateUserMaxDevices(&repoDto.UpdateUserMaxDevices{
+		UserId:     &userId,
+		MaxDevices: req.MaxDevices,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "user.max_devices", slog.Any("userId", userId), slog.Any("maxDevices", req.MaxDevices))
+	return &svcDto.SetUserMaxDevicesResponse{}, nil
+}
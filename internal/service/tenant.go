@@ -0,0 +1,429 @@
+package service
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"ppAuthService/pkg/jwt"
+	"ppAuthService/pkg/password"
+	"ppAuthService/pkg/secure"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const tenantKey = "x-tenant"
+
+var tenantNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// the kids that are not in the store are remembered for a while, at most so many
+const (
+	unknownKeyIdLifetime = time.Minute
+	maxUnknownKeyIds     = 1024
+)
+
+// unknownKeyIds keeps the kids that are not in the store, so that the tokens with made up kids do not reach the store
+// on every call. The kid is the thumbprint of the key, so a new key never has a remembered kid
+type unknownKeyIds struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func (u *unknownKeyIds) contains(keyId string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	expiresAt, ok := u.entries[keyId]
+	return ok && time.Now().Before(expiresAt)
+}
+func (u *unknownKeyIds) add(keyId string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.entries == nil || len(u.entries) >= maxUnknownKeyIds {
+		u.entries = make(map[string]time.Time)
+	}
+	u.entries[keyId] = time.Now().Add(unknownKeyIdLifetime)
+}
+
+// tenantSigningKey is the decrypted own key of the tenant
+type tenantSigningKey struct {
+	tenantId   uuid.UUID
+	signingKey *jwt.SigningKey
+}
+
+// requestTenant returns the tenant named in the metadata, or the default tenant
+func (s *Service) requestTenant(ctx context.Context, owner string) (*entity.Tenant, error) {
+	name := metadataValue(ctx, tenantKey)
+	var (
+		tenant *entity.Tenant
+		err    error
+	)
+	if name == "" {
+		tenant, err = s.store.GetTenant(&entity.DefaultTenantId)
+	} else {
+		tenant, err = s.store.GetTenantByName(name)
+	}
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.lg.Error("tenant not found", slog.String("owner", owner), slog.String("tenant", name))
+			return nil, status.Error(codes.NotFound, svcErr.ErrTenantNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return tenant, nil
+}
+
+// checkTenantsAdmin lets only the admins of the default tenant manage the tenants
+func (s *Service) checkTenantsAdmin(ctx context.Context, owner string) error {
+	if *callerTenantId(ctx) != entity.DefaultTenantId {
+		s.lg.Warn("admin of the default tenant is required", slog.String("owner", owner))
+		return status.Error(codes.PermissionDenied, svcErr.ErrAdminRoleRequired.Error())
+	}
+	return nil
+}
+
+// getTenant returns the tenant of the user
+func (s *Service) getTenant(tenantId *uuid.UUID) (*entity.Tenant, error) {
+	tenant, err := s.store.GetTenant(tenantId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrTenantNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return tenant, nil
+}
+
+// tenantLogin is the login guard key of the login. The logins of the default tenant are kept as they are,
+// so the counters of the users registered before the tenants stay valid
+func tenantLogin(tenantId *uuid.UUID, login string) string {
+	if tenantId == nil || *tenantId == entity.DefaultTenantId {
+		return login
+	}
+	return tenantId.String() + "/" + login
+}
+
+// tokenLifetimes returns the access and refresh token lifetimes of the tenant
+func (s *Service) tokenLifetimes(tenant *entity.Tenant) (time.Duration, time.Duration) {
+	accessLifetime, refreshLifetime := s.accessLifetime, s.refrashLifetime
+	if tenant.AccessLifetime != nil {
+		accessLifetime = *tenant.AccessLifetime
+	}
+	if tenant.RefreshLifetime != nil {
+		refreshLifetime = *tenant.RefreshLifetime
+	}
+	return accessLifetime, refreshLifetime
+}
+
+// tenantPasswordPolicy applies the overrides of the tenant to the configured policy
+func (s *Service) tenantPasswordPolicy(tenant *entity.Tenant) *password.Policy {
+	if tenant == nil || tenant.PasswordPolicy == nil {
+		return s.passwordPolicy
+	}
+	policy := *s.passwordPolicy
+	overrides := tenant.PasswordPolicy
+	if overrides.MinLength != nil {
+		policy.MinLength = *overrides.MinLength
+	}
+	if overrides.MaxLength != nil {
+		policy.MaxLength = *overrides.MaxLength
+	}
+	if overrides.RequireLower != nil {
+		policy.RequireLower = *overrides.RequireLower
+	}
+	if overrides.RequireUpper != nil {
+		policy.RequireUpper = *overrides.RequireUpper
+	}
+	if overrides.RequireDigit != nil {
+		policy.RequireDigit = *overrides.RequireDigit
+	}
+	if overrides.RequireSymbol != nil {
+		policy.RequireSymbol = *overrides.RequireSymbol
+	}
+	if overrides.DisallowLogin != nil {
+		policy.DisallowLogin = *overrides.DisallowLogin
+	}
+	return &policy
+}
+
+// decryptTenantSigningKey returns the usable key. The keys never change, so the decrypted ones are kept by the id
+func (s *Service) decryptTenantSigningKey(stored *entity.TenantSigningKey) (*tenantSigningKey, error) {
+	if key, ok := s.tenantSigningKeys.Load(stored.KeyId); ok {
+		return key.(*tenantSigningKey), nil
+	}
+	if s.tenantKeyEncryptionKey == nil {
+		return nil, errors.New("tenant signing key encryption key is not configured")
+	}
+	privateKeyPem, err := secure.Decrypt(stored.PrivateKey, s.tenantKeyEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := secure.ParsePrivateKey([]byte(privateKeyPem))
+	if err != nil {
+		return nil, err
+	}
+	key := &tenantSigningKey{
+		tenantId:   *stored.TenantId,
+		signingKey: &jwt.SigningKey{Id: stored.KeyId, PrivateKey: privateKey},
+	}
+	s.tenantSigningKeys.Store(stored.KeyId, key)
+	return key, nil
+}
+
+// tokenSigningKey returns the newest own key of the tenant, the tenants without own keys use the key of the service
+func (s *Service) tokenSigningKey(tenant *entity.Tenant, owner string) (*jwt.SigningKey, error) {
+	storedKeys, err := s.store.GetTenantSigningKeysByTenantId(tenant.TenantId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if len(storedKeys) == 0 {
+		return s.signingKey, nil
+	}
+	key, err := s.decryptTenantSigningKey(storedKeys[0])
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner), slog.String("keyId", storedKeys[0].KeyId))
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return key.signingKey, nil
+}
+
+// publicKey finds the key of the token by its kid. The tokens issued before the kids were set are checked
+// with the key of the service
+func (s *Service) publicKey(keyId string) (*rsa.PublicKey, error) {
+	if keyId == "" || keyId == s.signingKey.Id {
+		return &s.signingKey.PrivateKey.PublicKey, nil
+	}
+	if s.unknownKeyIds.contains(keyId) {
+		return nil, fmt.Errorf("unknown signing key %q", keyId)
+	}
+	stored, err := s.store.GetTenantSigningKey(keyId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			s.unknownKeyIds.add(keyId)
+		}
+		return nil, fmt.Errorf("unknown signing key %q", keyId)
+	}
+	key, err := s.decryptTenantSigningKey(stored)
+	if err != nil {
+		return nil, err
+	}
+	return &key.signingKey.PrivateKey.PublicKey, nil
+}
+
+// ParseToken verifies the token issued by the service. A key of the tenant is accepted for the tokens of the tenant only
+func (s *Service) ParseToken(tokenString string) (*jwt.TokenClaims, error) {
+	claims, keyId, err := jwt.ParseToken(tokenString, s.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := s.tenantSigningKeys.Load(keyId); ok {
+		if claims.Tenant == nil || *claims.Tenant != key.(*tenantSigningKey).tenantId {
+			return nil, errors.New("token is signed with the key of another tenant")
+		}
+	}
+	return claims, nil
+}
+
+// parseTenantSettings validates the overrides of the tenant, empty lifetimes are not overridden
+func (s *Service) parseTenantSettings(accessLifetimeValue string, refreshLifetimeValue string, passwordPolicy *entity.TenantPasswordPolicy, owner string) (*time.Duration, *time.Duration, error) {
+	var lifetimes [2]*time.Duration
+	for i, value := range []string{accessLifetimeValue, refreshLifetimeValue} {
+		if value == "" {
+			continue
+		}
+		lifetime, err := time.ParseDuration(value)
+		if err != nil || lifetime < time.Second {
+			s.lg.Error("invalid lifetime value", slog.String("owner", owner), slog.String("lifetime", value))
+			return nil, nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentLifetime.Error())
+		}
+		lifetimes[i] = &lifetime
+	}
+	if passwordPolicy != nil {
+		policy := s.tenantPasswordPolicy(&entity.Tenant{PasswordPolicy: passwordPolicy})
+		if policy.MinLength < 1 || policy.MaxLength < policy.MinLength {
+			s.lg.Error("invalid password policy value", slog.String("owner", owner))
+			return nil, nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentPolicy.Error())
+		}
+	}
+	return lifetimes[0], lifetimes[1], nil
+}
+
+// addTenantSigningKey generates the new key of the tenant, it signs the tokens from now on. The previous keys
+// stay in the JWKS of the tenant, so the tokens signed with them stay valid until they expire
+func (s *Service) addTenantSigningKey(tenantId *uuid.UUID, owner string) (string, error) {
+	if s.tenantKeyEncryptionKey == nil {
+		return "", status.Error(codes.FailedPrecondition, svcErr.ErrTenantKeysNotConfigured.Error())
+	}
+	privateKey, privateKeyPem, err := secure.GeneratePrivateKey(s.tenant.SigningKeyBits)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	encryptedPrivateKey, err := secure.Encrypt(string(privateKeyPem), s.tenantKeyEncryptionKey)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", owner))
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	keyId := jwt.KeyId(&privateKey.PublicKey)
+	if err := s.store.AddTenantSigningKey(&repoDto.AddTenantSigningKey{
+		KeyId:      keyId,
+		TenantId:   tenantId,
+		PrivateKey: encryptedPrivateKey,
+	}); err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	return keyId, nil
+}
+
+func toTenantDto(tenant *entity.Tenant) *svcDto.Tenant {
+	t := &svcDto.Tenant{
+		TenantId:       tenant.TenantId.String(),
+		Name:           tenant.Name,
+		PasswordPolicy: tenant.PasswordPolicy,
+		CreatedAt:      tenant.CreatedAt,
+	}
+	if tenant.AccessLifetime != nil {
+		t.AccessLifetime = tenant.AccessLifetime.String()
+	}
+	if tenant.RefreshLifetime != nil {
+		t.RefreshLifetime = tenant.RefreshLifetime.String()
+	}
+	return t
+}
+
+func (s *Service) CreateTenant(ctx context.Context, req *svcDto.CreateTenantRequest) (*svcDto.CreateTenantResponse, error) {
+	if err := s.checkTenantsAdmin(ctx, "service.CreateTenant"); err != nil {
+		return nil, err
+	}
+	if !tenantNameRegexp.MatchString(req.Name) {
+		s.lg.Error("invalid tenant name value", slog.String("owner", "service.CreateTenant"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentTenant.Error())
+	}
+	accessLifetime, refreshLifetime, err := s.parseTenantSettings(req.AccessLifetime, req.RefreshLifetime, req.PasswordPolicy, "service.CreateTenant")
+	if err != nil {
+		return nil, err
+	}
+	if req.OwnSigningKey && s.tenantKeyEncryptionKey == nil {
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrTenantKeysNotConfigured.Error())
+	}
+	tenantId, err := s.store.AddTenant(&repoDto.AddTenant{
+		Name:            req.Name,
+		AccessLifetime:  accessLifetime,
+		RefreshLifetime: refreshLifetime,
+		PasswordPolicy:  req.PasswordPolicy,
+	})
+	if err != nil {
+		if errors.Is(err, repoErr.ErrUniqueViolation) {
+			return nil, status.Error(codes.AlreadyExists, svcErr.ErrTenantAlreadyExists.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.CreateTenantResponse{TenantId: tenantId.String()}
+	if req.OwnSigningKey {
+		if resp.KeyId, err = s.addTenantSigningKey(tenantId, "service.CreateTenant"); err != nil {
+			return nil, err
+		}
+	}
+	s.audit(ctx, "tenant.create", slog.Any("tenantId", tenantId), slog.String("tenant", req.Name), slog.String("keyId", resp.KeyId))
+	return resp, nil
+}
+func (s *Service) UpdateTenant(ctx context.Context, req *svcDto.UpdateTenantRequest) (*svcDto.UpdateTenantResponse, error) {
+	if err := s.checkTenantsAdmin(ctx, "service.UpdateTenant"); err != nil {
+		return nil, err
+	}
+	accessLifetime, refreshLifetime, err := s.parseTenantSettings(req.AccessLifetime, req.RefreshLifetime, req.PasswordPolicy, "service.UpdateTenant")
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := s.store.GetTenantByName(req.Name)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrTenantNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.store.UpdateTenant(&repoDto.UpdateTenant{
+		TenantId:        tenant.TenantId,
+		AccessLifetime:  accessLifetime,
+		RefreshLifetime: refreshLifetime,
+		PasswordPolicy:  req.PasswordPolicy,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrTenantNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.audit(ctx, "tenant.update", slog.Any("tenantId", tenant.TenantId), slog.String("tenant", tenant.Name))
+	return &svcDto.UpdateTenantResponse{}, nil
+}
+func (s *Service) ListTenants(ctx context.Context, req *svcDto.ListTenantsRequest) (*svcDto.ListTenantsResponse, error) {
+	if err := s.checkTenantsAdmin(ctx, "service.ListTenants"); err != nil {
+		return nil, err
+	}
+	tenants, err := s.store.GetTenants()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &svcDto.ListTenantsResponse{Tenants: make([]*svcDto.Tenant, 0, len(tenants))}
+	for _, tenant := range tenants {
+		resp.Tenants = append(resp.Tenants, toTenantDto(tenant))
+	}
+	return resp, nil
+}
+func (s *Service) RotateTenantSigningKey(ctx context.Context, req *svcDto.RotateTenantSigningKeyRequest) (*svcDto.RotateTenantSigningKeyResponse, error) {
+	if err := s.checkTenantsAdmin(ctx, "service.RotateTenantSigningKey"); err != nil {
+		return nil, err
+	}
+	tenant, err := s.store.GetTenantByName(req.Name)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrTenantNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	keyId, err := s.addTenantSigningKey(tenant.TenantId, "service.RotateTenantSigningKey")
+	if err != nil {
+		return nil, err
+	}
+	s.audit(ctx, "tenant.key.rotate", slog.Any("tenantId", tenant.TenantId), slog.String("tenant", tenant.Name), slog.String("keyId", keyId))
+	return &svcDto.RotateTenantSigningKeyResponse{KeyId: keyId}, nil
+}
+
+// TenantJwks returns the public keys the tokens of the tenant are verified with: the own keys of the tenant,
+// or the key of the service for the tenants without them
+func (s *Service) TenantJwks(name string) ([]*jwt.Jwk, error) {
+	tenant, err := s.store.GetTenantByName(name)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrTenantNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	storedKeys, err := s.store.GetTenantSigningKeysByTenantId(tenant.TenantId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if len(storedKeys) == 0 {
+		return []*jwt.Jwk{jwt.NewJwk(s.signingKey.Id, &s.signingKey.PrivateKey.PublicKey)}, nil
+	}
+	jwks := make([]*jwt.Jwk, 0, len(storedKeys))
+	for _, storedKey := range storedKeys {
+		key, err := s.decryptTenantSigningKey(storedKey)
+		if err != nil {
+			s.lg.Error(err.Error(), slog.String("owner", "service.TenantJwks"), slog.String("keyId", storedKey.KeyId))
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		jwks = append(jwks, jwt.NewJwk(key.signingKey.Id, &key.signingKey.PrivateKey.PublicKey))
+	}
+	return jwks, nil
+}
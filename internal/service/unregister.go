@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	loginPolicyReserve = "reserve"
+	loginPolicyFree    = "free"
+)
+
+// deleteUser marks the user as deleted and logs it out. The user is purged after the retention period
+func (s *Service) deleteUser(ctx context.Context, user *entity.User, reason string, owner string) error {
+	if err := s.store.DeleteUser(&repoDto.DeleteUser{
+		UserId:    user.UserId,
+		OldStatus: user.Status,
+		Reason:    reason,
+		FreeLogin: s.unregister.LoginPolicy == loginPolicyFree,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			// the status was changed concurrently
+			return status.Error(codes.Aborted, svcErr.ErrStatusTransition.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := s.store.RevokeRefreshTokensByUserIdAndDeviceCode(&repoDto.RevokeRefreshTokensByUserIdAndDeviceCode{
+		UserId:     user.UserId,
+		DeviceCode: nil,
+	}); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	s.decisionCache.invalidateUser(*user.UserId)
+	s.audit(ctx, "user.delete", slog.Any("userId", user.UserId), slog.String("oldStatus", user.Status), slog.String("reason", reason), slog.Time("purgeAfter", time.Now().Add(s.unregister.RetentionPeriod)))
+	return nil
+}
+
+func (s *Service) RestoreUser(ctx context.Context, req *svcDto.RestoreUserRequest) (*svcDto.RestoreUserResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.RestoreUser"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	if req.Reason == "" {
+		s.lg.Error("reason is required", slog.String("owner", "service.RestoreUser"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentReason.Error())
+	}
+	user, err := s.store.GetUser(&userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user.Status != entity.UserStatusDeleted || user.DeletedAt == nil {
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrStatusTransition.Error())
+	}
+	// the purge may not have run yet
+	if time.Since(*user.DeletedAt) > s.unregister.RetentionPeriod {
+		return nil, status.Error(codes.FailedPrecondition, svcErr.ErrRestoreWindowExpired.Error())
+	}
+	if err := s.store.RestoreUser(&repoDto.RestoreUser{
+		UserId: &userId,
+		Reason: req.Reason,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrUniqueViolation) {
+			return nil, status.Error(codes.AlreadyExists, svcErr.ErrLoginAlreadyExists.Error())
+		}
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.Aborted, svcErr.ErrStatusTransition.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.decisionCache.invalidateUser(userId)
+	s.audit(ctx, "user.restore", slog.Any("userId", userId), slog.String("reason", req.Reason))
+	return &svcDto.RestoreUserResponse{}, nil
+}
+
+// PurgeDeletedUsers removes the users deleted longer than the retention period ago, with all their data
+func (s *Service) PurgeDeletedUsers() (int64, error) {
+	return s.store.RemoveUsersByDeletedAt(time.Now().Add(-s.unregister.RetentionPeriod))
+}
+
+// RemoveExpiredRefreshTokens removes the refresh tokens that can not be used anymore
+func (s *Service) RemoveExpiredRefreshTokens() (int64, error) {
+	return s.store.RemoveRefreshTokensByExpirationAt(time.Now())
+}
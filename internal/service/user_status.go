@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"ppAuthService/internal/entity"
+	repoDto "ppAuthService/internal/repository/dto"
+	repoErr "ppAuthService/internal/repository/err"
+	svcDto "ppAuthService/internal/service/dto"
+	svcErr "ppAuthService/internal/service/err"
+	"slices"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userStatusTransitions lists the statuses each status may be changed to
+var userStatusTransitions = map[string][]string{
+	entity.UserStatusPending:  {entity.UserStatusActive, entity.UserStatusDisabled, entity.UserStatusDeleted},
+	entity.UserStatusActive:   {entity.UserStatusLocked, entity.UserStatusDisabled, entity.UserStatusDeleted},
+	entity.UserStatusLocked:   {entity.UserStatusActive, entity.UserStatusDisabled, entity.UserStatusDeleted},
+	entity.UserStatusDisabled: {entity.UserStatusActive, entity.UserStatusDeleted},
+	entity.UserStatusDeleted:  {},
+}
+
+// checkUserStatus refuses the users that are not active, each status with its own error
+func (s *Service) checkUserStatus(user *entity.User, owner string) error {
+	if user.Status == entity.UserStatusActive {
+		return nil
+	}
+	s.lg.Warn("user is not active", slog.String("owner", owner), slog.Any("userId", user.UserId), slog.String("status", user.Status))
+	switch user.Status {
+	case entity.UserStatusPending:
+		return status.Error(codes.FailedPrecondition, svcErr.ErrUserPending.Error())
+	case entity.UserStatusLocked:
+		return status.Error(codes.PermissionDenied, svcErr.ErrUserLocked.Error())
+	case entity.UserStatusDisabled:
+		return status.Error(codes.PermissionDenied, svcErr.ErrUserDisabled.Error())
+	case entity.UserStatusDeleted:
+		return status.Error(codes.NotFound, svcErr.ErrUserDeleted.Error())
+	}
+	return status.Error(codes.Internal, svcErr.ErrInternalServerError.Error())
+}
+
+// setUserStatus changes the status of the user if the transition is allowed. Leaving the active status logs the user out
+func (s *Service) setUserStatus(ctx context.Context, user *entity.User, newStatus string, reason string, owner string) error {
+	if !slices.Contains(userStatusTransitions[user.Status], newStatus) {
+		s.lg.Error("user status transition is not allowed", slog.String("owner", owner), slog.String("oldStatus", user.Status), slog.String("newStatus", newStatus))
+		return status.Error(codes.FailedPrecondition, svcErr.ErrStatusTransition.Error())
+	}
+	if newStatus == entity.UserStatusDeleted {
+		return s.deleteUser(ctx, user, reason, owner)
+	}
+	if err := s.store.UpdateUserStatus(&repoDto.UpdateUserStatus{
+		UserId:    user.UserId,
+		OldStatus: user.Status,
+		NewStatus: newStatus,
+		Reason:    reason,
+	}); err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			// the status was changed concurrently
+			return status.Error(codes.Aborted, svcErr.ErrStatusTransition.Error())
+		}
+		return status.Error(codes.Internal, err.Error())
+	}
+	if newStatus != entity.UserStatusActive {
+		if err := s.store.RevokeRefreshTokensByUserIdAndDeviceCode(&repoDto.RevokeRefreshTokensByUserIdAndDeviceCode{
+			UserId:     user.UserId,
+			DeviceCode: nil,
+		}); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	s.decisionCache.invalidateUser(*user.UserId)
+	s.audit(ctx, "user.status", slog.Any("userId", user.UserId), slog.String("oldStatus", user.Status), slog.String("newStatus", newStatus), slog.String("reason", reason))
+	return nil
+}
+
+func (s *Service) SetUserStatus(ctx context.Context, req *svcDto.SetUserStatusRequest) (*svcDto.SetUserStatusResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.SetUserStatus"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	if _, ok := userStatusTransitions[req.Status]; !ok {
+		s.lg.Error("invalid user status value", slog.String("owner", "service.SetUserStatus"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentStatus.Error())
+	}
+	if req.Reason == "" {
+		s.lg.Error("reason is required", slog.String("owner", "service.SetUserStatus"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentReason.Error())
+	}
+	user, err := s.store.GetUser(&userId)
+	if err != nil {
+		if errors.Is(err, repoErr.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, svcErr.ErrUserNotFound.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.setUserStatus(ctx, user, req.Status, req.Reason, "service.SetUserStatus"); err != nil {
+		return nil, err
+	}
+	return &svcDto.SetUserStatusResponse{}, nil
+}
+func (s *Service) GetUserStatusHistory(ctx context.Context, req *svcDto.GetUserStatusHistoryRequest) (*svcDto.GetUserStatusHistoryResponse, error) {
+	userId, err := uuid.Parse(req.UserId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "service.GetUserStatusHistory"))
+		return nil, status.Error(codes.InvalidArgument, svcErr.ErrInvalidArgumentUserId.Error())
+	}
+	history, err := s.store.GetUserStatusHistory(&userId)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	changes := make([]*svcDto.UserStatusChange, 0, len(history))
+	for _, entry := range history {
+		changes = append(changes, &svcDto.UserStatusChange{
+			OldStatus: entry.OldStatus,
+			NewStatus: entry.NewStatus,
+			Reason:    entry.Reason,
+			ChangedAt: entry.ChangedAt,
+		})
+	}
+	return &svcDto.GetUserStatusHistoryResponse{Changes: changes}, nil
+}
@@ -21,24 +21,207 @@ import (
 )
 
 const (
+	userFields   = `user_id,login,password,max_devices,status,status_changed_at,deleted_at,email,email_verified_at,pending_email,tenant_id,created_at`
 	addUserQuery = `
-INSERT INTO "user" (login,password) 
-VALUES ($1, $2) RETURNING user_id;`
+INSERT INTO "user" (tenant_id,login,password,email) 
+VALUES ($1, $2, $3, $4) RETURNING user_id;`
+	getUserQuery = `
+SELECT ` + userFields + ` FROM "user" 
+WHERE user_id=$1;`
 	getUserByLoginQuery = `
-SELECT * FROM "user" 
-WHERE login=$1;`
+SELECT ` + userFields + ` FROM "user" 
+WHERE tenant_id=$1 AND login=$2;`
+	updateUserMaxDevicesQuery = `
+UPDATE "user" SET max_devices=$2
+WHERE user_id=$1
+RETURNING user_id;`
+	updateUserPendingEmailQuery = `
+UPDATE "user" SET pending_email=$2
+WHERE user_id=$1
+RETURNING user_id;`
+	verifyUserEmailQuery = `
+UPDATE "user" SET email=$2, email_verified_at=now(),
+pending_email = CASE WHEN pending_email=$2 THEN NULL ELSE pending_email END
+WHERE user_id=$1 AND (pending_email=$2 OR (email=$2 AND email_verified_at IS NULL))
+RETURNING user_id;`
+	updateUserStatusQuery = `
+UPDATE "user" SET status=$3, status_changed_at=now()
+WHERE user_id=$1 AND status=$2
+RETURNING user_id;`
+	addUserStatusHistoryQuery = `
+INSERT INTO user_status_history (user_id,old_status,new_status,reason)
+VALUES ($1,$2,$3,$4);`
+	getUserStatusHistoryQuery = `
+SELECT user_status_history_id,user_id,old_status,new_status,reason,changed_at FROM user_status_history
+WHERE user_id=$1
+ORDER BY changed_at;`
 	updateUserQuery = `
 UPDATE "user" SET 
 login = CASE WHEN $2::character varying IS NULL THEN login ELSE $2 END,
 password = CASE WHEN $3::character varying IS NULL THEN password ELSE $3 END
 WHERE user_id=$1
 RETURNING user_id;`
-	removeUserQuery = `
-DELETE FROM "user" WHERE user_id=$1 RETURNING user_id;`
+	deleteUserQuery = `
+UPDATE "user" SET status='deleted', status_changed_at=now(), deleted_at=now(), deleted_login=login, deleted_status=status,
+login = CASE WHEN $3 THEN login || ':deleted:' || user_id ELSE login END
+WHERE user_id=$1 AND status=$2
+RETURNING user_id;`
+	existsDeletedLoginQuery = `
+SELECT EXISTS (SELECT 1 FROM "user" u JOIN "user" o ON o.tenant_id=u.tenant_id AND o.login=u.deleted_login
+WHERE u.user_id=$1 AND o.user_id<>u.user_id);`
+	restoreUserQuery = `
+UPDATE "user" SET status=COALESCE(deleted_status,'active'), status_changed_at=now(), deleted_at=NULL,
+login=deleted_login, deleted_login=NULL, deleted_status=NULL
+WHERE user_id=$1 AND status='deleted'
+RETURNING status;`
+	removeUsersByDeletedAtQuery = `
+DELETE FROM "user"
+WHERE status='deleted' AND deleted_at < $1;`
+	listUsersQuery = `
+SELECT ` + userFields + ` FROM "user"
+WHERE ($1::uuid IS NULL OR tenant_id=$1) AND ($2::character varying IS NULL OR starts_with(login,$2))
+AND ($3::character varying IS NULL OR status=$3)
+AND ($4::timestamptz IS NULL OR created_at >= $4) AND ($5::timestamptz IS NULL OR created_at < $5)
+AND ($6::timestamptz IS NULL OR (created_at,user_id) < ($6,$7::uuid))
+ORDER BY created_at DESC, user_id DESC
+LIMIT $8;`
+	getUserStatsQuery = `
+SELECT count(*),
+count(*) FILTER (WHERE u.status='pending'),
+count(*) FILTER (WHERE u.status='active'),
+count(*) FILTER (WHERE u.status='locked'),
+count(*) FILTER (WHERE u.status='disabled'),
+count(*) FILTER (WHERE u.status='deleted'),
+count(*) FILTER (WHERE u.email_verified_at IS NOT NULL),
+count(*) FILTER (WHERE m.is_confirmed),
+count(*) FILTER (WHERE u.created_at >= $2::timestamptz - interval '1 day'),
+count(*) FILTER (WHERE u.created_at >= $2::timestamptz - interval '7 days')
+FROM "user" u
+LEFT JOIN user_mfa m ON m.user_id=u.user_id
+WHERE ($1::uuid IS NULL OR u.tenant_id=$1);`
+	tenantFields   = `tenant_id,name,access_lifetime_seconds,refresh_lifetime_seconds,password_policy,created_at`
+	addTenantQuery = `
+INSERT INTO tenant (name,access_lifetime_seconds,refresh_lifetime_seconds,password_policy)
+VALUES ($1,$2,$3,$4) RETURNING tenant_id;`
+	getTenantQuery = `
+SELECT ` + tenantFields + ` FROM tenant
+WHERE tenant_id=$1;`
+	getTenantByNameQuery = `
+SELECT ` + tenantFields + ` FROM tenant
+WHERE name=$1;`
+	getTenantsQuery = `
+SELECT ` + tenantFields + ` FROM tenant
+ORDER BY name;`
+	updateTenantQuery = `
+UPDATE tenant SET access_lifetime_seconds=$2, refresh_lifetime_seconds=$3, password_policy=$4
+WHERE tenant_id=$1
+RETURNING tenant_id;`
+	addTenantSigningKeyQuery = `
+INSERT INTO tenant_signing_key (key_id,tenant_id,private_key)
+VALUES ($1,$2,$3);`
+	getTenantSigningKeyQuery = `
+SELECT key_id,tenant_id,private_key,created_at FROM tenant_signing_key
+WHERE key_id=$1;`
+	getTenantSigningKeysQuery = `
+SELECT key_id,tenant_id,private_key,created_at FROM tenant_signing_key
+ORDER BY tenant_id,created_at DESC;`
+	getTenantSigningKeysByTenantIdQuery = `
+SELECT key_id,tenant_id,private_key,created_at FROM tenant_signing_key
+WHERE tenant_id=$1
+ORDER BY created_at DESC;`
+	serviceAccountFields   = `service_account_id,tenant_id,client_id,name,scopes,is_disabled,created_at`
+	addServiceAccountQuery = `
+INSERT INTO service_account (tenant_id,client_id,name,scopes)
+VALUES ($1,$2,$3,$4) RETURNING service_account_id;`
+	getServiceAccountQuery = `
+SELECT ` + serviceAccountFields + ` FROM service_account
+WHERE service_account_id=$1;`
+	getServiceAccountByClientIdQuery = `
+SELECT ` + serviceAccountFields + ` FROM service_account
+WHERE client_id=$1;`
+	getServiceAccountsQuery = `
+SELECT ` + serviceAccountFields + ` FROM service_account
+WHERE tenant_id=$1
+ORDER BY name,client_id;`
+	updateServiceAccountQuery = `
+UPDATE service_account SET
+scopes = CASE WHEN $2::character varying[] IS NULL THEN scopes ELSE $2 END,
+is_disabled = CASE WHEN $3::boolean IS NULL THEN is_disabled ELSE $3 END
+WHERE service_account_id=$1
+RETURNING service_account_id;`
+	removeServiceAccountQuery = `
+DELETE FROM service_account WHERE service_account_id=$1 RETURNING service_account_id;`
+	addServiceAccountSecretQuery = `
+INSERT INTO service_account_secret (service_account_id,secret_hash,expiration_at)
+VALUES ($1,$2,$3) RETURNING service_account_secret_id;`
+	getServiceAccountSecretsQuery = `
+SELECT service_account_secret_id,service_account_id,secret_hash,expiration_at,created_at FROM service_account_secret
+WHERE service_account_id=$1
+ORDER BY created_at;`
+	removeServiceAccountSecretQuery = `
+DELETE FROM service_account_secret WHERE service_account_id=$1 AND service_account_secret_id=$2
+RETURNING service_account_secret_id;`
+	apiKeyFields   = `api_key_id,user_id,service_account_id,name,prefix,key_hash,scopes,expiration_at,last_used_at,created_at`
+	addApiKeyQuery = `
+INSERT INTO api_key (user_id,service_account_id,name,prefix,key_hash,scopes,expiration_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING api_key_id;`
+	getApiKeyByPrefixQuery = `
+SELECT ` + apiKeyFields + ` FROM api_key
+WHERE prefix=$1;`
+	getApiKeysByOwnerQuery = `
+SELECT ` + apiKeyFields + ` FROM api_key
+WHERE ($1::uuid IS NULL OR user_id=$1) AND ($2::uuid IS NULL OR service_account_id=$2)
+ORDER BY created_at DESC;`
+	updateApiKeyLastUsedAtQuery = `
+UPDATE api_key SET last_used_at=$2 WHERE api_key_id=$1;`
+	removeApiKeyQuery = `
+DELETE FROM api_key WHERE api_key_id=$1 RETURNING api_key_id;`
+	oauthClientFields   = `oauth_client_id,tenant_id,client_id,name,client_type,secret_hash,redirect_uris,scopes,created_at`
+	addOAuthClientQuery = `
+INSERT INTO oauth_client (tenant_id,client_id,name,client_type,secret_hash,redirect_uris,scopes)
+VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING oauth_client_id;`
+	getOAuthClientByClientIdQuery = `
+SELECT ` + oauthClientFields + ` FROM oauth_client
+WHERE client_id=$1;`
+	getOAuthClientsQuery = `
+SELECT ` + oauthClientFields + ` FROM oauth_client
+WHERE tenant_id=$1
+ORDER BY name,client_id;`
+	updateOAuthClientQuery = `
+UPDATE oauth_client SET
+redirect_uris = CASE WHEN $2::character varying[] IS NULL THEN redirect_uris ELSE $2 END,
+scopes = CASE WHEN $3::character varying[] IS NULL THEN scopes ELSE $3 END
+WHERE oauth_client_id=$1
+RETURNING oauth_client_id;`
+	removeOAuthClientQuery = `
+DELETE FROM oauth_client WHERE oauth_client_id=$1 RETURNING oauth_client_id;`
+	revokeRefreshTokensByDeviceCodeQuery = `
+UPDATE refresh_token 
+SET is_revoke=true
+WHERE device_code=$1 AND is_revoke=false;`
+	addOAuthAuthorizationCodeQuery = `
+INSERT INTO oauth_authorization_code (code_hash,oauth_client_id,user_id,redirect_uri,redirect_uri_sent,scopes,code_challenge,nonce,acr,auth_time,expiration_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11);`
+	takeOAuthAuthorizationCodeQuery = `
+DELETE FROM oauth_authorization_code WHERE code_hash=$1
+RETURNING code_hash,oauth_client_id,user_id,redirect_uri,redirect_uri_sent,scopes,code_challenge,nonce,acr,auth_time,expiration_at,created_at;`
+	removeOAuthAuthorizationCodesByExpirationAtQuery = `
+DELETE FROM oauth_authorization_code
+WHERE expiration_at < $1;`
 	addRefreshTokenWithRefreshTokenIdQuery = `
-INSERT INTO refresh_token VALUES ($1,$2,$3,$4,$5);`
+INSERT INTO refresh_token (refresh_token_id,user_id,device_code,expiration_at,is_revoke,session_id,issued_at,client_ip,user_agent,client_version,last_used_at,scopes)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12);`
+	lockUserQuery = `
+SELECT user_id FROM "user"
+WHERE user_id=$1
+FOR UPDATE;`
+	getActiveDeviceCodesQuery = `
+SELECT device_code FROM refresh_token
+WHERE user_id=$1 AND is_revoke=false AND expiration_at > now() AND device_code<>$2
+GROUP BY device_code
+ORDER BY max(last_used_at);`
 	getRefreshTokenQuery = `
-SELECT * FROM refresh_token 
+SELECT refresh_token_id,user_id,device_code,expiration_at,is_revoke,session_id,issued_at,created_at,client_ip,user_agent,client_version,last_used_at,scopes FROM refresh_token 
 WHERE refresh_token_id=$1;`
 	revokeRefreshTokensByUserIdAndDeviceCodeQuery = `
 UPDATE refresh_token 
@@ -46,11 +229,206 @@ SET is_revoke=true
 WHERE user_id=$1 AND ($2::character varying IS NULL OR device_code=$2);`
 	revokeRefreshTokenByRefreshTokenIdQuery = `
 UPDATE refresh_token 
-SET is_revoke=true
+SET is_revoke=true, last_used_at=now()
 WHERE refresh_token_id = $1;`
 	removeRefreshTokensByExpirationAtQuery = `
 DELETE FROM refresh_token
 WHERE expiration_at < $1;`
+	getSessionsByUserIdQuery = `
+SELECT session_id,user_id,device_code,issued_at,expiration_at,created_at,client_ip,user_agent,client_version,last_used_at FROM refresh_token
+WHERE user_id=$1 AND is_revoke=false AND expiration_at > $2
+ORDER BY device_code, issued_at;`
+	revokeRefreshTokensBySessionIdQuery = `
+UPDATE refresh_token 
+SET is_revoke=true
+WHERE user_id=$1 AND session_id=$2 AND is_revoke=false;`
+	revokeRefreshTokensExceptDeviceCodeQuery = `
+UPDATE refresh_token 
+SET is_revoke=true
+WHERE user_id=$1 AND device_code<>$2 AND is_revoke=false;`
+	addPasswordResetTokenQuery = `
+INSERT INTO password_reset_token (user_id,token_hash,expiration_at)
+VALUES ($1,$2,$3);`
+	resetPasswordByTokenQuery = `
+WITH token AS (
+UPDATE password_reset_token
+SET used_at=now()
+WHERE token_hash=$1 AND user_id=$2 AND used_at IS NULL AND expiration_at > $3
+RETURNING user_id)
+UPDATE "user" SET password=$4
+FROM token
+WHERE "user".user_id=token.user_id
+RETURNING "user".user_id;`
+	getPasswordResetTokenQuery = `
+SELECT password_reset_token_id,user_id,token_hash,expiration_at,created_at,used_at FROM password_reset_token
+WHERE token_hash=$1 AND used_at IS NULL AND expiration_at > $2;`
+	removePasswordResetTokensByUserIdQuery = `
+DELETE FROM password_reset_token
+WHERE user_id=$1 AND used_at IS NULL;`
+	addEmailVerificationTokenQuery = `
+INSERT INTO email_verification_token (user_id,email,token_hash,expiration_at)
+VALUES ($1,$2,$3,$4);`
+	removeEmailVerificationTokensQuery = `
+DELETE FROM email_verification_token
+WHERE user_id=$1 AND used_at IS NULL;`
+	getLastEmailVerificationTokenCreatedAtQuery = `
+SELECT max(created_at) FROM email_verification_token
+WHERE user_id=$1;`
+	useEmailVerificationTokenQuery = `
+UPDATE email_verification_token
+SET used_at=now()
+WHERE token_hash=$1 AND used_at IS NULL AND expiration_at > $2
+RETURNING email_verification_token_id,user_id,email,token_hash,expiration_at,created_at,used_at;`
+	addPasswordHistoryQuery = `
+INSERT INTO password_history (user_id,password)
+VALUES ($1,$2);`
+	trimPasswordHistoryQuery = `
+DELETE FROM password_history
+WHERE user_id=$1 AND password_history_id NOT IN (
+SELECT password_history_id FROM password_history
+WHERE user_id=$1
+ORDER BY created_at DESC
+LIMIT $2);`
+	getPasswordHistoryQuery = `
+SELECT password FROM password_history
+WHERE user_id=$1
+ORDER BY created_at DESC
+LIMIT $2;`
+	addRoleQuery = `
+INSERT INTO role (tenant_id,name,description,max_devices)
+VALUES ($1,$2,$3,$4) RETURNING role_id;`
+	getRolesQuery = `
+SELECT r.role_id,r.name,r.description,r.max_devices,r.created_at,
+COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}') FROM role r
+LEFT JOIN role_permission rp ON rp.role_id=r.role_id
+LEFT JOIN permission p ON p.permission_id=rp.permission_id
+WHERE r.tenant_id=$1
+GROUP BY r.role_id
+ORDER BY r.name;`
+	removeRoleQuery = `
+DELETE FROM role WHERE tenant_id=$1 AND name=$2 RETURNING role_id;`
+	addPermissionQuery = `
+INSERT INTO permission (tenant_id,name,description)
+VALUES ($1,$2,$3) RETURNING permission_id;`
+	getPermissionsQuery = `
+SELECT permission_id,name,description,created_at FROM permission
+WHERE tenant_id=$1
+ORDER BY name;`
+	removePermissionQuery = `
+DELETE FROM permission WHERE tenant_id=$1 AND name=$2 RETURNING permission_id;`
+	addRolePermissionQuery = `
+INSERT INTO role_permission (role_id,permission_id,condition)
+SELECT r.role_id,p.permission_id,$4 FROM role r, permission p
+WHERE r.tenant_id=$1 AND r.name=$2 AND p.tenant_id=$1 AND p.name=$3
+ON CONFLICT (role_id,permission_id) DO UPDATE SET condition=EXCLUDED.condition
+RETURNING role_id;`
+	existsUserTenantRoleQuery = `
+SELECT EXISTS (SELECT 1 FROM role r JOIN "user" u ON u.tenant_id=r.tenant_id WHERE u.user_id=$1 AND r.name=$2);`
+	removeRolePermissionQuery = `
+DELETE FROM role_permission
+WHERE role_id=(SELECT role_id FROM role WHERE tenant_id=$1 AND name=$2)
+AND permission_id=(SELECT permission_id FROM permission WHERE tenant_id=$1 AND name=$3)
+RETURNING role_id;`
+	addUserRoleQuery = `
+INSERT INTO user_role (user_id,role_id)
+SELECT u.user_id,r.role_id FROM "user" u
+JOIN role r ON r.tenant_id=u.tenant_id
+WHERE u.user_id=$1 AND r.name=$2
+ON CONFLICT DO NOTHING
+RETURNING role_id;`
+	removeUserRoleQuery = `
+DELETE FROM user_role
+WHERE user_id=$1 AND role_id=(SELECT r.role_id FROM role r JOIN "user" u ON u.tenant_id=r.tenant_id WHERE u.user_id=$1 AND r.name=$2)
+RETURNING role_id;`
+	getRolesByUserIdQuery = `
+SELECT r.role_id,r.name,r.description,r.max_devices,r.created_at,
+COALESCE(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}') FROM user_role ur
+JOIN "user" u ON u.user_id=ur.user_id
+JOIN role r ON r.role_id=ur.role_id AND r.tenant_id=u.tenant_id
+LEFT JOIN role_permission rp ON rp.role_id=r.role_id
+LEFT JOIN permission p ON p.permission_id=rp.permission_id AND p.tenant_id=r.tenant_id
+WHERE ur.user_id=$1
+GROUP BY r.role_id
+ORDER BY r.name;`
+	getGrantsByUserIdQuery = `
+SELECT r.name,p.name,rp.condition FROM user_role ur
+JOIN "user" u ON u.user_id=ur.user_id
+JOIN role r ON r.role_id=ur.role_id AND r.tenant_id=u.tenant_id
+JOIN role_permission rp ON rp.role_id=r.role_id
+JOIN permission p ON p.permission_id=rp.permission_id AND p.tenant_id=r.tenant_id
+WHERE ur.user_id=$1
+ORDER BY p.name,r.name;`
+	nextRelationTupleRevisionQuery = `
+UPDATE relation_tuple_revision SET revision=revision+1
+RETURNING revision;`
+	getRelationTupleRevisionQuery = `
+SELECT revision FROM relation_tuple_revision;`
+	addRelationTupleQuery = `
+INSERT INTO relation_tuple (tenant_id,namespace,object_id,relation,subject_namespace,subject_id,subject_relation,revision)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+ON CONFLICT (tenant_id,namespace,object_id,relation,subject_namespace,subject_id,subject_relation) DO NOTHING;`
+	removeRelationTupleQuery = `
+DELETE FROM relation_tuple
+WHERE tenant_id=$1 AND namespace=$2 AND object_id=$3 AND relation=$4 AND subject_namespace=$5 AND subject_id=$6 AND subject_relation=$7;`
+	getRelationTuplesQuery = `
+SELECT namespace,object_id,relation,subject_namespace,subject_id,subject_relation,revision,created_at FROM relation_tuple
+WHERE tenant_id=$1 AND namespace=$2 AND ($3::character varying IS NULL OR object_id=$3) AND ($4::character varying IS NULL OR relation=$4)
+AND ($5::character varying IS NULL OR subject_namespace=$5) AND ($6::character varying IS NULL OR subject_id=$6)
+AND ($7::character varying IS NULL OR subject_relation=$7) AND (NOT $9 OR subject_relation<>'')
+ORDER BY object_id,relation,subject_namespace,subject_id,subject_relation
+LIMIT NULLIF($8,0);`
+	getLoginAttemptQuery = `
+SELECT * FROM login_attempt
+WHERE key_type=$1 AND key=$2;`
+	addLoginAttemptFailureQuery = `
+INSERT INTO login_attempt (key_type,key,failed_count,last_failed_at)
+VALUES ($1,$2,1,$3)
+ON CONFLICT (key_type,key) DO UPDATE SET
+failed_count = CASE WHEN login_attempt.last_failed_at < $4 THEN 1 ELSE login_attempt.failed_count+1 END,
+last_failed_at = $3
+RETURNING *;`
+	updateLoginAttemptLockedUntilQuery = `
+UPDATE login_attempt
+SET locked_until=$3
+WHERE key_type=$1 AND key=$2;`
+	removeLoginAttemptQuery = `
+DELETE FROM login_attempt
+WHERE key_type=$1 AND key=$2;`
+	setUserMfaQuery = `
+INSERT INTO user_mfa (user_id,secret)
+VALUES ($1,$2)
+ON CONFLICT (user_id) DO UPDATE SET
+secret=$2, is_confirmed=false, last_used_step=0, created_at=now()
+WHERE user_mfa.is_confirmed=false
+RETURNING user_id;`
+	getUserMfaQuery = `
+SELECT * FROM user_mfa
+WHERE user_id=$1;`
+	confirmUserMfaQuery = `
+UPDATE user_mfa
+SET is_confirmed=true
+WHERE user_id=$1
+RETURNING user_id;`
+	updateUserMfaLastUsedStepQuery = `
+UPDATE user_mfa
+SET last_used_step=$2
+WHERE user_id=$1 AND last_used_step<$2
+RETURNING user_id;`
+	removeUserMfaQuery = `
+DELETE FROM user_mfa WHERE user_id=$1 RETURNING user_id;`
+	addMfaRecoveryCodeQuery = `
+INSERT INTO mfa_recovery_code (user_id,code_hash)
+VALUES ($1,$2);`
+	useMfaRecoveryCodeQuery = `
+UPDATE mfa_recovery_code
+SET used_at=now(), used_ip=$3
+WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL
+RETURNING mfa_recovery_code_id;`
+	getUnusedMfaRecoveryCodeCountQuery = `
+SELECT count(*) FROM mfa_recovery_code
+WHERE user_id=$1 AND used_at IS NULL;`
+	removeMfaRecoveryCodesQuery = `
+DELETE FROM mfa_recovery_code WHERE user_id=$1;`
 )
 
 type Store struct {
@@ -89,12 +467,20 @@ func MustNew(lg *slog.Logger, cfg *config.Store) *Store {
 	}
 }
 
+// scanUser scans the userFields columns
+func scanUser(row pgx.Row, user *entity.User) error {
+	return row.Scan(&user.UserId, &user.Login, &user.Password, &user.MaxDevices, &user.Status, &user.StatusChangedAt, &user.DeletedAt, &user.Email, &user.EmailVerifiedAt, &user.PendingEmail, &user.TenantId, &user.CreatedAt)
+}
+
 func (s *Store) AddUser(dto *repoDto.AddUser) (*uuid.UUID, error) {
 	userId := new(uuid.UUID)
-	err := s.pool.QueryRow(context.Background(), addUserQuery, dto.Login, dto.Password).Scan(userId)
+	err := s.pool.QueryRow(context.Background(), addUserQuery, dto.TenantId, dto.Login, dto.Password, dto.Email).Scan(userId)
 	if err != nil {
 		s.lg.Error(err.Error(), slog.String("owner", "store.AddUser"))
 		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23505" {
+			if pgError.ConstraintName == "user_tenant_id_email_uq" {
+				return nil, repoErr.ErrEmailUniqueViolation
+			}
 			return nil, repoErr.ErrUniqueViolation
 		}
 		return nil, repoErr.ErrInternalServerError
@@ -102,9 +488,21 @@ func (s *Store) AddUser(dto *repoDto.AddUser) (*uuid.UUID, error) {
 	}
 	return userId, nil
 }
-func (s *Store) GetUserByLogin(login string) (*entity.User, error) {
+func (s *Store) GetUser(userId *uuid.UUID) (*entity.User, error) {
+	user := new(entity.User)
+	err := scanUser(s.pool.QueryRow(context.Background(), getUserQuery, userId), user)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetUser"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return user, nil
+}
+func (s *Store) GetUserByLogin(tenantId *uuid.UUID, login string) (*entity.User, error) {
 	user := new(entity.User)
-	err := s.pool.QueryRow(context.Background(), getUserByLoginQuery, login).Scan(&user.UserId, &user.Login, &user.Password)
+	err := scanUser(s.pool.QueryRow(context.Background(), getUserByLoginQuery, tenantId, login), user)
 	if err != nil {
 		s.lg.Error(err.Error(), slog.String("owner", "store.GetUserByLogin"))
 		if errors.Is(err, sql.ErrNoRows) {
@@ -126,10 +524,10 @@ func (s *Store) UpdateUser(dto *repoDto.UpdateUser) error {
 	}
 	return nil
 }
-func (s *Store) RemoveUser(userId *uuid.UUID) error {
-	err := s.pool.QueryRow(context.Background(), removeUserQuery, userId).Scan(userId)
+func (s *Store) UpdateUserMaxDevices(dto *repoDto.UpdateUserMaxDevices) error {
+	err := s.pool.QueryRow(context.Background(), updateUserMaxDevicesQuery, dto.UserId, dto.MaxDevices).Scan(new(uuid.UUID))
 	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveUser"))
+		s.lg.Error(err.Error(), slog.String("owner", "store.UpdateUserMaxDevices"))
 		if errors.Is(err, sql.ErrNoRows) {
 			return repoErr.ErrRecordNotFound
 		}
@@ -138,47 +536,1219 @@ func (s *Store) RemoveUser(userId *uuid.UUID) error {
 	return nil
 }
 
-func (s *Store) AddRefreshTokenWithRefreshTokenId(dto *repoDto.AddRefreshTokenWithRefreshTokenId) error {
-	_, err := s.pool.Exec(context.Background(), addRefreshTokenWithRefreshTokenIdQuery, dto.RefreshTokenId, dto.UserId, dto.DeviceCode, dto.ExpirationAt, dto.IsRevoke)
+// UpdateUserPendingEmail sets the email waiting for the verification and removes the unused verification tokens,
+// so that only the tokens sent afterwards verify an email
+func (s *Store) UpdateUserPendingEmail(dto *repoDto.UpdateUserPendingEmail) error {
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), updateUserPendingEmailQuery, dto.UserId, dto.PendingEmail).Scan(new(uuid.UUID)); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.Background(), removeEmailVerificationTokensQuery, dto.UserId)
+		return err
+	})
 	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "store.AddRefreshTokenWithRefreshTokenId"))
+		s.lg.Error(err.Error(), slog.String("owner", "store.UpdateUserPendingEmail"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
 		return repoErr.ErrInternalServerError
 	}
 	return nil
 }
-func (s *Store) GetRefreshToken(refreshTokenId *uuid.UUID) (*entity.RefreshToken, error) {
-	refreshToken := new(entity.RefreshToken)
-	err := s.pool.QueryRow(context.Background(), getRefreshTokenQuery, refreshTokenId).Scan(&refreshToken.RefreshTokenId, &refreshToken.UserId, &refreshToken.DeviceCode, &refreshToken.ExpirationAt, &refreshToken.IsRevoke)
+
+// UpdateUserStatus changes the status and records the change with the reason in the history.
+// ErrRecordNotFound means there is no user with the old status
+func (s *Store) UpdateUserStatus(dto *repoDto.UpdateUserStatus) error {
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), updateUserStatusQuery, dto.UserId, dto.OldStatus, dto.NewStatus).Scan(new(uuid.UUID)); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.Background(), addUserStatusHistoryQuery, dto.UserId, dto.OldStatus, dto.NewStatus, dto.Reason)
+		return err
+	})
 	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "store.GetRefreshToken"))
+		s.lg.Error(err.Error(), slog.String("owner", "store.UpdateUserStatus"))
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, repoErr.ErrRecordNotFound
+			return repoErr.ErrRecordNotFound
 		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) GetUserStatusHistory(userId *uuid.UUID) ([]*entity.UserStatusHistory, error) {
+	rows, err := s.pool.Query(context.Background(), getUserStatusHistoryQuery, userId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetUserStatusHistory"))
 		return nil, repoErr.ErrInternalServerError
 	}
-	return refreshToken, nil
+	history, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.UserStatusHistory, error) {
+		entry := new(entity.UserStatusHistory)
+		err := row.Scan(&entry.UserStatusHistoryId, &entry.UserId, &entry.OldStatus, &entry.NewStatus, &entry.Reason, &entry.ChangedAt)
+		return entry, err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetUserStatusHistory"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return history, nil
 }
-func (s *Store) RevokeRefreshTokenByRefreshTokenId(refreshTokenId *uuid.UUID) error {
-	_, err := s.pool.Exec(context.Background(), revokeRefreshTokenByRefreshTokenIdQuery, refreshTokenId)
+
+// DeleteUser marks the user as deleted and records the change in the status history
+func (s *Store) DeleteUser(dto *repoDto.DeleteUser) error {
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), deleteUserQuery, dto.UserId, dto.OldStatus, dto.FreeLogin).Scan(new(uuid.UUID)); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.Background(), addUserStatusHistoryQuery, dto.UserId, dto.OldStatus, entity.UserStatusDeleted, dto.Reason)
+		return err
+	})
 	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "store.RevokeRefreshTokenByRefreshTokenId"))
+		s.lg.Error(err.Error(), slog.String("owner", "store.DeleteUser"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
 		return repoErr.ErrInternalServerError
 	}
 	return nil
 }
-func (s *Store) RevokeRefreshTokensByUserIdAndDeviceCode(dto *repoDto.RevokeRefreshTokensByUserIdAndDeviceCode) error {
-	_, err := s.pool.Exec(context.Background(), revokeRefreshTokensByUserIdAndDeviceCodeQuery, dto.UserId, dto.DeviceCode)
+
+// RestoreUser gives the deleted user back the status it had before the deletion and the original login.
+// ErrUniqueViolation means the login was released and is taken by now
+func (s *Store) RestoreUser(dto *repoDto.RestoreUser) error {
+	loginTaken := false
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), existsDeletedLoginQuery, dto.UserId).Scan(&loginTaken); err != nil || loginTaken {
+			return err
+		}
+		var restoredStatus string
+		if err := tx.QueryRow(context.Background(), restoreUserQuery, dto.UserId).Scan(&restoredStatus); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.Background(), addUserStatusHistoryQuery, dto.UserId, entity.UserStatusDeleted, restoredStatus, dto.Reason)
+		return err
+	})
+	if loginTaken {
+		s.lg.Error("login is taken", slog.String("owner", "store.RestoreUser"))
+		return repoErr.ErrUniqueViolation
+	}
 	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "store.RevokeRefreshTokensByUserIdAndDeviceCode"))
+		s.lg.Error(err.Error(), slog.String("owner", "store.RestoreUser"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23505" {
+			return repoErr.ErrUniqueViolation
+		}
 		return repoErr.ErrInternalServerError
 	}
 	return nil
 }
-func (s *Store) RemoveRefreshTokensByExpirationAt(now time.Time) (int64, error) {
-	result, err := s.pool.Exec(context.Background(), removeRefreshTokensByExpirationAtQuery, now)
+func (s *Store) RemoveUsersByDeletedAt(before time.Time) (int64, error) {
+	result, err := s.pool.Exec(context.Background(), removeUsersByDeletedAtQuery, before)
 	if err != nil {
-		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveRefreshTokensByExpirationAt"))
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveUsersByDeletedAt"))
 		return -1, repoErr.ErrInternalServerError
 	}
 	return result.RowsAffected(), nil
 }
+
+func (s *Store) ListUsers(dto *repoDto.ListUsers) ([]*entity.User, error) {
+	rows, err := s.pool.Query(context.Background(), listUsersQuery, dto.TenantId, dto.LoginPrefix, dto.Status, dto.CreatedFrom, dto.CreatedTo, dto.AfterCreatedAt, dto.AfterUserId, dto.Limit)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.ListUsers"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	users, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.User, error) {
+		user := new(entity.User)
+		err := scanUser(row, user)
+		return user, err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.ListUsers"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return users, nil
+}
+func (s *Store) GetUserStats(tenantId *uuid.UUID, now time.Time) (*entity.UserStats, error) {
+	stats := new(entity.UserStats)
+	err := s.pool.QueryRow(context.Background(), getUserStatsQuery, tenantId, now).Scan(&stats.Total, &stats.Pending, &stats.Active, &stats.Locked, &stats.Disabled, &stats.Deleted, &stats.EmailVerified, &stats.MfaEnabled, &stats.CreatedLastDay, &stats.CreatedLastWeek)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetUserStats"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return stats, nil
+}
+
+// lifetimes are kept in seconds
+func durationSeconds(d *time.Duration) *int64 {
+	if d == nil {
+		return nil
+	}
+	seconds := int64(d.Seconds())
+	return &seconds
+}
+func secondsDuration(seconds *int64) *time.Duration {
+	if seconds == nil {
+		return nil
+	}
+	d := time.Duration(*seconds) * time.Second
+	return &d
+}
+func scanTenant(row pgx.Row) (*entity.Tenant, error) {
+	tenant := new(entity.Tenant)
+	var accessLifetime, refreshLifetime *int64
+	if err := row.Scan(&tenant.TenantId, &tenant.Name, &accessLifetime, &refreshLifetime, &tenant.PasswordPolicy, &tenant.CreatedAt); err != nil {
+		return nil, err
+	}
+	tenant.AccessLifetime = secondsDuration(accessLifetime)
+	tenant.RefreshLifetime = secondsDuration(refreshLifetime)
+	return tenant, nil
+}
+func (s *Store) AddTenant(dto *repoDto.AddTenant) (*uuid.UUID, error) {
+	tenantId := new(uuid.UUID)
+	err := s.pool.QueryRow(context.Background(), addTenantQuery, dto.Name, durationSeconds(dto.AccessLifetime), durationSeconds(dto.RefreshLifetime), dto.PasswordPolicy).Scan(tenantId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddTenant"))
+		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23505" {
+			return nil, repoErr.ErrUniqueViolation
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return tenantId, nil
+}
+func (s *Store) GetTenant(tenantId *uuid.UUID) (*entity.Tenant, error) {
+	tenant, err := scanTenant(s.pool.QueryRow(context.Background(), getTenantQuery, tenantId))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetTenant"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return tenant, nil
+}
+func (s *Store) GetTenantByName(name string) (*entity.Tenant, error) {
+	tenant, err := scanTenant(s.pool.QueryRow(context.Background(), getTenantByNameQuery, name))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetTenantByName"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return tenant, nil
+}
+func (s *Store) GetTenants() ([]*entity.Tenant, error) {
+	rows, err := s.pool.Query(context.Background(), getTenantsQuery)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetTenants"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	tenants, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.Tenant, error) {
+		return scanTenant(row)
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetTenants"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return tenants, nil
+}
+func (s *Store) UpdateTenant(dto *repoDto.UpdateTenant) error {
+	err := s.pool.QueryRow(context.Background(), updateTenantQuery, dto.TenantId, durationSeconds(dto.AccessLifetime), durationSeconds(dto.RefreshLifetime), dto.PasswordPolicy).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.UpdateTenant"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) AddTenantSigningKey(dto *repoDto.AddTenantSigningKey) error {
+	_, err := s.pool.Exec(context.Background(), addTenantSigningKeyQuery, dto.KeyId, dto.TenantId, dto.PrivateKey)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddTenantSigningKey"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func scanTenantSigningKey(row pgx.Row) (*entity.TenantSigningKey, error) {
+	signingKey := new(entity.TenantSigningKey)
+	err := row.Scan(&signingKey.KeyId, &signingKey.TenantId, &signingKey.PrivateKey, &signingKey.CreatedAt)
+	return signingKey, err
+}
+func (s *Store) GetTenantSigningKey(keyId string) (*entity.TenantSigningKey, error) {
+	signingKey, err := scanTenantSigningKey(s.pool.QueryRow(context.Background(), getTenantSigningKeyQuery, keyId))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetTenantSigningKey"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return signingKey, nil
+}
+
+// GetTenantSigningKeys returns the keys of all the tenants, the newest of each tenant first
+func (s *Store) GetTenantSigningKeys() ([]*entity.TenantSigningKey, error) {
+	rows, err := s.pool.Query(context.Background(), getTenantSigningKeysQuery)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetTenantSigningKeys"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	signingKeys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.TenantSigningKey, error) {
+		return scanTenantSigningKey(row)
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetTenantSigningKeys"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return signingKeys, nil
+}
+
+// GetTenantSigningKeysByTenantId returns the keys of the tenant, the newest first
+func (s *Store) GetTenantSigningKeysByTenantId(tenantId *uuid.UUID) ([]*entity.TenantSigningKey, error) {
+	rows, err := s.pool.Query(context.Background(), getTenantSigningKeysByTenantIdQuery, tenantId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetTenantSigningKeysByTenantId"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	signingKeys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.TenantSigningKey, error) {
+		return scanTenantSigningKey(row)
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetTenantSigningKeysByTenantId"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return signingKeys, nil
+}
+
+func scanServiceAccount(row pgx.Row) (*entity.ServiceAccount, error) {
+	serviceAccount := new(entity.ServiceAccount)
+	err := row.Scan(&serviceAccount.ServiceAccountId, &serviceAccount.TenantId, &serviceAccount.ClientId, &serviceAccount.Name, &serviceAccount.Scopes, &serviceAccount.IsDisabled, &serviceAccount.CreatedAt)
+	return serviceAccount, err
+}
+func (s *Store) AddServiceAccount(dto *repoDto.AddServiceAccount) (*uuid.UUID, error) {
+	serviceAccountId := new(uuid.UUID)
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), addServiceAccountQuery, dto.TenantId, dto.ClientId, dto.Name, dto.Scopes).Scan(serviceAccountId); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.Background(), addServiceAccountSecretQuery, serviceAccountId, dto.SecretHash, dto.SecretExpirationAt)
+		return err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddServiceAccount"))
+		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23505" {
+			return nil, repoErr.ErrUniqueViolation
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return serviceAccountId, nil
+}
+func (s *Store) GetServiceAccount(serviceAccountId *uuid.UUID) (*entity.ServiceAccount, error) {
+	serviceAccount, err := scanServiceAccount(s.pool.QueryRow(context.Background(), getServiceAccountQuery, serviceAccountId))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetServiceAccount"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return serviceAccount, nil
+}
+func (s *Store) GetServiceAccountByClientId(clientId string) (*entity.ServiceAccount, error) {
+	serviceAccount, err := scanServiceAccount(s.pool.QueryRow(context.Background(), getServiceAccountByClientIdQuery, clientId))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetServiceAccountByClientId"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return serviceAccount, nil
+}
+func (s *Store) GetServiceAccounts(tenantId *uuid.UUID) ([]*entity.ServiceAccount, error) {
+	rows, err := s.pool.Query(context.Background(), getServiceAccountsQuery, tenantId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetServiceAccounts"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	serviceAccounts, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.ServiceAccount, error) {
+		return scanServiceAccount(row)
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetServiceAccounts"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return serviceAccounts, nil
+}
+func (s *Store) UpdateServiceAccount(dto *repoDto.UpdateServiceAccount) error {
+	err := s.pool.QueryRow(context.Background(), updateServiceAccountQuery, dto.ServiceAccountId, dto.Scopes, dto.IsDisabled).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.UpdateServiceAccount"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) RemoveServiceAccount(serviceAccountId *uuid.UUID) error {
+	err := s.pool.QueryRow(context.Background(), removeServiceAccountQuery, serviceAccountId).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveServiceAccount"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) AddServiceAccountSecret(dto *repoDto.AddServiceAccountSecret) (*uuid.UUID, error) {
+	serviceAccountSecretId := new(uuid.UUID)
+	err := s.pool.QueryRow(context.Background(), addServiceAccountSecretQuery, dto.ServiceAccountId, dto.SecretHash, dto.ExpirationAt).Scan(serviceAccountSecretId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddServiceAccountSecret"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return serviceAccountSecretId, nil
+}
+
+// GetServiceAccountSecrets returns the secrets of the account including the expired ones, the oldest first
+func (s *Store) GetServiceAccountSecrets(serviceAccountId *uuid.UUID) ([]*entity.ServiceAccountSecret, error) {
+	rows, err := s.pool.Query(context.Background(), getServiceAccountSecretsQuery, serviceAccountId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetServiceAccountSecrets"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	secrets, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.ServiceAccountSecret, error) {
+		secret := new(entity.ServiceAccountSecret)
+		err := row.Scan(&secret.ServiceAccountSecretId, &secret.ServiceAccountId, &secret.SecretHash, &secret.ExpirationAt, &secret.CreatedAt)
+		return secret, err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetServiceAccountSecrets"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return secrets, nil
+}
+func (s *Store) RemoveServiceAccountSecret(serviceAccountId *uuid.UUID, serviceAccountSecretId *uuid.UUID) error {
+	err := s.pool.QueryRow(context.Background(), removeServiceAccountSecretQuery, serviceAccountId, serviceAccountSecretId).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveServiceAccountSecret"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+func scanApiKey(row pgx.Row) (*entity.ApiKey, error) {
+	apiKey := new(entity.ApiKey)
+	err := row.Scan(&apiKey.ApiKeyId, &apiKey.UserId, &apiKey.ServiceAccountId, &apiKey.Name, &apiKey.Prefix, &apiKey.KeyHash, &apiKey.Scopes, &apiKey.ExpirationAt, &apiKey.LastUsedAt, &apiKey.CreatedAt)
+	return apiKey, err
+}
+func (s *Store) AddApiKey(dto *repoDto.AddApiKey) (*uuid.UUID, error) {
+	apiKeyId := new(uuid.UUID)
+	err := s.pool.QueryRow(context.Background(), addApiKeyQuery, dto.UserId, dto.ServiceAccountId, dto.Name, dto.Prefix, dto.KeyHash, dto.Scopes, dto.ExpirationAt).Scan(apiKeyId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddApiKey"))
+		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23505" {
+			return nil, repoErr.ErrUniqueViolation
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return apiKeyId, nil
+}
+func (s *Store) GetApiKeyByPrefix(prefix string) (*entity.ApiKey, error) {
+	apiKey, err := scanApiKey(s.pool.QueryRow(context.Background(), getApiKeyByPrefixQuery, prefix))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetApiKeyByPrefix"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return apiKey, nil
+}
+
+// GetApiKeysByOwner returns the keys of the user or of the service account including the expired ones, the newest first
+func (s *Store) GetApiKeysByOwner(userId *uuid.UUID, serviceAccountId *uuid.UUID) ([]*entity.ApiKey, error) {
+	rows, err := s.pool.Query(context.Background(), getApiKeysByOwnerQuery, userId, serviceAccountId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetApiKeysByOwner"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	apiKeys, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.ApiKey, error) {
+		return scanApiKey(row)
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetApiKeysByOwner"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return apiKeys, nil
+}
+func (s *Store) UpdateApiKeyLastUsedAt(apiKeyId *uuid.UUID, lastUsedAt time.Time) error {
+	_, err := s.pool.Exec(context.Background(), updateApiKeyLastUsedAtQuery, apiKeyId, lastUsedAt)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.UpdateApiKeyLastUsedAt"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) RemoveApiKey(apiKeyId *uuid.UUID) error {
+	err := s.pool.QueryRow(context.Background(), removeApiKeyQuery, apiKeyId).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveApiKey"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+func scanOAuthClient(row pgx.Row) (*entity.OAuthClient, error) {
+	oauthClient := new(entity.OAuthClient)
+	err := row.Scan(&oauthClient.OAuthClientId, &oauthClient.TenantId, &oauthClient.ClientId, &oauthClient.Name, &oauthClient.ClientType, &oauthClient.SecretHash, &oauthClient.RedirectUris, &oauthClient.Scopes, &oauthClient.CreatedAt)
+	return oauthClient, err
+}
+func (s *Store) AddOAuthClient(dto *repoDto.AddOAuthClient) (*uuid.UUID, error) {
+	oauthClientId := new(uuid.UUID)
+	err := s.pool.QueryRow(context.Background(), addOAuthClientQuery, dto.TenantId, dto.ClientId, dto.Name, dto.ClientType, dto.SecretHash, dto.RedirectUris, dto.Scopes).Scan(oauthClientId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddOAuthClient"))
+		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23505" {
+			return nil, repoErr.ErrUniqueViolation
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return oauthClientId, nil
+}
+func (s *Store) GetOAuthClientByClientId(clientId string) (*entity.OAuthClient, error) {
+	oauthClient, err := scanOAuthClient(s.pool.QueryRow(context.Background(), getOAuthClientByClientIdQuery, clientId))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetOAuthClientByClientId"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return oauthClient, nil
+}
+func (s *Store) GetOAuthClients(tenantId *uuid.UUID) ([]*entity.OAuthClient, error) {
+	rows, err := s.pool.Query(context.Background(), getOAuthClientsQuery, tenantId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetOAuthClients"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	oauthClients, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.OAuthClient, error) {
+		return scanOAuthClient(row)
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetOAuthClients"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return oauthClients, nil
+}
+func (s *Store) UpdateOAuthClient(dto *repoDto.UpdateOAuthClient) error {
+	err := s.pool.QueryRow(context.Background(), updateOAuthClientQuery, dto.OAuthClientId, dto.RedirectUris, dto.Scopes).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.UpdateOAuthClient"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+// RemoveOAuthClient removes the client and revokes the refresh tokens of its sessions in one transaction
+func (s *Store) RemoveOAuthClient(dto *repoDto.RemoveOAuthClient) error {
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), removeOAuthClientQuery, dto.OAuthClientId).Scan(new(uuid.UUID)); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.Background(), revokeRefreshTokensByDeviceCodeQuery, dto.DeviceCode)
+		return err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveOAuthClient"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) AddOAuthAuthorizationCode(dto *repoDto.AddOAuthAuthorizationCode) error {
+	_, err := s.pool.Exec(context.Background(), addOAuthAuthorizationCodeQuery, dto.CodeHash, dto.OAuthClientId, dto.UserId, dto.RedirectUri, dto.RedirectUriSent, dto.Scopes, dto.CodeChallenge, dto.Nonce, dto.Acr, dto.AuthTime, dto.ExpirationAt)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddOAuthAuthorizationCode"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+// TakeOAuthAuthorizationCode removes the code and returns it, so the code can be exchanged only once
+func (s *Store) TakeOAuthAuthorizationCode(codeHash string) (*entity.OAuthAuthorizationCode, error) {
+	code := new(entity.OAuthAuthorizationCode)
+	err := s.pool.QueryRow(context.Background(), takeOAuthAuthorizationCodeQuery, codeHash).Scan(&code.CodeHash, &code.OAuthClientId, &code.UserId, &code.RedirectUri, &code.RedirectUriSent, &code.Scopes, &code.CodeChallenge, &code.Nonce, &code.Acr, &code.AuthTime, &code.ExpirationAt, &code.CreatedAt)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.TakeOAuthAuthorizationCode"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return code, nil
+}
+func (s *Store) RemoveOAuthAuthorizationCodesByExpirationAt(now time.Time) (int64, error) {
+	result, err := s.pool.Exec(context.Background(), removeOAuthAuthorizationCodesByExpirationAtQuery, now)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveOAuthAuthorizationCodesByExpirationAt"))
+		return 0, repoErr.ErrInternalServerError
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *Store) AddRefreshTokenWithRefreshTokenId(dto *repoDto.AddRefreshTokenWithRefreshTokenId) error {
+	_, err := s.pool.Exec(context.Background(), addRefreshTokenWithRefreshTokenIdQuery, dto.RefreshTokenId, dto.UserId, dto.DeviceCode, dto.ExpirationAt, dto.IsRevoke, dto.SessionId, dto.IssuedAt, dto.ClientIp, dto.UserAgent, dto.ClientVersion, dto.LastUsedAt, dto.Scopes)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddRefreshTokenWithRefreshTokenId"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+// AddDeviceRefreshToken replaces the tokens of the device with the new one. The limit of devices is checked while
+// the user is locked, so that the concurrent logins cannot exceed it. The evicted devices are returned,
+// ErrLimitExceeded means the limit is reached and the devices are not evicted
+func (s *Store) AddDeviceRefreshToken(dto *repoDto.AddDeviceRefreshToken) ([]string, error) {
+	refreshToken := dto.RefreshToken
+	var evictedDeviceCodes []string
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), lockUserQuery, refreshToken.UserId).Scan(new(uuid.UUID)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(context.Background(), revokeRefreshTokensByUserIdAndDeviceCodeQuery, refreshToken.UserId, &refreshToken.DeviceCode); err != nil {
+			return err
+		}
+		if dto.MaxDevices > 0 {
+			rows, err := tx.Query(context.Background(), getActiveDeviceCodesQuery, refreshToken.UserId, refreshToken.DeviceCode)
+			if err != nil {
+				return err
+			}
+			deviceCodes, err := pgx.CollectRows(rows, pgx.RowTo[string])
+			if err != nil {
+				return err
+			}
+			if len(deviceCodes) >= dto.MaxDevices {
+				if !dto.EvictLru {
+					return repoErr.ErrLimitExceeded
+				}
+				evictedDeviceCodes = deviceCodes[:len(deviceCodes)-dto.MaxDevices+1]
+				for _, deviceCode := range evictedDeviceCodes {
+					if _, err := tx.Exec(context.Background(), revokeRefreshTokensByUserIdAndDeviceCodeQuery, refreshToken.UserId, &deviceCode); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		_, err := tx.Exec(context.Background(), addRefreshTokenWithRefreshTokenIdQuery, refreshToken.RefreshTokenId, refreshToken.UserId, refreshToken.DeviceCode, refreshToken.ExpirationAt, refreshToken.IsRevoke, refreshToken.SessionId, refreshToken.IssuedAt, refreshToken.ClientIp, refreshToken.UserAgent, refreshToken.ClientVersion, refreshToken.LastUsedAt, refreshToken.Scopes)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, repoErr.ErrLimitExceeded) {
+			return nil, err
+		}
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddDeviceRefreshToken"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return evictedDeviceCodes, nil
+}
+func (s *Store) GetRefreshToken(refreshTokenId *uuid.UUID) (*entity.RefreshToken, error) {
+	refreshToken := new(entity.RefreshToken)
+	err := s.pool.QueryRow(context.Background(), getRefreshTokenQuery, refreshTokenId).Scan(&refreshToken.RefreshTokenId, &refreshToken.UserId, &refreshToken.DeviceCode, &refreshToken.ExpirationAt, &refreshToken.IsRevoke, &refreshToken.SessionId, &refreshToken.IssuedAt, &refreshToken.CreatedAt, &refreshToken.ClientIp, &refreshToken.UserAgent, &refreshToken.ClientVersion, &refreshToken.LastUsedAt, &refreshToken.Scopes)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetRefreshToken"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return refreshToken, nil
+}
+func (s *Store) RevokeRefreshTokenByRefreshTokenId(refreshTokenId *uuid.UUID) error {
+	_, err := s.pool.Exec(context.Background(), revokeRefreshTokenByRefreshTokenIdQuery, refreshTokenId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RevokeRefreshTokenByRefreshTokenId"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) RevokeRefreshTokensByUserIdAndDeviceCode(dto *repoDto.RevokeRefreshTokensByUserIdAndDeviceCode) error {
+	_, err := s.pool.Exec(context.Background(), revokeRefreshTokensByUserIdAndDeviceCodeQuery, dto.UserId, dto.DeviceCode)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RevokeRefreshTokensByUserIdAndDeviceCode"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) RemoveRefreshTokensByExpirationAt(now time.Time) (int64, error) {
+	result, err := s.pool.Exec(context.Background(), removeRefreshTokensByExpirationAtQuery, now)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveRefreshTokensByExpirationAt"))
+		return -1, repoErr.ErrInternalServerError
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *Store) GetSessionsByUserId(userId *uuid.UUID, now time.Time) ([]*entity.Session, error) {
+	rows, err := s.pool.Query(context.Background(), getSessionsByUserIdQuery, userId, now)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetSessionsByUserId"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	sessions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.Session, error) {
+		session := new(entity.Session)
+		err := row.Scan(&session.SessionId, &session.UserId, &session.DeviceCode, &session.IssuedAt, &session.ExpirationAt, &session.LastRefreshAt, &session.ClientIp, &session.UserAgent, &session.ClientVersion, &session.LastUsedAt)
+		return session, err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetSessionsByUserId"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return sessions, nil
+}
+func (s *Store) RevokeRefreshTokensBySessionId(dto *repoDto.RevokeRefreshTokensBySessionId) error {
+	result, err := s.pool.Exec(context.Background(), revokeRefreshTokensBySessionIdQuery, dto.UserId, dto.SessionId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RevokeRefreshTokensBySessionId"))
+		return repoErr.ErrInternalServerError
+	}
+	if result.RowsAffected() == 0 {
+		return repoErr.ErrRecordNotFound
+	}
+	return nil
+}
+func (s *Store) RevokeRefreshTokensExceptDeviceCode(dto *repoDto.RevokeRefreshTokensExceptDeviceCode) (int64, error) {
+	result, err := s.pool.Exec(context.Background(), revokeRefreshTokensExceptDeviceCodeQuery, dto.UserId, dto.DeviceCode)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RevokeRefreshTokensExceptDeviceCode"))
+		return -1, repoErr.ErrInternalServerError
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *Store) AddPasswordResetToken(dto *repoDto.AddPasswordResetToken) error {
+	_, err := s.pool.Exec(context.Background(), addPasswordResetTokenQuery, dto.UserId, dto.TokenHash, dto.ExpirationAt)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddPasswordResetToken"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+// ResetPasswordByToken marks the token as used and sets the password in one statement, so a token is consumed
+// exactly once and only together with the password. ErrRecordNotFound means the token is used, expired or unknown
+func (s *Store) ResetPasswordByToken(dto *repoDto.ResetPasswordByToken) error {
+	err := s.pool.QueryRow(context.Background(), resetPasswordByTokenQuery, dto.TokenHash, dto.UserId, dto.Now, dto.Password).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.ResetPasswordByToken"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+// GetPasswordResetToken returns the unused and unexpired token
+func (s *Store) GetPasswordResetToken(tokenHash string, now time.Time) (*entity.PasswordResetToken, error) {
+	token := new(entity.PasswordResetToken)
+	err := s.pool.QueryRow(context.Background(), getPasswordResetTokenQuery, tokenHash, now).Scan(&token.PasswordResetTokenId, &token.UserId, &token.TokenHash, &token.ExpirationAt, &token.CreatedAt, &token.UsedAt)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetPasswordResetToken"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return token, nil
+}
+
+// RemovePasswordResetTokensByUserId removes the outstanding tokens of the user, the used ones are kept
+func (s *Store) RemovePasswordResetTokensByUserId(userId *uuid.UUID) error {
+	_, err := s.pool.Exec(context.Background(), removePasswordResetTokensByUserIdQuery, userId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemovePasswordResetTokensByUserId"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+func (s *Store) AddEmailVerificationToken(dto *repoDto.AddEmailVerificationToken) error {
+	_, err := s.pool.Exec(context.Background(), addEmailVerificationTokenQuery, dto.UserId, dto.Email, dto.TokenHash, dto.ExpirationAt)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddEmailVerificationToken"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+// GetLastEmailVerificationTokenCreatedAt returns the time the last verification token of the user was created,
+// ErrRecordNotFound when there is none
+func (s *Store) GetLastEmailVerificationTokenCreatedAt(userId *uuid.UUID) (*time.Time, error) {
+	var createdAt *time.Time
+	err := s.pool.QueryRow(context.Background(), getLastEmailVerificationTokenCreatedAtQuery, userId).Scan(&createdAt)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetLastEmailVerificationTokenCreatedAt"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	if createdAt == nil {
+		return nil, repoErr.ErrRecordNotFound
+	}
+	return createdAt, nil
+}
+
+// VerifyEmailByToken marks the unused and unexpired token as used and sets its email as the verified email of the user
+// in one transaction. The email must be the pending one or the current unverified one, ErrRecordNotFound otherwise
+func (s *Store) VerifyEmailByToken(tokenHash string, now time.Time) (*entity.EmailVerificationToken, error) {
+	token := new(entity.EmailVerificationToken)
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), useEmailVerificationTokenQuery, tokenHash, now).Scan(&token.EmailVerificationTokenId, &token.UserId, &token.Email, &token.TokenHash, &token.ExpirationAt, &token.CreatedAt, &token.UsedAt); err != nil {
+			return err
+		}
+		return tx.QueryRow(context.Background(), verifyUserEmailQuery, token.UserId, token.Email).Scan(new(uuid.UUID))
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.VerifyEmailByToken"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23505" {
+			return nil, repoErr.ErrEmailUniqueViolation
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return token, nil
+}
+
+// AddPasswordHistory adds the password to the history and keeps only the latest dto.Depth entries
+func (s *Store) AddPasswordHistory(dto *repoDto.AddPasswordHistory) error {
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(context.Background(), addPasswordHistoryQuery, dto.UserId, dto.Password); err != nil {
+			return err
+		}
+		_, err := tx.Exec(context.Background(), trimPasswordHistoryQuery, dto.UserId, dto.Depth)
+		return err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddPasswordHistory"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) GetPasswordHistory(userId *uuid.UUID, limit int) ([]string, error) {
+	rows, err := s.pool.Query(context.Background(), getPasswordHistoryQuery, userId, limit)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetPasswordHistory"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	passwords, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetPasswordHistory"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return passwords, nil
+}
+
+func scanRole(row pgx.CollectableRow) (*entity.Role, error) {
+	role := new(entity.Role)
+	err := row.Scan(&role.RoleId, &role.Name, &role.Description, &role.MaxDevices, &role.CreatedAt, &role.Permissions)
+	return role, err
+}
+func (s *Store) AddRole(dto *repoDto.AddRole) (*uuid.UUID, error) {
+	roleId := new(uuid.UUID)
+	err := s.pool.QueryRow(context.Background(), addRoleQuery, dto.TenantId, dto.Name, dto.Description, dto.MaxDevices).Scan(roleId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddRole"))
+		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23505" {
+			return nil, repoErr.ErrUniqueViolation
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return roleId, nil
+}
+func (s *Store) GetRoles(tenantId *uuid.UUID) ([]*entity.Role, error) {
+	rows, err := s.pool.Query(context.Background(), getRolesQuery, tenantId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetRoles"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	roles, err := pgx.CollectRows(rows, scanRole)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetRoles"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return roles, nil
+}
+func (s *Store) RemoveRole(tenantId *uuid.UUID, name string) error {
+	err := s.pool.QueryRow(context.Background(), removeRoleQuery, tenantId, name).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveRole"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) AddPermission(dto *repoDto.AddPermission) (*uuid.UUID, error) {
+	permissionId := new(uuid.UUID)
+	err := s.pool.QueryRow(context.Background(), addPermissionQuery, dto.TenantId, dto.Name, dto.Description).Scan(permissionId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddPermission"))
+		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23505" {
+			return nil, repoErr.ErrUniqueViolation
+		}
+		return nil, repoErr.ErrInternalServerError
+	}
+	return permissionId, nil
+}
+func (s *Store) GetPermissions(tenantId *uuid.UUID) ([]*entity.Permission, error) {
+	rows, err := s.pool.Query(context.Background(), getPermissionsQuery, tenantId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetPermissions"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	permissions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.Permission, error) {
+		permission := new(entity.Permission)
+		err := row.Scan(&permission.PermissionId, &permission.Name, &permission.Description, &permission.CreatedAt)
+		return permission, err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetPermissions"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return permissions, nil
+}
+func (s *Store) RemovePermission(tenantId *uuid.UUID, name string) error {
+	err := s.pool.QueryRow(context.Background(), removePermissionQuery, tenantId, name).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemovePermission"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+// AddRolePermission grants the permission to the role or replaces the condition of the grant.
+// ErrRecordNotFound means the role or the permission does not exist
+func (s *Store) AddRolePermission(dto *repoDto.RolePermission) error {
+	err := s.pool.QueryRow(context.Background(), addRolePermissionQuery, dto.TenantId, dto.Role, dto.Permission, dto.Condition).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddRolePermission"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) RemoveRolePermission(dto *repoDto.RolePermission) error {
+	err := s.pool.QueryRow(context.Background(), removeRolePermissionQuery, dto.TenantId, dto.Role, dto.Permission).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveRolePermission"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+// AddUserRole assigns the role of the tenant of the user to the user. ErrRecordNotFound means the role or the user
+// does not exist
+func (s *Store) AddUserRole(dto *repoDto.UserRole) error {
+	err := s.pool.QueryRow(context.Background(), addUserRoleQuery, dto.UserId, dto.Role).Scan(new(uuid.UUID))
+	if err != nil {
+		// the role is assigned already
+		if errors.Is(err, sql.ErrNoRows) {
+			var exists bool
+			if err := s.pool.QueryRow(context.Background(), existsUserTenantRoleQuery, dto.UserId, dto.Role).Scan(&exists); err == nil && exists {
+				return nil
+			}
+			return repoErr.ErrRecordNotFound
+		}
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddUserRole"))
+		if pgError, ok := err.(*pgconn.PgError); ok && pgError.Code == "23503" {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) RemoveUserRole(dto *repoDto.UserRole) error {
+	err := s.pool.QueryRow(context.Background(), removeUserRoleQuery, dto.UserId, dto.Role).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveUserRole"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+// GetRolesByUserId returns the roles of the user with their permissions
+func (s *Store) GetRolesByUserId(userId *uuid.UUID) ([]*entity.Role, error) {
+	rows, err := s.pool.Query(context.Background(), getRolesByUserIdQuery, userId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetRolesByUserId"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	roles, err := pgx.CollectRows(rows, scanRole)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetRolesByUserId"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return roles, nil
+}
+
+// GetGrantsByUserId returns the permissions of the user through all the roles, one row per role and permission
+func (s *Store) GetGrantsByUserId(userId *uuid.UUID) ([]*entity.Grant, error) {
+	rows, err := s.pool.Query(context.Background(), getGrantsByUserIdQuery, userId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetGrantsByUserId"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	grants, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.Grant, error) {
+		grant := new(entity.Grant)
+		err := row.Scan(&grant.Role, &grant.Permission, &grant.Condition)
+		return grant, err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetGrantsByUserId"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return grants, nil
+}
+
+// WriteRelationTuples writes and deletes the tuples at once and returns the revision of the change
+func (s *Store) WriteRelationTuples(dto *repoDto.WriteRelationTuples) (int64, error) {
+	var revision int64
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), nextRelationTupleRevisionQuery).Scan(&revision); err != nil {
+			return err
+		}
+		for _, tuple := range dto.Deletes {
+			if _, err := tx.Exec(context.Background(), removeRelationTupleQuery, dto.TenantId, tuple.Namespace, tuple.ObjectId, tuple.Relation, tuple.SubjectNamespace, tuple.SubjectId, tuple.SubjectRelation); err != nil {
+				return err
+			}
+		}
+		for _, tuple := range dto.Writes {
+			if _, err := tx.Exec(context.Background(), addRelationTupleQuery, dto.TenantId, tuple.Namespace, tuple.ObjectId, tuple.Relation, tuple.SubjectNamespace, tuple.SubjectId, tuple.SubjectRelation, revision); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.WriteRelationTuples"))
+		return -1, repoErr.ErrInternalServerError
+	}
+	return revision, nil
+}
+func (s *Store) GetRelationTuples(dto *repoDto.GetRelationTuples) ([]*entity.RelationTuple, error) {
+	rows, err := s.pool.Query(context.Background(), getRelationTuplesQuery, dto.TenantId, dto.Namespace, dto.ObjectId, dto.Relation, dto.SubjectNamespace, dto.SubjectId, dto.SubjectRelation, dto.Limit, dto.UsersetsOnly)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetRelationTuples"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	tuples, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*entity.RelationTuple, error) {
+		tuple := new(entity.RelationTuple)
+		err := row.Scan(&tuple.Namespace, &tuple.ObjectId, &tuple.Relation, &tuple.SubjectNamespace, &tuple.SubjectId, &tuple.SubjectRelation, &tuple.Revision, &tuple.CreatedAt)
+		return tuple, err
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetRelationTuples"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return tuples, nil
+}
+
+// GetRelationTupleRevision returns the revision of the last committed change, the reads made after it see the change
+func (s *Store) GetRelationTupleRevision() (int64, error) {
+	var revision int64
+	err := s.pool.QueryRow(context.Background(), getRelationTupleRevisionQuery).Scan(&revision)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetRelationTupleRevision"))
+		return -1, repoErr.ErrInternalServerError
+	}
+	return revision, nil
+}
+
+func (s *Store) GetLoginAttempt(keyType string, key string) (*entity.LoginAttempt, error) {
+	loginAttempt := new(entity.LoginAttempt)
+	err := s.pool.QueryRow(context.Background(), getLoginAttemptQuery, keyType, key).Scan(&loginAttempt.KeyType, &loginAttempt.Key, &loginAttempt.FailedCount, &loginAttempt.LastFailedAt, &loginAttempt.LockedUntil)
+	if err != nil {
+		// the absence of failed attempts is the usual case and is not logged
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetLoginAttempt"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return loginAttempt, nil
+}
+func (s *Store) AddLoginAttemptFailure(dto *repoDto.AddLoginAttemptFailure) (*entity.LoginAttempt, error) {
+	loginAttempt := new(entity.LoginAttempt)
+	err := s.pool.QueryRow(context.Background(), addLoginAttemptFailureQuery, dto.KeyType, dto.Key, dto.FailedAt, dto.ResetBefore).Scan(&loginAttempt.KeyType, &loginAttempt.Key, &loginAttempt.FailedCount, &loginAttempt.LastFailedAt, &loginAttempt.LockedUntil)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.AddLoginAttemptFailure"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return loginAttempt, nil
+}
+func (s *Store) UpdateLoginAttemptLockedUntil(dto *repoDto.UpdateLoginAttemptLockedUntil) error {
+	_, err := s.pool.Exec(context.Background(), updateLoginAttemptLockedUntilQuery, dto.KeyType, dto.Key, dto.LockedUntil)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.UpdateLoginAttemptLockedUntil"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) RemoveLoginAttempt(keyType string, key string) error {
+	_, err := s.pool.Exec(context.Background(), removeLoginAttemptQuery, keyType, key)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveLoginAttempt"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+func (s *Store) SetUserMfa(dto *repoDto.SetUserMfa) error {
+	userId := new(uuid.UUID)
+	err := s.pool.QueryRow(context.Background(), setUserMfaQuery, dto.UserId, dto.Secret).Scan(userId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.SetUserMfa"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrUniqueViolation
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) GetUserMfa(userId *uuid.UUID) (*entity.UserMfa, error) {
+	userMfa := new(entity.UserMfa)
+	err := s.pool.QueryRow(context.Background(), getUserMfaQuery, userId).Scan(&userMfa.UserId, &userMfa.Secret, &userMfa.IsConfirmed, &userMfa.LastUsedStep, &userMfa.CreatedAt)
+	if err != nil {
+		// most users have no mfa, it is not an error
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repoErr.ErrRecordNotFound
+		}
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetUserMfa"))
+		return nil, repoErr.ErrInternalServerError
+	}
+	return userMfa, nil
+}
+
+// ConfirmUserMfa enables the mfa together with the first set of the recovery codes
+func (s *Store) ConfirmUserMfa(dto *repoDto.SetMfaRecoveryCodes) error {
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		if err := tx.QueryRow(context.Background(), confirmUserMfaQuery, dto.UserId).Scan(new(uuid.UUID)); err != nil {
+			return err
+		}
+		return replaceMfaRecoveryCodes(tx, dto)
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.ConfirmUserMfa"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) UpdateUserMfaLastUsedStep(dto *repoDto.UpdateUserMfaLastUsedStep) error {
+	err := s.pool.QueryRow(context.Background(), updateUserMfaLastUsedStepQuery, dto.UserId, dto.LastUsedStep).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.UpdateUserMfaLastUsedStep"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) RemoveUserMfa(userId *uuid.UUID) error {
+	err := s.pool.QueryRow(context.Background(), removeUserMfaQuery, userId).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveUserMfa"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+
+// replaceMfaRecoveryCodes removes the recovery codes of the user and adds the new ones in the transaction
+func replaceMfaRecoveryCodes(tx pgx.Tx, dto *repoDto.SetMfaRecoveryCodes) error {
+	if _, err := tx.Exec(context.Background(), removeMfaRecoveryCodesQuery, dto.UserId); err != nil {
+		return err
+	}
+	for _, codeHash := range dto.CodeHashes {
+		if _, err := tx.Exec(context.Background(), addMfaRecoveryCodeQuery, dto.UserId, codeHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) SetMfaRecoveryCodes(dto *repoDto.SetMfaRecoveryCodes) error {
+	err := pgx.BeginFunc(context.Background(), s.pool, func(tx pgx.Tx) error {
+		return replaceMfaRecoveryCodes(tx, dto)
+	})
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.SetMfaRecoveryCodes"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) UseMfaRecoveryCode(dto *repoDto.UseMfaRecoveryCode) error {
+	err := s.pool.QueryRow(context.Background(), useMfaRecoveryCodeQuery, dto.UserId, dto.CodeHash, dto.UsedIp).Scan(new(uuid.UUID))
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.UseMfaRecoveryCode"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repoErr.ErrRecordNotFound
+		}
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
+func (s *Store) GetUnusedMfaRecoveryCodeCount(userId *uuid.UUID) (int64, error) {
+	var count int64
+	err := s.pool.QueryRow(context.Background(), getUnusedMfaRecoveryCodeCountQuery, userId).Scan(&count)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.GetUnusedMfaRecoveryCodeCount"))
+		return -1, repoErr.ErrInternalServerError
+	}
+	return count, nil
+}
+func (s *Store) RemoveMfaRecoveryCodes(userId *uuid.UUID) error {
+	_, err := s.pool.Exec(context.Background(), removeMfaRecoveryCodesQuery, userId)
+	if err != nil {
+		s.lg.Error(err.Error(), slog.String("owner", "store.RemoveMfaRecoveryCodes"))
+		return repoErr.ErrInternalServerError
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS public.login_attempt
+(
+    key_type character varying COLLATE pg_catalog."default" NOT NULL,
+    key character varying COLLATE pg_catalog."default" NOT NULL,
+    failed_count integer NOT NULL DEFAULT 0,
+    last_failed_at timestamp with time zone NOT NULL DEFAULT now(),
+    locked_until timestamp with time zone,
+    CONSTRAINT login_attempt_pk PRIMARY KEY (key_type, key)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS public.user_mfa
+(
+    user_id uuid NOT NULL,
+    secret character varying COLLATE pg_catalog."default" NOT NULL,
+    is_confirmed boolean NOT NULL DEFAULT false,
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT user_mfa_pk PRIMARY KEY (user_id),
+    CONSTRAINT user_mfa_user_id_fk FOREIGN KEY (user_id)
+        REFERENCES public."user" (user_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS public.mfa_recovery_code
+(
+    mfa_recovery_code_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    code_hash character varying COLLATE pg_catalog."default" NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    used_at timestamp with time zone,
+    used_ip character varying COLLATE pg_catalog."default",
+    CONSTRAINT mfa_recovery_code_pk PRIMARY KEY (mfa_recovery_code_id),
+    CONSTRAINT mfa_recovery_code_user_id_fk FOREIGN KEY (user_id)
+        REFERENCES public."user" (user_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS mfa_recovery_code_user_id_idx ON public.mfa_recovery_code (user_id);
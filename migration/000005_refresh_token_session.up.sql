@@ -0,0 +1,5 @@
+ALTER TABLE public.refresh_token
+    ADD COLUMN IF NOT EXISTS session_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    ADD COLUMN IF NOT EXISTS issued_at timestamp with time zone NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS created_at timestamp with time zone NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS refresh_token_user_id_idx ON public.refresh_token (user_id, session_id);
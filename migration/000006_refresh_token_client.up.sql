@@ -0,0 +1,5 @@
+ALTER TABLE public.refresh_token
+    ADD COLUMN IF NOT EXISTS client_ip character varying COLLATE pg_catalog."default" NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS user_agent character varying COLLATE pg_catalog."default" NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS client_version character varying COLLATE pg_catalog."default" NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS last_used_at timestamp with time zone NOT NULL DEFAULT now();
@@ -0,0 +1,2 @@
+ALTER TABLE public."user"
+    ADD COLUMN IF NOT EXISTS max_devices integer;
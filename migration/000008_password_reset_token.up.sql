@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS public.password_reset_token
+(
+    password_reset_token_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    token_hash character varying COLLATE pg_catalog."default" NOT NULL,
+    expiration_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    used_at timestamp with time zone,
+    CONSTRAINT password_reset_token_pk PRIMARY KEY (password_reset_token_id),
+    CONSTRAINT password_reset_token_token_hash_uq UNIQUE (token_hash),
+    CONSTRAINT password_reset_token_user_id_fk FOREIGN KEY (user_id)
+        REFERENCES public."user" (user_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS password_reset_token_user_id_idx ON public.password_reset_token (user_id);
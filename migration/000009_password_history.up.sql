@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS public.password_history
+(
+    password_history_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    password character varying COLLATE pg_catalog."default" NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT password_history_pk PRIMARY KEY (password_history_id),
+    CONSTRAINT password_history_user_id_fk FOREIGN KEY (user_id)
+        REFERENCES public."user" (user_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS password_history_user_id_idx ON public.password_history (user_id, created_at);
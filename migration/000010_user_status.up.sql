@@ -0,0 +1,20 @@
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS status character varying COLLATE pg_catalog."default" NOT NULL DEFAULT 'active';
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS status_changed_at timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE public."user" DROP CONSTRAINT IF EXISTS user_status_ck;
+ALTER TABLE public."user" ADD CONSTRAINT user_status_ck CHECK (status IN ('pending','active','locked','disabled','deleted'));
+
+CREATE TABLE IF NOT EXISTS public.user_status_history
+(
+    user_status_history_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    old_status character varying COLLATE pg_catalog."default" NOT NULL,
+    new_status character varying COLLATE pg_catalog."default" NOT NULL,
+    reason character varying COLLATE pg_catalog."default" NOT NULL,
+    changed_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT user_status_history_pk PRIMARY KEY (user_status_history_id),
+    CONSTRAINT user_status_history_user_id_fk FOREIGN KEY (user_id)
+        REFERENCES public."user" (user_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS user_status_history_user_id_idx ON public.user_status_history (user_id, changed_at);
@@ -0,0 +1,3 @@
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS deleted_login character varying COLLATE pg_catalog."default";
+CREATE INDEX IF NOT EXISTS user_deleted_at_idx ON public."user" (deleted_at) WHERE status = 'deleted';
@@ -0,0 +1,22 @@
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS email character varying COLLATE pg_catalog."default";
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS email_verified_at timestamp with time zone;
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS pending_email character varying COLLATE pg_catalog."default";
+CREATE UNIQUE INDEX IF NOT EXISTS user_email_uq ON public."user" (email) WHERE email IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS public.email_verification_token
+(
+    email_verification_token_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    email character varying COLLATE pg_catalog."default" NOT NULL,
+    token_hash character varying COLLATE pg_catalog."default" NOT NULL,
+    expiration_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    used_at timestamp with time zone,
+    CONSTRAINT email_verification_token_pk PRIMARY KEY (email_verification_token_id),
+    CONSTRAINT email_verification_token_token_hash_uq UNIQUE (token_hash),
+    CONSTRAINT email_verification_token_user_id_fk FOREIGN KEY (user_id)
+        REFERENCES public."user" (user_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS email_verification_token_user_id_idx ON public.email_verification_token (user_id);
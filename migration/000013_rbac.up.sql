@@ -0,0 +1,52 @@
+CREATE TABLE IF NOT EXISTS public.role
+(
+    role_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    name character varying COLLATE pg_catalog."default" NOT NULL,
+    description character varying COLLATE pg_catalog."default" NOT NULL DEFAULT '',
+    max_devices integer,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT role_pk PRIMARY KEY (role_id),
+    CONSTRAINT role_name_uq UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS public.permission
+(
+    permission_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    name character varying COLLATE pg_catalog."default" NOT NULL,
+    description character varying COLLATE pg_catalog."default" NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT permission_pk PRIMARY KEY (permission_id),
+    CONSTRAINT permission_name_uq UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS public.role_permission
+(
+    role_id uuid NOT NULL,
+    permission_id uuid NOT NULL,
+    CONSTRAINT role_permission_pk PRIMARY KEY (role_id, permission_id),
+    CONSTRAINT role_permission_role_id_fk FOREIGN KEY (role_id)
+        REFERENCES public.role (role_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT role_permission_permission_id_fk FOREIGN KEY (permission_id)
+        REFERENCES public.permission (permission_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS public.user_role
+(
+    user_id uuid NOT NULL,
+    role_id uuid NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT user_role_pk PRIMARY KEY (user_id, role_id),
+    CONSTRAINT user_role_user_id_fk FOREIGN KEY (user_id)
+        REFERENCES public."user" (user_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT user_role_role_id_fk FOREIGN KEY (role_id)
+        REFERENCES public.role (role_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS user_role_role_id_idx ON public.user_role (role_id);
@@ -0,0 +1 @@
+ALTER TABLE public.role_permission ADD COLUMN IF NOT EXISTS condition character varying COLLATE pg_catalog."default" NOT NULL DEFAULT '';
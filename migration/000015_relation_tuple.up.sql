@@ -0,0 +1,23 @@
+-- the single row counts the changes of the tuples, the writes are serialized on it
+CREATE TABLE IF NOT EXISTS public.relation_tuple_revision
+(
+    id boolean NOT NULL DEFAULT true,
+    revision bigint NOT NULL DEFAULT 0,
+    CONSTRAINT relation_tuple_revision_pk PRIMARY KEY (id),
+    CONSTRAINT relation_tuple_revision_id_check CHECK (id)
+);
+INSERT INTO public.relation_tuple_revision (id, revision) VALUES (true, 0) ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS public.relation_tuple
+(
+    namespace character varying COLLATE pg_catalog."default" NOT NULL,
+    object_id character varying COLLATE pg_catalog."default" NOT NULL,
+    relation character varying COLLATE pg_catalog."default" NOT NULL,
+    subject_namespace character varying COLLATE pg_catalog."default" NOT NULL,
+    subject_id character varying COLLATE pg_catalog."default" NOT NULL,
+    subject_relation character varying COLLATE pg_catalog."default" NOT NULL DEFAULT '',
+    revision bigint NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT relation_tuple_pk PRIMARY KEY (namespace, object_id, relation, subject_namespace, subject_id, subject_relation)
+);
+CREATE INDEX IF NOT EXISTS relation_tuple_subject_idx ON public.relation_tuple (subject_namespace, subject_id, subject_relation);
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS public.tenant
+(
+    tenant_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    name character varying COLLATE pg_catalog."default" NOT NULL,
+    access_lifetime_seconds bigint,
+    refresh_lifetime_seconds bigint,
+    password_policy jsonb,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT tenant_pk PRIMARY KEY (tenant_id),
+    CONSTRAINT tenant_name_uq UNIQUE (name)
+);
+-- the users registered before the tenants belong to the default tenant
+INSERT INTO public.tenant (tenant_id, name) VALUES ('00000000-0000-0000-0000-000000000000', 'default') ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS public.tenant_signing_key
+(
+    key_id character varying COLLATE pg_catalog."default" NOT NULL,
+    tenant_id uuid NOT NULL,
+    private_key character varying COLLATE pg_catalog."default" NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT tenant_signing_key_pk PRIMARY KEY (key_id),
+    CONSTRAINT tenant_signing_key_tenant_id_fk FOREIGN KEY (tenant_id)
+        REFERENCES public.tenant (tenant_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS tenant_signing_key_tenant_id_idx ON public.tenant_signing_key (tenant_id, created_at);
+
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+ALTER TABLE public."user" DROP CONSTRAINT IF EXISTS user_tenant_id_fk;
+ALTER TABLE public."user" ADD CONSTRAINT user_tenant_id_fk FOREIGN KEY (tenant_id)
+    REFERENCES public.tenant (tenant_id) MATCH SIMPLE
+    ON UPDATE CASCADE
+    ON DELETE RESTRICT;
+-- logins and emails are unique within the tenant
+ALTER TABLE public."user" DROP CONSTRAINT IF EXISTS user_login_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS user_tenant_id_login_uq ON public."user" (tenant_id, login);
+DROP INDEX IF EXISTS public.user_email_uq;
+CREATE UNIQUE INDEX IF NOT EXISTS user_tenant_id_email_uq ON public."user" (tenant_id, email) WHERE email IS NOT NULL;
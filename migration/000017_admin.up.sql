@@ -0,0 +1,6 @@
+-- the users created before the column get the time of the migration
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS created_at timestamp with time zone NOT NULL DEFAULT now();
+CREATE INDEX IF NOT EXISTS user_created_at_idx ON public."user" (created_at, user_id);
+
+-- the callers of the AdminService must have the role, the first admin is assigned in the database
+INSERT INTO public.role (name, description) VALUES ('admin', 'access to the AdminService') ON CONFLICT DO NOTHING;
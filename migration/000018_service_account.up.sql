@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS public.service_account
+(
+    service_account_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    client_id character varying COLLATE pg_catalog."default" NOT NULL,
+    name character varying COLLATE pg_catalog."default" NOT NULL,
+    scopes character varying[] NOT NULL DEFAULT '{}',
+    is_disabled boolean NOT NULL DEFAULT false,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT service_account_pk PRIMARY KEY (service_account_id),
+    CONSTRAINT service_account_client_id_uq UNIQUE (client_id)
+);
+-- several secrets let the clients rotate them without a downtime
+CREATE TABLE IF NOT EXISTS public.service_account_secret
+(
+    service_account_secret_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    service_account_id uuid NOT NULL,
+    secret_hash character varying COLLATE pg_catalog."default" NOT NULL,
+    expiration_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT service_account_secret_pk PRIMARY KEY (service_account_secret_id),
+    CONSTRAINT service_account_secret_service_account_id_fk FOREIGN KEY (service_account_id)
+        REFERENCES public.service_account (service_account_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS service_account_secret_service_account_id_idx ON public.service_account_secret (service_account_id);
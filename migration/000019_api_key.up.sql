@@ -0,0 +1,27 @@
+-- the key belongs either to a user or to a service account
+CREATE TABLE IF NOT EXISTS public.api_key
+(
+    api_key_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    user_id uuid,
+    service_account_id uuid,
+    name character varying COLLATE pg_catalog."default" NOT NULL,
+    prefix character varying COLLATE pg_catalog."default" NOT NULL,
+    key_hash character varying COLLATE pg_catalog."default" NOT NULL,
+    scopes character varying[] NOT NULL DEFAULT '{}',
+    expiration_at timestamp with time zone,
+    last_used_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT api_key_pk PRIMARY KEY (api_key_id),
+    CONSTRAINT api_key_prefix_uq UNIQUE (prefix),
+    CONSTRAINT api_key_owner_ck CHECK ((user_id IS NULL) <> (service_account_id IS NULL)),
+    CONSTRAINT api_key_user_id_fk FOREIGN KEY (user_id)
+        REFERENCES public."user" (user_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT api_key_service_account_id_fk FOREIGN KEY (service_account_id)
+        REFERENCES public.service_account (service_account_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS api_key_user_id_idx ON public.api_key (user_id);
+CREATE INDEX IF NOT EXISTS api_key_service_account_id_idx ON public.api_key (service_account_id);
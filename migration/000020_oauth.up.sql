@@ -0,0 +1,42 @@
+-- the public clients (SPA, mobile) have no secret and must use PKCE
+CREATE TABLE IF NOT EXISTS public.oauth_client
+(
+    oauth_client_id uuid NOT NULL DEFAULT gen_random_uuid(),
+    client_id character varying COLLATE pg_catalog."default" NOT NULL,
+    name character varying COLLATE pg_catalog."default" NOT NULL,
+    client_type character varying COLLATE pg_catalog."default" NOT NULL,
+    secret_hash character varying COLLATE pg_catalog."default",
+    redirect_uris character varying[] NOT NULL,
+    scopes character varying[] NOT NULL DEFAULT '{}',
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT oauth_client_pk PRIMARY KEY (oauth_client_id),
+    CONSTRAINT oauth_client_client_id_uq UNIQUE (client_id),
+    CONSTRAINT oauth_client_client_type_ck CHECK (client_type IN ('public','confidential')),
+    CONSTRAINT oauth_client_secret_hash_ck CHECK ((client_type = 'confidential') = (secret_hash IS NOT NULL))
+);
+-- the codes are single use, the exchange deletes the code
+CREATE TABLE IF NOT EXISTS public.oauth_authorization_code
+(
+    code_hash character varying COLLATE pg_catalog."default" NOT NULL,
+    oauth_client_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    redirect_uri character varying COLLATE pg_catalog."default" NOT NULL,
+    redirect_uri_sent boolean NOT NULL,
+    scopes character varying[] NOT NULL DEFAULT '{}',
+    code_challenge character varying COLLATE pg_catalog."default",
+    auth_time timestamp with time zone NOT NULL,
+    expiration_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    CONSTRAINT oauth_authorization_code_pk PRIMARY KEY (code_hash),
+    CONSTRAINT oauth_authorization_code_oauth_client_id_fk FOREIGN KEY (oauth_client_id)
+        REFERENCES public.oauth_client (oauth_client_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE,
+    CONSTRAINT oauth_authorization_code_user_id_fk FOREIGN KEY (user_id)
+        REFERENCES public."user" (user_id) MATCH SIMPLE
+        ON UPDATE CASCADE
+        ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS oauth_authorization_code_expiration_at_idx ON public.oauth_authorization_code (expiration_at);
+-- scopes granted to the OAuth client, NULL for the first-party logins
+ALTER TABLE public.refresh_token ADD COLUMN IF NOT EXISTS scopes character varying[];
@@ -0,0 +1,3 @@
+-- the OpenID Connect nonce of the authorization request and the authentication context class of the login
+ALTER TABLE public.oauth_authorization_code ADD COLUMN IF NOT EXISTS nonce character varying COLLATE pg_catalog."default";
+ALTER TABLE public.oauth_authorization_code ADD COLUMN IF NOT EXISTS acr character varying COLLATE pg_catalog."default" NOT NULL DEFAULT '1';
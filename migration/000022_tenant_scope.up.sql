@@ -0,0 +1,51 @@
+-- the roles, the permissions, the relation tuples, the service accounts and the OAuth clients belong to a tenant,
+-- the existing ones to the default tenant
+ALTER TABLE public.role ADD COLUMN IF NOT EXISTS tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+ALTER TABLE public.role DROP CONSTRAINT IF EXISTS role_tenant_id_fk;
+ALTER TABLE public.role ADD CONSTRAINT role_tenant_id_fk FOREIGN KEY (tenant_id)
+    REFERENCES public.tenant (tenant_id) MATCH SIMPLE
+    ON UPDATE CASCADE
+    ON DELETE CASCADE;
+ALTER TABLE public.role DROP CONSTRAINT IF EXISTS role_name_uq;
+CREATE UNIQUE INDEX IF NOT EXISTS role_tenant_id_name_uq ON public.role (tenant_id, name);
+
+ALTER TABLE public.permission ADD COLUMN IF NOT EXISTS tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+ALTER TABLE public.permission DROP CONSTRAINT IF EXISTS permission_tenant_id_fk;
+ALTER TABLE public.permission ADD CONSTRAINT permission_tenant_id_fk FOREIGN KEY (tenant_id)
+    REFERENCES public.tenant (tenant_id) MATCH SIMPLE
+    ON UPDATE CASCADE
+    ON DELETE CASCADE;
+ALTER TABLE public.permission DROP CONSTRAINT IF EXISTS permission_name_uq;
+CREATE UNIQUE INDEX IF NOT EXISTS permission_tenant_id_name_uq ON public.permission (tenant_id, name);
+
+ALTER TABLE public.relation_tuple ADD COLUMN IF NOT EXISTS tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+ALTER TABLE public.relation_tuple DROP CONSTRAINT IF EXISTS relation_tuple_tenant_id_fk;
+ALTER TABLE public.relation_tuple ADD CONSTRAINT relation_tuple_tenant_id_fk FOREIGN KEY (tenant_id)
+    REFERENCES public.tenant (tenant_id) MATCH SIMPLE
+    ON UPDATE CASCADE
+    ON DELETE CASCADE;
+ALTER TABLE public.relation_tuple DROP CONSTRAINT IF EXISTS relation_tuple_pk;
+ALTER TABLE public.relation_tuple ADD CONSTRAINT relation_tuple_pk PRIMARY KEY (tenant_id, namespace, object_id, relation, subject_namespace, subject_id, subject_relation);
+DROP INDEX IF EXISTS public.relation_tuple_subject_idx;
+CREATE INDEX IF NOT EXISTS relation_tuple_subject_idx ON public.relation_tuple (tenant_id, subject_namespace, subject_id, subject_relation);
+
+-- the client ids stay globally unique, the grants find the account and the client by them alone
+ALTER TABLE public.service_account ADD COLUMN IF NOT EXISTS tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+ALTER TABLE public.service_account DROP CONSTRAINT IF EXISTS service_account_tenant_id_fk;
+ALTER TABLE public.service_account ADD CONSTRAINT service_account_tenant_id_fk FOREIGN KEY (tenant_id)
+    REFERENCES public.tenant (tenant_id) MATCH SIMPLE
+    ON UPDATE CASCADE
+    ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS service_account_tenant_id_idx ON public.service_account (tenant_id, name);
+
+ALTER TABLE public.oauth_client ADD COLUMN IF NOT EXISTS tenant_id uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+ALTER TABLE public.oauth_client DROP CONSTRAINT IF EXISTS oauth_client_tenant_id_fk;
+ALTER TABLE public.oauth_client ADD CONSTRAINT oauth_client_tenant_id_fk FOREIGN KEY (tenant_id)
+    REFERENCES public.tenant (tenant_id) MATCH SIMPLE
+    ON UPDATE CASCADE
+    ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS oauth_client_tenant_id_idx ON public.oauth_client (tenant_id, name);
+
+-- the users keep only the roles of their own tenant
+DELETE FROM public.user_role ur USING public."user" u, public.role r
+WHERE u.user_id=ur.user_id AND r.role_id=ur.role_id AND r.tenant_id<>u.tenant_id;
@@ -0,0 +1,2 @@
+-- the status of the user before the deletion, the restore brings it back
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS deleted_status character varying COLLATE pg_catalog."default";
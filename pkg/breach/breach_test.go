@@ -0,0 +1,140 @@
+package breach
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func hashHex(password string) string {
+	hash := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+// buildIndex writes the index of the corpus lines in the file format to a temporary file
+func buildIndex(t *testing.T, lines []string) string {
+	t.Helper()
+	dir := t.TempDir()
+	corpusPath := filepath.Join(dir, "corpus.txt")
+	if err := os.WriteFile(corpusPath, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	w, err := NewWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Build(corpusPath, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	indexPath := filepath.Join(dir, "breach.idx")
+	if err := os.WriteFile(indexPath, buffer.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return indexPath
+}
+
+func TestLookup(t *testing.T) {
+	passwords := map[string]string{}
+	for _, password := range []string{"password", "123456", "qwerty", "letmein"} {
+		passwords[hashHex(password)] = password
+	}
+	var hashes []string
+	for hash := range passwords {
+		hashes = append(hashes, hash)
+	}
+	// the corpus is ordered by hash, the repeated hash is summed up
+	slices.Sort(hashes)
+	lines := []string{hashes[0] + ":3", hashes[0] + ":4"}
+	for _, hash := range hashes[1:] {
+		lines = append(lines, hash+":10")
+	}
+	index, err := Open(buildIndex(t, lines))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	if index.Len() != int64(len(hashes)) {
+		t.Errorf("Len() = %d, want %d", index.Len(), len(hashes))
+	}
+	tests := []struct {
+		password string
+		count    int
+	}{
+		{passwords[hashes[0]], 7},
+		{passwords[hashes[1]], 10},
+		{passwords[hashes[len(hashes)-1]], 10},
+		{"correct horse battery staple", 0},
+	}
+	for _, tt := range tests {
+		count, err := index.Lookup(tt.password)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", tt.password, err)
+		}
+		if count != tt.count {
+			t.Errorf("Lookup(%q) = %d, want %d", tt.password, count, tt.count)
+		}
+	}
+}
+
+func TestWriterAdd(t *testing.T) {
+	low, high := bytes.Repeat([]byte{0x01}, hashSize), bytes.Repeat([]byte{0x02}, hashSize)
+	tests := []struct {
+		name    string
+		hashes  [][]byte
+		counts  []uint32
+		wantErr bool
+	}{
+		{"ascending", [][]byte{low, high}, []uint32{1, 1}, false},
+		{"repeated", [][]byte{low, low}, []uint32{math.MaxUint32, 1}, false},
+		{"not sorted", [][]byte{high, low}, []uint32{1, 1}, true},
+		{"short hash", [][]byte{low[:10]}, []uint32{1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := NewWriter(new(bytes.Buffer))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, hash := range tt.hashes {
+				if err = w.Add(hash, tt.counts[i]); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"no header", "notbreach"},
+		{"partial record", magic + "123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "breach.idx")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Open(path); !errors.Is(err, ErrInvalidIndex) {
+				t.Errorf("Open() error = %v, want %v", err, ErrInvalidIndex)
+			}
+		})
+	}
+}
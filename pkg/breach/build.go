@@ -0,0 +1,153 @@
+package breach
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Writer writes the index from the hashes given in the ascending order
+type Writer struct {
+	w     *bufio.Writer
+	last  []byte
+	count uint32
+	total int64
+}
+
+func NewWriter(w io.Writer) (*Writer, error) {
+	bw := bufio.NewWriterSize(w, 1<<20)
+	if _, err := bw.WriteString(magic); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bw}, nil
+}
+
+// Add adds the hash, the counts of the repeated hash are summed up
+func (w *Writer) Add(hash []byte, count uint32) error {
+	if len(hash) != hashSize {
+		return fmt.Errorf("invalid hash length %d", len(hash))
+	}
+	if w.last != nil {
+		switch bytes.Compare(w.last, hash) {
+		case 0:
+			w.count = addCount(w.count, count)
+			return nil
+		case 1:
+			return fmt.Errorf("hashes are not sorted: %X after %X", hash, w.last)
+		}
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+	w.last = append(w.last[:0], hash...)
+	w.count = count
+	return nil
+}
+
+// Close writes the last hash, it does not close the underlying writer
+func (w *Writer) Close() error {
+	if w.last != nil {
+		if err := w.flush(); err != nil {
+			return err
+		}
+		w.last = nil
+	}
+	return w.w.Flush()
+}
+
+// Len returns the number of hashes written so far
+func (w *Writer) Len() int64 {
+	return w.total
+}
+func (w *Writer) flush() error {
+	record := make([]byte, recordSize)
+	copy(record, w.last)
+	binary.BigEndian.PutUint32(record[hashSize:], w.count)
+	if _, err := w.w.Write(record); err != nil {
+		return err
+	}
+	w.total++
+	return nil
+}
+func addCount(a uint32, b uint32) uint32 {
+	if uint64(a)+uint64(b) > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return a + b
+}
+
+// Build writes the index from the corpus in the HIBP formats: either a directory of range files named by
+// the 5 character hash prefix with SUFFIX:COUNT lines, or a single file with HASH:COUNT lines ordered by hash
+func Build(corpusPath string, w *Writer) error {
+	info, err := os.Stat(corpusPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return addFile(corpusPath, "", w)
+	}
+	entries, err := os.ReadDir(corpusPath)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToUpper(names[i]) < strings.ToUpper(names[j])
+	})
+	for _, name := range names {
+		prefix := strings.TrimSuffix(name, filepath.Ext(name))
+		if len(prefix) != 5 {
+			continue
+		}
+		if err := addFile(filepath.Join(corpusPath, name), prefix, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func addFile(path string, prefix string, w *Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		hashValue, countValue, ok := strings.Cut(text, ":")
+		if !ok {
+			return fmt.Errorf("%s:%d: invalid line", path, line)
+		}
+		hash, err := hex.DecodeString(prefix + hashValue)
+		if err != nil || len(hash) != hashSize {
+			return fmt.Errorf("%s:%d: invalid hash", path, line)
+		}
+		count, err := strconv.ParseUint(countValue, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid count", path, line)
+		}
+		if err := w.Add(hash, uint32(count)); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
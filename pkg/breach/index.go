@@ -0,0 +1,80 @@
+// Package breach looks passwords up in a local corpus of breached password hashes.
+//
+// The index file starts with the magic header followed by records of the 20 byte SHA-1 hash and the
+// 4 byte big-endian count, sorted by the hash. A lookup is a binary search over the file.
+package breach
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"os"
+)
+
+const (
+	magic      = "ppbreach"
+	hashSize   = sha1.Size
+	recordSize = hashSize + 4
+)
+
+var ErrInvalidIndex = errors.New("invalid breach index file")
+
+type Index struct {
+	file  *os.File
+	count int64
+}
+
+func Open(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	header := make([]byte, len(magic))
+	if _, err := file.ReadAt(header, 0); err != nil || string(header) != magic || (info.Size()-int64(len(magic)))%recordSize != 0 {
+		file.Close()
+		return nil, ErrInvalidIndex
+	}
+	return &Index{
+		file:  file,
+		count: (info.Size() - int64(len(magic))) / recordSize,
+	}, nil
+}
+func (i *Index) Close() error {
+	return i.file.Close()
+}
+
+// Len returns the number of hashes in the index
+func (i *Index) Len() int64 {
+	return i.count
+}
+
+// Lookup returns how many times the password was seen in the breaches, 0 if never
+func (i *Index) Lookup(password string) (int, error) {
+	hash := sha1.Sum([]byte(password))
+	return i.LookupHash(hash)
+}
+func (i *Index) LookupHash(hash [hashSize]byte) (int, error) {
+	record := make([]byte, recordSize)
+	low, high := int64(0), i.count
+	for low < high {
+		middle := low + (high-low)/2
+		if _, err := i.file.ReadAt(record, int64(len(magic))+middle*recordSize); err != nil {
+			return 0, err
+		}
+		switch bytes.Compare(record[:hashSize], hash[:]) {
+		case 0:
+			return int(binary.BigEndian.Uint32(record[hashSize:])), nil
+		case -1:
+			low = middle + 1
+		default:
+			high = middle
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,198 @@
+// Package condition evaluates the attribute conditions of the permission grants.
+// A condition is a conjunction of comparisons, e.g.
+//
+//	resource.owner == subject.id && resource.tenant == subject.tenant
+//
+// An operand is an attribute of the subject or of the resource, or a literal in double quotes
+package condition
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	scopeSubject  = "subject"
+	scopeResource = "resource"
+)
+
+type operand struct {
+	// empty for literals
+	scope string
+	value string
+}
+
+func (o *operand) resolve(subject, resource map[string]string) (string, bool) {
+	switch o.scope {
+	case scopeSubject:
+		value, ok := subject[o.value]
+		return value, ok
+	case scopeResource:
+		value, ok := resource[o.value]
+		return value, ok
+	}
+	return o.value, true
+}
+func (o *operand) String() string {
+	if o.scope == "" {
+		return strconv.Quote(o.value)
+	}
+	return o.scope + "." + o.value
+}
+
+type comparison struct {
+	left  operand
+	equal bool
+	right operand
+}
+
+func (c *comparison) String() string {
+	if c.equal {
+		return c.left.String() + " == " + c.right.String()
+	}
+	return c.left.String() + " != " + c.right.String()
+}
+
+// Condition is a parsed condition, the zero value is always met
+type Condition struct {
+	comparisons []comparison
+}
+
+func parseOperand(s string) (operand, error) {
+	if strings.HasPrefix(s, `"`) {
+		value, err := strconv.Unquote(s)
+		if err != nil {
+			return operand{}, fmt.Errorf("invalid literal %s", s)
+		}
+		return operand{value: value}, nil
+	}
+	scope, name, ok := strings.Cut(s, ".")
+	if !ok || name == "" || (scope != scopeSubject && scope != scopeResource) {
+		return operand{}, fmt.Errorf("invalid operand %q, subject.<name>, resource.<name> or a quoted literal is expected", s)
+	}
+	return operand{scope: scope, value: name}, nil
+}
+
+type tokenKind int
+
+const (
+	tokenOperand tokenKind = iota
+	tokenAnd
+	tokenEqual
+	tokenNotEqual
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func isNameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_' || b == '.' || b == '-'
+}
+
+// tokenize splits the condition into the operands and the operators. The literals are taken as a whole,
+// so the operators inside the quotes are a part of the value
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "&&"):
+			tokens = append(tokens, token{kind: tokenAnd, text: "&&"})
+			i += 2
+		case strings.HasPrefix(s[i:], "=="):
+			tokens = append(tokens, token{kind: tokenEqual, text: "=="})
+			i += 2
+		case strings.HasPrefix(s[i:], "!="):
+			tokens = append(tokens, token{kind: tokenNotEqual, text: "!="})
+			i += 2
+		case s[i] == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				// the escaped character never ends the literal
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated literal %s", s[i:])
+			}
+			tokens = append(tokens, token{kind: tokenOperand, text: s[i : j+1]})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && isNameByte(s[j]) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("unexpected character %q", s[i])
+			}
+			tokens = append(tokens, token{kind: tokenOperand, text: s[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// Parse parses the condition, the empty string is the condition that is always met
+func Parse(s string) (*Condition, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Condition)
+	for i := 0; i < len(tokens); {
+		if i+3 > len(tokens) || tokens[i].kind != tokenOperand || (tokens[i+1].kind != tokenEqual && tokens[i+1].kind != tokenNotEqual) || tokens[i+2].kind != tokenOperand {
+			return nil, fmt.Errorf("invalid comparison at %q, <operand> == <operand> or <operand> != <operand> is expected", tokens[i].text)
+		}
+		left, err := parseOperand(tokens[i].text)
+		if err != nil {
+			return nil, err
+		}
+		right, err := parseOperand(tokens[i+2].text)
+		if err != nil {
+			return nil, err
+		}
+		c.comparisons = append(c.comparisons, comparison{left: left, equal: tokens[i+1].kind == tokenEqual, right: right})
+		i += 3
+		if i == len(tokens) {
+			break
+		}
+		if tokens[i].kind != tokenAnd {
+			return nil, fmt.Errorf("invalid condition at %q, && is expected", tokens[i].text)
+		}
+		i++
+		if i == len(tokens) {
+			return nil, fmt.Errorf("invalid condition, a comparison is expected after &&")
+		}
+	}
+	return c, nil
+}
+
+// Evaluate reports whether the condition is met. A comparison with a missing attribute is never met.
+// For an unmet condition the failed comparison is returned with the values it was evaluated with
+func (c *Condition) Evaluate(subject, resource map[string]string) (bool, string) {
+	for _, cmp := range c.comparisons {
+		left, leftOk := cmp.left.resolve(subject, resource)
+		right, rightOk := cmp.right.resolve(subject, resource)
+		if !leftOk || !rightOk {
+			return false, fmt.Sprintf("%s: attribute is missing", cmp.String())
+		}
+		if (left == right) != cmp.equal {
+			return false, fmt.Sprintf("%s: %q, %q", cmp.String(), left, right)
+		}
+	}
+	return true, ""
+}
+
+// String returns the condition in the canonical form
+func (c *Condition) String() string {
+	parts := make([]string, 0, len(c.comparisons))
+	for _, cmp := range c.comparisons {
+		parts = append(parts, cmp.String())
+	}
+	return strings.Join(parts, " && ")
+}
@@ -0,0 +1,70 @@
+package condition
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		canonical string
+		wantErr   bool
+	}{
+		{"empty", "", "", false},
+		{"attributes", "resource.owner == subject.id", "resource.owner == subject.id", false},
+		{"conjunction", "resource.owner==subject.id&&resource.tenant != subject.tenant",
+			"resource.owner == subject.id && resource.tenant != subject.tenant", false},
+		{"literal with operators", `resource.kind == "a && b == c"`, `resource.kind == "a && b == c"`, false},
+		{"escaped quote", `resource.kind == "a\"b"`, `resource.kind == "a\"b"`, false},
+		{"unknown scope", "user.id == subject.id", "", true},
+		{"missing name", "resource. == subject.id", "", true},
+		{"missing operator", "resource.owner subject.id", "", true},
+		{"trailing and", "resource.owner == subject.id &&", "", true},
+		{"unterminated literal", `resource.kind == "a`, "", true},
+		{"unexpected character", "resource.owner == subject.id || true", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse(tt.condition)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.condition, err, tt.wantErr)
+			}
+			if err == nil && c.String() != tt.canonical {
+				t.Errorf("Parse(%q) = %q, want %q", tt.condition, c.String(), tt.canonical)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	subject := map[string]string{"id": "u1", "tenant": "t1"}
+	tests := []struct {
+		name      string
+		condition string
+		resource  map[string]string
+		want      bool
+	}{
+		{"empty", "", nil, true},
+		{"equal", "resource.owner == subject.id", map[string]string{"owner": "u1"}, true},
+		{"not equal", "resource.owner == subject.id", map[string]string{"owner": "u2"}, false},
+		{"inequality", "resource.owner != subject.id", map[string]string{"owner": "u2"}, true},
+		{"missing attribute", "resource.owner != subject.id", nil, false},
+		{"literal", `resource.kind == "a && b"`, map[string]string{"kind": "a && b"}, true},
+		{"conjunction", "resource.owner == subject.id && resource.tenant == subject.tenant",
+			map[string]string{"owner": "u1", "tenant": "t2"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse(tt.condition)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.condition, err)
+			}
+			got, reason := c.Evaluate(subject, tt.resource)
+			if got != tt.want {
+				t.Errorf("Evaluate(%q) = %v (%s), want %v", tt.condition, got, reason, tt.want)
+			}
+			if !got && reason == "" {
+				t.Errorf("Evaluate(%q) has no reason", tt.condition)
+			}
+		})
+	}
+}
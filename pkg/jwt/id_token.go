@@ -0,0 +1,42 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IdTokenClaims are the claims of the OpenID Connect ID token, the subject and the audience are in the registered claims
+type IdTokenClaims struct {
+	// time of the authentication of the user
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// the value of the authorization request, the client checks it against the replay
+	Nonce string `json:"nonce,omitempty"`
+	// hash of the access token issued together with the ID token
+	AtHash string `json:"at_hash,omitempty"`
+	// authentication context class and the methods of the authentication
+	Acr string   `json:"acr,omitempty"`
+	Amr []string `json:"amr,omitempty"`
+	// the client the token was issued to
+	Azp string `json:"azp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// CreateIdToken signs the ID token of the authentication made at authTime, the issue and the expiration times are set here
+func CreateIdToken(idTokenClaims *IdTokenClaims, authTime time.Time, lifetime time.Duration, signingKey *SigningKey) (string, error) {
+	now := time.Now()
+	idTokenClaims.AuthTime = jwt.NewNumericDate(authTime)
+	idTokenClaims.IssuedAt = jwt.NewNumericDate(now)
+	idTokenClaims.ExpiresAt = jwt.NewNumericDate(now.Add(lifetime))
+	tokenJwt := jwt.NewWithClaims(jwt.SigningMethodRS256, idTokenClaims)
+	tokenJwt.Header["kid"] = signingKey.Id
+	return tokenJwt.SignedString(signingKey.PrivateKey)
+}
+
+// AtHash is the left half of the SHA-256 hash of the access token, the hash of the RS256 algorithm
+func AtHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
@@ -0,0 +1,37 @@
+package jwt
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+)
+
+// Jwk is the public RSA key in the JSON Web Key form (RFC 7517)
+type Jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func NewJwk(keyId string, publicKey *rsa.PublicKey) *Jwk {
+	return &Jwk{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: keyId,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+// KeyId returns the JWK thumbprint of the key (RFC 7638), so the id does not change while the key does not
+func KeyId(publicKey *rsa.PublicKey) string {
+	jwk := NewJwk("", publicKey)
+	// the members are required in the lexicographic order without spaces
+	sum := sha256.Sum256([]byte(`{"e":"` + jwk.E + `","kty":"RSA","n":"` + jwk.N + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -2,6 +2,8 @@ package jwt
 
 import (
 	"crypto/rsa"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -21,10 +23,97 @@ type TokenClaims struct {
 	Sub        *uuid.UUID `json:"sub"`
 	DeviceCode string     `json:"device"`
 	TokenType  string     `json:"type"`
+	// time of the authentication the token descends from
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// roles and permissions of the user, AuthzTruncated is set when they did not fit into the token
+	Roles          []string `json:"roles,omitempty"`
+	Permissions    []string `json:"perms,omitempty"`
+	AuthzTruncated bool     `json:"authz_truncated,omitempty"`
+	// tenant of the user
+	Tenant *uuid.UUID `json:"tenant,omitempty"`
+	// kind of the subject, empty for the users
+	SubjectType string `json:"sub_type,omitempty"`
+	// space separated scopes the token is limited to
+	Scope string `json:"scope,omitempty"`
+	// prefix of the api key the token was exchanged for
+	ApiKey string `json:"api_key,omitempty"`
+	// the OAuth client the token was issued to, it is the audience of the token too
+	ClientId string `json:"client_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func CreateToken(userId *uuid.UUID, deviceCode string, tokenType string, lifetime time.Duration, privateKey *rsa.PrivateKey) (string, *TokenClaims, error) {
+// SigningKey is the private key with the id that is put into the kid header of the tokens
+type SigningKey struct {
+	Id         string
+	PrivateKey *rsa.PrivateKey
+}
+
+// KeyFunc returns the public key by the kid header of the token
+type KeyFunc func(keyId string) (*rsa.PublicKey, error)
+
+// SubjectTypeServiceAccount is the subject type of the tokens of the service accounts
+const SubjectTypeServiceAccount = "service_account"
+
+// Option sets the optional claims of the token
+type Option func(*TokenClaims)
+
+func WithAuthTime(authTime time.Time) Option {
+	return func(tokenClaims *TokenClaims) {
+		tokenClaims.AuthTime = jwt.NewNumericDate(authTime)
+	}
+}
+
+func WithAuthorization(roles []string, permissions []string, truncated bool) Option {
+	return func(tokenClaims *TokenClaims) {
+		tokenClaims.Roles = roles
+		tokenClaims.Permissions = permissions
+		tokenClaims.AuthzTruncated = truncated
+	}
+}
+
+func WithTenant(tenantId *uuid.UUID) Option {
+	return func(tokenClaims *TokenClaims) {
+		tokenClaims.Tenant = tenantId
+	}
+}
+
+func WithSubjectType(subjectType string) Option {
+	return func(tokenClaims *TokenClaims) {
+		tokenClaims.SubjectType = subjectType
+	}
+}
+
+func WithScopes(scopes []string) Option {
+	return func(tokenClaims *TokenClaims) {
+		tokenClaims.Scope = strings.Join(scopes, " ")
+	}
+}
+
+func WithApiKey(prefix string) Option {
+	return func(tokenClaims *TokenClaims) {
+		tokenClaims.ApiKey = prefix
+	}
+}
+
+func WithClient(clientId string) Option {
+	return func(tokenClaims *TokenClaims) {
+		tokenClaims.ClientId = clientId
+		tokenClaims.Audience = []string{clientId}
+	}
+}
+
+// IsDelegated reports whether the token acts for the subject with limited rights: the token of an api key, of
+// a service account, of an OAuth client or limited to scopes
+func (c *TokenClaims) IsDelegated() bool {
+	return c.ApiKey != "" || c.SubjectType != "" || c.ClientId != "" || c.Scope != ""
+}
+
+// HasScope reports whether the scope is one of the scopes of the token
+func (c *TokenClaims) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+func CreateToken(userId *uuid.UUID, deviceCode string, tokenType string, lifetime time.Duration, signingKey *SigningKey, options ...Option) (string, *TokenClaims, error) {
 	tokenId := uuid.New()
 	now := time.Now()
 	tokenClaims := TokenClaims{
@@ -32,17 +121,44 @@ func CreateToken(userId *uuid.UUID, deviceCode string, tokenType string, lifetim
 		userId,
 		deviceCode,
 		tokenType,
+		nil,
+		nil,
+		nil,
+		false,
+		nil,
+		"",
+		"",
+		"",
+		"",
 		jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
 			NotBefore: jwt.NewNumericDate(now),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
+	for _, option := range options {
+		option(&tokenClaims)
+	}
 	tokenJwt := jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims)
-	tokenString, err := tokenJwt.SignedString(privateKey)
+	tokenJwt.Header["kid"] = signingKey.Id
+	tokenString, err := tokenJwt.SignedString(signingKey.PrivateKey)
 	if err != nil {
 		return "", nil, err
 	}
 	return tokenString, &tokenClaims, nil
 
 }
+
+// ParseToken verifies the token with the key of its kid header and returns the claims and the kid
+func ParseToken(tokenString string, keyFunc KeyFunc) (*TokenClaims, string, error) {
+	tokenClaims := new(TokenClaims)
+	var keyId string
+	_, err := jwt.ParseWithClaims(tokenString, tokenClaims, func(token *jwt.Token) (any, error) {
+		keyId, _ = token.Header["kid"].(string)
+		return keyFunc(keyId)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	if err != nil {
+		return nil, "", err
+	}
+	return tokenClaims, keyId, nil
+}
@@ -0,0 +1,96 @@
+// Package password checks the passwords against the password policy
+package password
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation is one failed rule of the policy
+type Violation struct {
+	Rule        string
+	Description string
+}
+
+type Policy struct {
+	MinLength     int
+	MaxLength     int
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// the password must not contain the login
+	DisallowLogin bool
+	// lowercased common passwords
+	CommonPasswords map[string]struct{}
+}
+
+// LoadCommonPasswords reads the list with one password per line. Empty lines and lines starting with # are skipped
+func LoadCommonPasswords(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	commonPasswords := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		commonPasswords[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return commonPasswords, nil
+}
+
+// Validate returns all the rules the password fails, so that they can be shown at once
+func (p *Policy) Validate(password string, login string) []Violation {
+	var violations []Violation
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{"min_length", fmt.Sprintf("password must be at least %d characters long", p.MinLength)})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{"max_length", fmt.Sprintf("password must be at most %d characters long", p.MaxLength)})
+	}
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, Violation{"lower", "password must contain a lowercase letter"})
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, Violation{"upper", "password must contain an uppercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{"digit", "password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{"symbol", "password must contain a symbol"})
+	}
+	if p.DisallowLogin && login != "" && strings.Contains(strings.ToLower(password), strings.ToLower(login)) {
+		violations = append(violations, Violation{"login", "password must not contain the login"})
+	}
+	if _, ok := p.CommonPasswords[strings.ToLower(password)]; ok {
+		violations = append(violations, Violation{"common", "password is too common"})
+	}
+	return violations
+}
@@ -0,0 +1,79 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func rules(violations []Violation) []string {
+	var names []string
+	for _, violation := range violations {
+		names = append(names, violation.Rule)
+	}
+	return names
+}
+
+func TestValidate(t *testing.T) {
+	policy := &Policy{
+		MinLength:       8,
+		MaxLength:       16,
+		RequireLower:    true,
+		RequireUpper:    true,
+		RequireDigit:    true,
+		RequireSymbol:   true,
+		DisallowLogin:   true,
+		CommonPasswords: map[string]struct{}{"passw0rd!a": {}},
+	}
+	tests := []struct {
+		name     string
+		password string
+		login    string
+		want     []string
+	}{
+		{"valid", "Correct-h0rse", "alice", nil},
+		{"too short", "Ab1!", "alice", []string{"min_length"}},
+		{"too long", "Correct-h0rse-battery", "alice", []string{"max_length"}},
+		{"length in runes", "Äöü1!äöü", "alice", nil},
+		{"no lowercase", "CORRECT-H0RSE", "alice", []string{"lower"}},
+		{"no uppercase", "correct-h0rse", "alice", []string{"upper"}},
+		{"no digit", "Correct-horse", "alice", []string{"digit"}},
+		{"no symbol", "Correcth0rse", "alice", []string{"symbol"}},
+		{"contains the login", "Alice-h0rse", "alice", []string{"login"}},
+		{"common", "Passw0rd!A", "alice", []string{"common"}},
+		{"all at once", "abc", "abc", []string{"min_length", "upper", "digit", "symbol", "login"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules(policy.Validate(tt.password, tt.login)); !slices.Equal(got, tt.want) {
+				t.Errorf("Validate(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateEmptyPolicy(t *testing.T) {
+	if violations := new(Policy).Validate("", ""); len(violations) != 0 {
+		t.Errorf("Validate() = %v, want no violations", violations)
+	}
+}
+
+func TestLoadCommonPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "common.txt")
+	if err := os.WriteFile(path, []byte("# the list\nPassword\n\n  qwerty  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	commonPasswords, err := LoadCommonPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commonPasswords) != 2 {
+		t.Errorf("LoadCommonPasswords() = %v, want 2 passwords", commonPasswords)
+	}
+	for _, password := range []string{"password", "qwerty"} {
+		if _, ok := commonPasswords[password]; !ok {
+			t.Errorf("LoadCommonPasswords() has no %q", password)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package rebac parses the namespace configuration of the relation tuples.
+// A namespace lists its relations, a relation may be rewritten as a union of usersets:
+//
+//	namespace doc {
+//	    relation parent
+//	    relation owner
+//	    relation editor = this | owner
+//	    relation viewer = this | editor | parent->viewer
+//	}
+//
+// "this" is the subjects of the tuples with the relation, "owner" is the computed userset of another relation
+// of the same object, "parent->viewer" is the tuple to userset: the viewers of the objects the parent tuples point to.
+// A relation without the rewrite is "this"
+package rebac
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type UsersetType int
+
+const (
+	This UsersetType = iota
+	ComputedUserset
+	TupleToUserset
+)
+
+// Userset is one member of the union of the relation rewrite
+type Userset struct {
+	Type UsersetType
+	// the relation of the computed userset or the target relation of the tuple to userset
+	Relation string
+	// the relation of the tuples that point to the other objects, for the tuple to userset
+	Tupleset string
+}
+
+func (u *Userset) String() string {
+	switch u.Type {
+	case ComputedUserset:
+		return u.Relation
+	case TupleToUserset:
+		return u.Tupleset + "->" + u.Relation
+	}
+	return "this"
+}
+
+type Relation struct {
+	Name    string
+	Rewrite []Userset
+}
+
+// Direct reports whether tuples can be written with the relation
+func (r *Relation) Direct() bool {
+	for _, userset := range r.Rewrite {
+		if userset.Type == This {
+			return true
+		}
+	}
+	return false
+}
+
+type Namespace struct {
+	Name      string
+	Relations map[string]*Relation
+}
+
+var nameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ValidName reports whether the name can be used for a namespace or a relation
+func ValidName(name string) bool {
+	return nameRegexp.MatchString(name)
+}
+
+func parseRewrite(expr string) ([]Userset, error) {
+	var rewrite []Userset
+	for _, part := range strings.Split(expr, "|") {
+		part = strings.TrimSpace(part)
+		if part == "this" {
+			rewrite = append(rewrite, Userset{Type: This})
+			continue
+		}
+		if tupleset, relation, ok := strings.Cut(part, "->"); ok {
+			tupleset, relation = strings.TrimSpace(tupleset), strings.TrimSpace(relation)
+			if !ValidName(tupleset) || !ValidName(relation) {
+				return nil, fmt.Errorf("invalid tuple to userset %q", part)
+			}
+			rewrite = append(rewrite, Userset{Type: TupleToUserset, Relation: relation, Tupleset: tupleset})
+			continue
+		}
+		if !ValidName(part) {
+			return nil, fmt.Errorf("invalid userset %q", part)
+		}
+		rewrite = append(rewrite, Userset{Type: ComputedUserset, Relation: part})
+	}
+	return rewrite, nil
+}
+
+// Parse reads the namespace configuration. Empty lines and lines starting with # are skipped
+func Parse(r io.Reader) (map[string]*Namespace, error) {
+	namespaces := make(map[string]*Namespace)
+	var namespace *Namespace
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "namespace "):
+			if namespace != nil {
+				return nil, fmt.Errorf("line %d: namespace %s is not closed", lineNumber, namespace.Name)
+			}
+			name, ok := strings.CutSuffix(strings.TrimSpace(strings.TrimPrefix(line, "namespace ")), "{")
+			name = strings.TrimSpace(name)
+			if !ok || !ValidName(name) {
+				return nil, fmt.Errorf("line %d: namespace <name> { is expected", lineNumber)
+			}
+			if _, ok := namespaces[name]; ok {
+				return nil, fmt.Errorf("line %d: namespace %s is defined twice", lineNumber, name)
+			}
+			namespace = &Namespace{Name: name, Relations: make(map[string]*Relation)}
+			namespaces[name] = namespace
+		case line == "}":
+			if namespace == nil {
+				return nil, fmt.Errorf("line %d: unexpected }", lineNumber)
+			}
+			namespace = nil
+		case strings.HasPrefix(line, "relation "):
+			if namespace == nil {
+				return nil, fmt.Errorf("line %d: relation outside of a namespace", lineNumber)
+			}
+			name, expr, hasRewrite := strings.Cut(strings.TrimPrefix(line, "relation "), "=")
+			name = strings.TrimSpace(name)
+			if !ValidName(name) {
+				return nil, fmt.Errorf("line %d: invalid relation name %q", lineNumber, name)
+			}
+			if _, ok := namespace.Relations[name]; ok {
+				return nil, fmt.Errorf("line %d: relation %s is defined twice", lineNumber, name)
+			}
+			relation := &Relation{Name: name, Rewrite: []Userset{{Type: This}}}
+			if hasRewrite {
+				rewrite, err := parseRewrite(expr)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+				}
+				relation.Rewrite = rewrite
+			}
+			namespace.Relations[name] = relation
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", lineNumber, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if namespace != nil {
+		return nil, fmt.Errorf("namespace %s is not closed", namespace.Name)
+	}
+	// the relations of other namespaces are resolved at the check, the ones of the same namespace must exist
+	for _, namespace := range namespaces {
+		for _, relation := range namespace.Relations {
+			for _, userset := range relation.Rewrite {
+				name := userset.Relation
+				if userset.Type == TupleToUserset {
+					name = userset.Tupleset
+				}
+				if userset.Type == This {
+					continue
+				}
+				if _, ok := namespace.Relations[name]; !ok {
+					return nil, fmt.Errorf("relation %s#%s refers to the undefined relation %s", namespace.Name, relation.Name, name)
+				}
+				if userset.Type == ComputedUserset && name == relation.Name {
+					return nil, fmt.Errorf("relation %s#%s refers to itself", namespace.Name, relation.Name)
+				}
+			}
+		}
+	}
+	return namespaces, nil
+}
+
+// Load reads the namespace configuration from the file
+func Load(path string) (map[string]*Namespace, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
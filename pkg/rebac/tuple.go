@@ -0,0 +1,70 @@
+package rebac
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Object is written as namespace:id
+type Object struct {
+	Namespace string
+	Id        string
+}
+
+func (o Object) String() string {
+	return o.Namespace + ":" + o.Id
+}
+
+// Subject is a single subject written as namespace:id, or the userset namespace:id#relation
+type Subject struct {
+	Namespace string
+	Id        string
+	// empty for a single subject
+	Relation string
+}
+
+func (s Subject) String() string {
+	if s.Relation == "" {
+		return s.Namespace + ":" + s.Id
+	}
+	return s.Namespace + ":" + s.Id + "#" + s.Relation
+}
+
+// Object returns the object of the userset
+func (s Subject) Object() Object {
+	return Object{Namespace: s.Namespace, Id: s.Id}
+}
+
+func validId(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "#@ \t\r\n")
+}
+
+// ParseObject parses namespace:id, the id may contain colons
+func ParseObject(s string) (Object, error) {
+	namespace, id, ok := strings.Cut(s, ":")
+	if !ok || !ValidName(namespace) || !validId(id) {
+		return Object{}, fmt.Errorf("invalid object %q, namespace:id is expected", s)
+	}
+	return Object{Namespace: namespace, Id: id}, nil
+}
+
+// ParseSubject parses namespace:id or namespace:id#relation
+func ParseSubject(s string) (Subject, error) {
+	objectValue, relation, hasRelation := strings.Cut(s, "#")
+	object, err := ParseObject(objectValue)
+	if err != nil || (hasRelation && !ValidName(relation)) {
+		return Subject{}, fmt.Errorf("invalid subject %q, namespace:id or namespace:id#relation is expected", s)
+	}
+	return Subject{Namespace: object.Namespace, Id: object.Id, Relation: relation}, nil
+}
+
+// Tuple says that the subject has the relation to the object
+type Tuple struct {
+	Object   Object
+	Relation string
+	Subject  Subject
+}
+
+func (t Tuple) String() string {
+	return t.Object.String() + "#" + t.Relation + "@" + t.Subject.String()
+}
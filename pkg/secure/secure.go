@@ -1,9 +1,13 @@
 package secure
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
@@ -25,9 +29,69 @@ func LoadPrivateKey(privateKeyPath string) (*rsa.PrivateKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	return ParsePrivateKey(privateKeyByteArray)
+}
+func ParsePrivateKey(privateKeyByteArray []byte) (*rsa.PrivateKey, error) {
 	privateKeyPemBlock, _ := pem.Decode(privateKeyByteArray)
 	if privateKeyPemBlock == nil || privateKeyPemBlock.Type != "RSA PRIVATE KEY" {
 		return nil, errors.New("decoding error. The PEM block was not found or the type is not equal to RSA PRIVATE KEY")
 	}
 	return x509.ParsePKCS1PrivateKey(privateKeyPemBlock.Bytes)
 }
+
+// GeneratePrivateKey returns a new RSA key and its PEM encoding in the form LoadPrivateKey reads
+func GeneratePrivateKey(bits int) (*rsa.PrivateKey, []byte, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privateKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), nil
+}
+
+// GenerateToken returns size random bytes encoded with the url safe base64
+func GenerateToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AES-256-GCM, the nonce is stored in front of the ciphertext
+func Encrypt(text string, key []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(text), nil)), nil
+}
+func Decrypt(ciphertext string, key []byte) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("decryption error. The ciphertext is too short")
+	}
+	text, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}